@@ -0,0 +1,312 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Shota0616/go-sns/bookmarks"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/likes"
+	"github.com/Shota0616/go-sns/lists"
+	"github.com/Shota0616/go-sns/messaging"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/search"
+	"github.com/Shota0616/go-sns/suggestions"
+	"github.com/Shota0616/go-sns/timeline"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// 削除を依頼してから実際に削除するまでの期間。この間にログインすると削除を取り消す
+	DeletionGracePeriod = 30 * 24 * time.Hour
+	// 削除したユーザーのユーザー名を他のユーザーが使えるようになるまでの期間（なりすましを防ぐため）
+	UsernameQuarantine = 90 * 24 * time.Hour
+)
+
+// 複数のインスタンスで同時に実行しないためのロック
+const purgeLockKey = "accounts:purge:lock"
+
+// アカウントの削除を予約する。猶予期間の間はアカウントを停止し、すべてのセッションを終了させる
+func ScheduleDeletion(ctx context.Context, userID uint, password string) (time.Time, error) {
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return time.Time{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return time.Time{}, ErrIncorrectPass
+	}
+
+	now := time.Now()
+	err := config.DB.Model(&user).Updates(map[string]interface{}{
+		"is_active":             false,
+		"deletion_scheduled_at": now,
+	}).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	config.RDB.Del(ctx, pendingKey(userID), attemptsKey(userID))
+	if err := RevokeSessions(ctx, userID); err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(DeletionGracePeriod), nil
+}
+
+// 猶予期間中のアカウントの削除を取り消して、アカウントを元に戻す
+func CancelDeletion(user *models.User) error {
+	result := config.DB.Model(&models.User{}).
+		Where("id = ? AND deletion_scheduled_at IS NOT NULL AND purged_at IS NULL", user.ID).
+		Updates(map[string]interface{}{"is_active": true, "deletion_scheduled_at": nil})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		user.IsActive = true
+		user.DeletionScheduledAt = nil
+	}
+	return nil
+}
+
+// 定期的に猶予期間の過ぎたアカウントを削除し、隔離期間の過ぎたユーザー名を解放する
+func StartPurger(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx := context.Background()
+			ok, err := config.RDB.SetNX(ctx, purgeLockKey, 1, interval).Result()
+			if err != nil || !ok {
+				continue
+			}
+			PurgeExpired()
+			if err := releaseUsernames(); err != nil {
+				log.Printf("accounts: failed to release usernames: %v", err)
+			}
+		}
+	}()
+}
+
+// 猶予期間の過ぎたアカウントをすべて削除する
+func PurgeExpired() {
+	var ids []uint
+	err := config.DB.Model(&models.User{}).
+		Where("is_active = ? AND deletion_scheduled_at < ?", false, time.Now().Add(-DeletionGracePeriod)).
+		Pluck("id", &ids).Error
+	if err != nil {
+		log.Printf("accounts: failed to load accounts to purge: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if err := Purge(id); err != nil {
+			log.Printf("accounts: failed to purge user %d: %v", id, err)
+		}
+	}
+}
+
+// アカウントのデータをすべて削除する。途中で失敗しても、もう一度実行すれば続きから削除できる
+func Purge(userID uint) error {
+	var user models.User
+	err := config.DB.Where("id = ? AND is_active = ? AND deletion_scheduled_at IS NOT NULL", userID, false).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// 削除を始めた後はログインしても取り消せない
+	if user.PurgedAt == nil {
+		if err := config.DB.Model(&user).Update("purged_at", time.Now()).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, step := range []func(uint) error{
+		purgePosts,
+		purgeLikes,
+		purgeFollows,
+		purgeRelations,
+		purgeFilters,
+		purgeNotifications,
+		messaging.RemoveUser,
+		suggestions.RemoveUser,
+		bookmarks.RemoveUser,
+		lists.RemoveUser,
+		purgeMedia,
+		purgeExports,
+	} {
+		if err := step(userID); err != nil {
+			return err
+		}
+	}
+
+	// メールアドレスはすぐに解放し、ユーザー名は隔離期間が過ぎるまで残す
+	ctx := context.Background()
+	config.RDB.Del(ctx, timeline.HomeKey(userID), timeline.UserKey(userID), timeline.HomeRepostsKey(userID))
+	if err := search.RemoveUser(ctx, userID); err != nil {
+		return err
+	}
+	return config.DB.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"email":                    fmt.Sprintf("deleted_%d@invalid", userID),
+		"password":                 "",
+		"display_name":             "",
+		"bio":                      "",
+		"location":                 "",
+		"links":                    nil,
+		"avatar_media_id":          nil,
+		"header_media_id":          nil,
+		"birthday":                 nil,
+		"language":                 "",
+		"notification_preferences": nil,
+		"deleted_at":               time.Now(),
+	}).Error
+}
+
+// 投稿の本文を消して削除する。リポストは取り消し、他のユーザーによるリポストも取り除く
+func purgePosts(userID uint) error {
+	var reposts []models.Post
+	if err := config.DB.Unscoped().Where("user_id = ? AND repost_of_id IS NOT NULL", userID).Find(&reposts).Error; err != nil {
+		return err
+	}
+	for _, r := range reposts {
+		err := config.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Delete(&r).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Model(&models.Post{}).Where("id = ?", *r.RepostOfID).
+				UpdateColumn("reposts_count", gorm.Expr("reposts_count - 1")).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	own := config.DB.Unscoped().Model(&models.Post{}).Select("id").Where("user_id = ?", userID)
+	var others []models.Post
+	if err := config.DB.Where("repost_of_id IN (?)", own).Find(&others).Error; err != nil {
+		return err
+	}
+	for _, r := range others {
+		if err := config.DB.Unscoped().Delete(&r).Error; err != nil {
+			return err
+		}
+		timeline.Unpublish(&r)
+	}
+
+	if err := config.DB.Where("post_id IN (?) OR user_id = ?", own, userID).Delete(&models.Mention{}).Error; err != nil {
+		return err
+	}
+	if err := config.DB.Where("post_id IN (?)", own).Delete(&models.PostHashtag{}).Error; err != nil {
+		return err
+	}
+	if err := config.DB.Where("post_id IN (?)", own).Delete(&models.Like{}).Error; err != nil {
+		return err
+	}
+	if err := config.DB.Where("user_id = ?", userID).Delete(&models.SearchDocument{}).Error; err != nil {
+		return err
+	}
+	// 返信や引用から参照されているため行は残し、本文だけを消す
+	return config.DB.Unscoped().Model(&models.Post{}).Where("user_id = ?", userID).
+		Updates(map[string]interface{}{"body": "", "deleted_at": gorm.Expr("COALESCE(deleted_at, ?)", time.Now())}).Error
+}
+
+// いいねを取り消す（いいね数も減らす）
+func purgeLikes(userID uint) error {
+	var postIDs []uint
+	if err := config.DB.Model(&models.Like{}).Where("user_id = ?", userID).Pluck("post_id", &postIDs).Error; err != nil {
+		return err
+	}
+	for _, id := range postIDs {
+		if _, err := likes.Unlike(userID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// フォロー・フォロワーの関係を削除し、相手のフォロー数・フォロワー数を減らす
+func purgeFollows(userID uint) error {
+	var demoted []uint
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		following := tx.Model(&models.Follow{}).Select("followee_id").Where("follower_id = ?", userID)
+		// フォロワーが減ることでセレブでなくなる相手（減らす前にちょうどしきい値の相手）
+		if err := tx.Model(&models.User{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN (?) AND followers_count = ?", following, timeline.CelebrityThreshold).
+			Pluck("id", &demoted).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id IN (?)", following).
+			UpdateColumn("followers_count", gorm.Expr("followers_count - 1")).Error; err != nil {
+			return err
+		}
+		followers := tx.Model(&models.Follow{}).Select("follower_id").Where("followee_id = ?", userID)
+		if err := tx.Model(&models.User{}).Where("id IN (?)", followers).
+			UpdateColumn("following_count", gorm.Expr("following_count - 1")).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("follower_id = ? OR followee_id = ?", userID, userID).Delete(&models.Follow{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"followers_count": 0, "following_count": 0}).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range demoted {
+		go timeline.OnCelebrityEnded(id)
+	}
+	return nil
+}
+
+// ブロック・ミュートを削除する（した側・された側の両方）
+func purgeRelations(userID uint) error {
+	if err := config.DB.Where("blocker_id = ? OR blocked_id = ?", userID, userID).Delete(&models.Block{}).Error; err != nil {
+		return err
+	}
+	return config.DB.Where("muter_id = ? OR muted_id = ?", userID, userID).Delete(&models.Mute{}).Error
+}
+
+// キーワードフィルターを削除する
+func purgeFilters(userID uint) error {
+	return config.DB.Where("user_id = ?", userID).Delete(&models.KeywordFilter{}).Error
+}
+
+// 受け取った通知と、他のユーザーに送った通知を削除する
+func purgeNotifications(userID uint) error {
+	return config.DB.Where("user_id = ? OR actor_id = ?", userID, userID).Delete(&models.Notification{}).Error
+}
+
+// アップロードした画像を削除する。ファイルはmediaのガベージコレクションで削除される
+func purgeMedia(userID uint) error {
+	if err := config.DB.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"avatar_media_id": nil, "header_media_id": nil}).Error; err != nil {
+		return err
+	}
+	return config.DB.Where("user_id = ?", userID).Delete(&models.Media{}).Error
+}
+
+// 作成済みのデータのエクスポートを削除する
+func purgeExports(userID uint) error {
+	var list []models.DataExport
+	if err := config.DB.Where("user_id = ?", userID).Find(&list).Error; err != nil {
+		return err
+	}
+	for _, e := range list {
+		if e.Key != "" {
+			if err := config.Storage.Delete(context.Background(), e.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return config.DB.Where("user_id = ?", userID).Delete(&models.DataExport{}).Error
+}
+
+// 隔離期間の過ぎた削除済みユーザーのユーザー名を解放する
+func releaseUsernames() error {
+	return config.DB.Unscoped().Model(&models.User{}).
+		Where("purged_at < ? AND username <> CONCAT('deleted_', id)", time.Now().Add(-UsernameQuarantine)).
+		Update("username", gorm.Expr("CONCAT('deleted_', id)")).Error
+}
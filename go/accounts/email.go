@@ -0,0 +1,295 @@
+package accounts
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shota0616/go-sns/auth"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// 確認コードの有効期限
+	EmailChangeTTL = 15 * time.Minute
+	// 確認コードを間違えられる回数
+	MaxEmailChangeAttempts = 5
+	// 元のメールアドレスに送るリンクで変更を取り消せる期間
+	EmailRevertWindow = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidEmail     = errors.New("invalid email address")
+	ErrSameEmail        = errors.New("the new email address is the current one")
+	ErrEmailTaken       = errors.New("the email address is already registered")
+	ErrIncorrectPass    = errors.New("incorrect password")
+	ErrInvalidCode      = errors.New("invalid or expired confirmation code")
+	ErrTooManyAttempts  = errors.New("too many attempts")
+	ErrInvalidRevert    = errors.New("invalid or expired revert token")
+	ErrNoPendingRequest = errors.New("no pending email change")
+)
+
+// 確認待ちの変更
+type pendingChange struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+// 変更を取り消すための記録
+type revertRecord struct {
+	UserID   uint   `json:"user_id"`
+	OldEmail string `json:"old_email"`
+	NewEmail string `json:"new_email"`
+}
+
+func pendingKey(userID uint) string {
+	return fmt.Sprintf("email_change:%d", userID)
+}
+
+// 確認コードを入力した回数。確認待ちの変更と同時に期限切れになる
+func attemptsKey(userID uint) string {
+	return fmt.Sprintf("email_change:%d:attempts", userID)
+}
+
+func revertKey(token string) string {
+	return "email_revert:" + token
+}
+
+func localize(id string, data map[string]interface{}) string {
+	return config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: id, TemplateData: data})
+}
+
+// 6桁の確認コードを生成する
+func confirmationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// メールアドレスが他のユーザー（削除済みを含む）に使われているかどうか
+func emailTaken(email string, userID uint) (bool, error) {
+	var count int64
+	err := config.DB.Unscoped().Model(&models.User{}).Where("email = ? AND id <> ?", email, userID).Count(&count).Error
+	return count > 0, err
+}
+
+func isDuplicate(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Duplicate entry")
+}
+
+// メールアドレスの変更を受け付け、新しいアドレスに確認コードを送る。
+// 変更は確認コードが入力されるまで反映しない
+func RequestEmailChange(ctx context.Context, userID uint, newEmail string, password string) error {
+	addr, err := mail.ParseAddress(strings.TrimSpace(newEmail))
+	if err != nil || addr.Name != "" {
+		return ErrInvalidEmail
+	}
+	newEmail = addr.Address
+
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return ErrIncorrectPass
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return ErrSameEmail
+	}
+	taken, err := emailTaken(newEmail, userID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+
+	code, err := confirmationCode()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(pendingChange{Email: newEmail, Code: code})
+	if err != nil {
+		return err
+	}
+	// 新しい変更の依頼で前の依頼を置き換え、入力した回数も数え直す
+	_, err = config.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, pendingKey(userID), data, EmailChangeTTL)
+		pipe.Del(ctx, attemptsKey(userID))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	body := localize("email_change_code_body", map[string]interface{}{
+		"Username": user.Username,
+		"Code":     code,
+		"Minutes":  int(EmailChangeTTL.Minutes()),
+	})
+	return auth.SendEmail(newEmail, localize("email_change_code_subject", nil), body)
+}
+
+// 確認待ちの変更がある場合だけ入力した回数を増やし、確認待ちの変更と同じ期限を付ける。
+// 確認待ちの変更がなければ-1を返す
+var countAttemptScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl <= 0 then
+	return -1
+end
+local n = redis.call("INCR", KEYS[2])
+redis.call("PEXPIRE", KEYS[2], ttl)
+return n
+`)
+
+// 確認待ちの変更を取得する。同時に入力されても上限を超えて試せないよう、
+// コードを照合する前に入力した回数を数える
+func checkCode(ctx context.Context, userID uint, code string) (*pendingChange, error) {
+	key := pendingKey(userID)
+	attempts, err := countAttemptScript.Run(ctx, config.RDB, []string{key, attemptsKey(userID)}).Int()
+	if err != nil {
+		return nil, err
+	}
+	if attempts < 0 {
+		return nil, ErrNoPendingRequest
+	}
+	if attempts > MaxEmailChangeAttempts {
+		config.RDB.Del(ctx, key, attemptsKey(userID))
+		return nil, ErrTooManyAttempts
+	}
+
+	data, err := config.RDB.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNoPendingRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pending pendingChange
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(pending.Code), []byte(code)) == 1 {
+		return &pending, nil
+	}
+	if attempts == MaxEmailChangeAttempts {
+		config.RDB.Del(ctx, key, attemptsKey(userID))
+		return nil, ErrTooManyAttempts
+	}
+	return nil, ErrInvalidCode
+}
+
+// 確認コードを検証してメールアドレスを変更する。
+// 元のアドレスには、一定期間変更を取り消せるリンクを付けたお知らせを送る
+func ConfirmEmailChange(ctx context.Context, userID uint, code string) (string, error) {
+	pending, err := checkCode(ctx, userID, strings.TrimSpace(code))
+	if err != nil {
+		return "", err
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return "", err
+	}
+	oldEmail := user.Email
+
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	record, err := json.Marshal(revertRecord{UserID: userID, OldEmail: oldEmail, NewEmail: pending.Email})
+	if err != nil {
+		return "", err
+	}
+	if err := config.RDB.Set(ctx, revertKey(token), record, EmailRevertWindow).Err(); err != nil {
+		return "", err
+	}
+
+	err = config.DB.Model(&user).Update("email", pending.Email).Error
+	if isDuplicate(err) {
+		config.RDB.Del(ctx, revertKey(token))
+		return "", ErrEmailTaken
+	}
+	if err != nil {
+		config.RDB.Del(ctx, revertKey(token))
+		return "", err
+	}
+	config.RDB.Del(ctx, pendingKey(userID), attemptsKey(userID))
+
+	// お知らせが届かなくても変更は済んでいるため、エラーはログに残すだけにする
+	body := localize("email_change_notice_body", map[string]interface{}{
+		"Username": user.Username,
+		"NewEmail": pending.Email,
+		"URL":      fmt.Sprintf("%s/auth/revert-email?token=%s", os.Getenv("APP_URL"), token),
+		"Days":     int(EmailRevertWindow.Hours() / 24),
+	})
+	if err := auth.SendEmail(oldEmail, localize("email_change_notice_subject", nil), body); err != nil {
+		log.Printf("accounts: failed to send email change notice to user %d: %v", userID, err)
+	}
+	return pending.Email, nil
+}
+
+// 元のアドレスに送ったリンクから変更を取り消す。
+// アカウントを乗っ取られた場合を想定して、すべてのセッションも終了させる
+func RevertEmailChange(ctx context.Context, token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidRevert
+	}
+	data, err := config.RDB.Get(ctx, revertKey(token)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrInvalidRevert
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record revertRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, record.UserID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRevert
+	} else if err != nil {
+		return nil, err
+	}
+	err = config.DB.Model(&user).Update("email", record.OldEmail).Error
+	if isDuplicate(err) {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 取り消しは一度だけ使える。確認待ちの変更も取り消す
+	config.RDB.Del(ctx, revertKey(token), pendingKey(user.ID), attemptsKey(user.ID))
+	if err := RevokeSessions(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,52 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shota0616/go-sns/auth"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/go-redis/redis/v8"
+)
+
+// リフレッシュトークンの有効期限。これより古いログアウトの記録は不要になる
+const sessionLifetime = 7 * 24 * time.Hour
+
+func revokedKey(userID uint) string {
+	return fmt.Sprintf("sessions:revoked:%d", userID)
+}
+
+// ユーザーのすべてのセッションを終了させる。これより前に発行したトークンは使えなくなる。
+// 終了させた直後にログインし直せるよう、日時はナノ秒単位で記録する
+func RevokeSessions(ctx context.Context, userID uint) error {
+	return config.RDB.Set(ctx, revokedKey(userID), time.Now().UnixNano(), sessionLifetime).Err()
+}
+
+// トークンが終了させたセッションのものかどうか
+func IsRevoked(ctx context.Context, claims *auth.Claims) (bool, error) {
+	revokedAt, err := RevokedAt(ctx, claims.ID)
+	if err != nil || revokedAt == nil {
+		return false, err
+	}
+	// ナノ秒単位の発行日時のないトークンも無効になる
+	return claims.IssuedAtNano <= revokedAt.UnixNano(), nil
+}
+
+// すべてのセッションを終了させた日時。終了させたことがなければnilを返す
+func RevokedAt(ctx context.Context, userID uint) (*time.Time, error) {
+	val, err := config.RDB.Get(ctx, revokedKey(userID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	nsec, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	t := time.Unix(0, nsec)
+	return &t, nil
+}
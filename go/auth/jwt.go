@@ -16,18 +16,22 @@ var jwtrefreshKey = []byte(os.Getenv("JWT_REFRESH_SECRET"))
 // JWTのペイロードに含まれるクレーム(情報)を定義
 type Claims struct {
 	ID uint `json:"id"` // ユーザーID
+	IssuedAtNano int64 `json:"iat_ns"` // ナノ秒単位の発行日時（iatは秒単位のため、ログアウトと同じ秒に発行したトークンを区別するのに使う）
 	jwt.StandardClaims // 標準のクレーム(例: exp、iatなど)
 }
 
 // JWTトークンを生成する関数
 func GenerateJWT(id uint) (string, error) {
+	now := time.Now()
 	// トークンの有効期限を24時間後に設定
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := now.Add(24 * time.Hour)
 	// claimsオブジェクトを生成
 	claims := &Claims{
 		ID: id,
+		IssuedAtNano: now.UnixNano(), // 発行日時（ログアウトさせる際に、これより前のトークンを無効にする）
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(), // 有効期限
+			IssuedAt:  now.Unix(),            // 発行日時
 		},
 	}
 
@@ -60,12 +64,15 @@ func ValidateJWT(tokenStr string) (*Claims, error) {
 
 // リフレッシュトークンを生成する関数
 func GenerateRefreshToken(id uint) (string, error) {
+	now := time.Now()
 	// リフレッシュトークンの有効期限を7日後に設定
-	expirationTime := time.Now().Add(7 * 24 * time.Hour)
+	expirationTime := now.Add(7 * 24 * time.Hour)
 	claims := &Claims{
 		ID: id,
+		IssuedAtNano: now.UnixNano(),
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(), // 有効期限
+			IssuedAt:  now.Unix(),            // 発行日時
 		},
 	}
 
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ログイン以外の用途（配信停止リンクなど）に使う署名付きトークンのクレーム
+type PurposeClaims struct {
+	ID      uint   `json:"id"`
+	Purpose string `json:"purpose"`
+	jwt.StandardClaims
+}
+
+// 用途ごとに秘密鍵を分けることで、ログイン用のJWTとして使い回せないようにする
+func purposeKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// 用途を限定した署名付きトークンを生成する関数。
+// 一度だけ使えるトークンにできるよう、トークンごとにランダムなID（jti）を付ける
+func GeneratePurposeToken(purpose string, id uint, ttl time.Duration) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	claims := &PurposeClaims{
+		ID:      id,
+		Purpose: purpose,
+		StandardClaims: jwt.StandardClaims{
+			Id:        hex.EncodeToString(jti),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(purposeKey(purpose))
+}
+
+// 用途を限定した署名付きトークンを検証する関数
+func ValidatePurposeToken(purpose string, tokenStr string) (*PurposeClaims, error) {
+	claims := &PurposeClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return purposeKey(purpose), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.Purpose != purpose {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
@@ -0,0 +1,310 @@
+package bookmarks
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/Shota0616/go-sns/visibility"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// 1ユーザーが作れるコレクションの数
+	MaxCollections = 100
+	// コレクション名の最大文字数
+	MaxNameLength = 50
+)
+
+var (
+	ErrInvalidName        = errors.New("invalid collection name")
+	ErrNameTaken          = errors.New("collection name already used")
+	ErrTooManyCollections = errors.New("too many collections")
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrNotFound           = errors.New("bookmark not found")
+)
+
+func cleanName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > MaxNameLength {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
+
+// 同じ名前のコレクションがあればErrNameTakenを返す（excludeIDは名前を変えるコレクション自身）
+func checkName(userID uint, name string, excludeID uint) error {
+	var count int64
+	if err := config.DB.Model(&models.BookmarkCollection{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, excludeID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+// 自分のコレクションを取得する
+func collection(userID, id uint) (*models.BookmarkCollection, error) {
+	var c models.BookmarkCollection
+	err := config.DB.Where("id = ? AND user_id = ?", id, userID).First(&c).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrCollectionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// 保存先のコレクションを確認する。0またはnilはどのコレクションにも入れないことを表す
+func target(userID uint, collectionID *uint) (*uint, error) {
+	if collectionID == nil || *collectionID == 0 {
+		return nil, nil
+	}
+	c, err := collection(userID, *collectionID)
+	if err != nil {
+		return nil, err
+	}
+	return &c.ID, nil
+}
+
+// コレクションを作成する
+func CreateCollection(userID uint, name string) (*models.BookmarkCollection, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+	var count int64
+	if err := config.DB.Model(&models.BookmarkCollection{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= MaxCollections {
+		return nil, ErrTooManyCollections
+	}
+	if err := checkName(userID, name, 0); err != nil {
+		return nil, err
+	}
+	c := models.BookmarkCollection{UserID: userID, Name: name}
+	if err := config.DB.Create(&c).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// コレクションの名前を変更する
+func RenameCollection(userID, id uint, name string) (*models.BookmarkCollection, error) {
+	c, err := collection(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if name, err = cleanName(name); err != nil {
+		return nil, err
+	}
+	if err := checkName(userID, name, c.ID); err != nil {
+		return nil, err
+	}
+	if err := config.DB.Model(c).Update("name", name).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// コレクションを削除する。中のブックマークは消さず、どのコレクションにも入っていない状態に戻す
+func DeleteCollection(userID, id uint) error {
+	c, err := collection(userID, id)
+	if err != nil {
+		return err
+	}
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Bookmark{}).Where("collection_id = ?", c.ID).Update("collection_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(c).Error
+	})
+}
+
+// コレクションの一覧と、コレクションごとのブックマークの数を返す
+func Collections(userID uint) ([]models.BookmarkCollection, map[uint]int64, error) {
+	var list []models.BookmarkCollection
+	if err := config.DB.Where("user_id = ?", userID).Order("name").Find(&list).Error; err != nil {
+		return nil, nil, err
+	}
+	var rows []struct {
+		CollectionID uint
+		Count        int64
+	}
+	if err := config.DB.Model(&models.Bookmark{}).
+		Select("collection_id, COUNT(*) AS count").
+		Where("user_id = ? AND collection_id IS NOT NULL", userID).
+		Group("collection_id").
+		Scan(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+	counts := make(map[uint]int64, len(rows))
+	for _, r := range rows {
+		counts[r.CollectionID] = r.Count
+	}
+	return list, counts, nil
+}
+
+// 投稿をブックマークする。ブックマーク済みの場合は指定したコレクションに移す
+func Add(userID, postID uint, collectionID *uint) (*models.Bookmark, error) {
+	to, err := target(userID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	b := models.Bookmark{UserID: userID, PostID: postID, CollectionID: to}
+	err = config.DB.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"collection_id"})}).Create(&b).Error
+	if err != nil {
+		return nil, err
+	}
+	// 既存の行を更新した場合はIDと作成日時が入らないため読み直す
+	if err := config.DB.Where("user_id = ? AND post_id = ?", userID, postID).First(&b).Error; err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ブックマークを別のコレクションに移す
+func Move(userID, postID uint, collectionID *uint) (*models.Bookmark, error) {
+	to, err := target(userID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	var b models.Bookmark
+	err = config.DB.Where("user_id = ? AND post_id = ?", userID, postID).First(&b).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := config.DB.Model(&b).Update("collection_id", to).Error; err != nil {
+		return nil, err
+	}
+	b.CollectionID = to
+	return &b, nil
+}
+
+// ブックマークを解除する。ブックマークしていなくてもエラーにしない
+func Remove(userID, postID uint) error {
+	return config.DB.Where("user_id = ? AND post_id = ?", userID, postID).Delete(&models.Bookmark{}).Error
+}
+
+// 閲覧者がブックマークしている投稿
+func BookmarkedBy(userID uint, postIDs []uint) map[uint]bool {
+	bookmarked := make(map[uint]bool)
+	if userID == 0 || len(postIDs) == 0 {
+		return bookmarked
+	}
+	var ids []uint
+	config.DB.Model(&models.Bookmark{}).Where("user_id = ? AND post_id IN ?", userID, postIDs).Pluck("post_id", &ids)
+	for _, id := range ids {
+		bookmarked[id] = true
+	}
+	return bookmarked
+}
+
+// ブックマークと投稿の組
+type Entry struct {
+	Bookmark models.Bookmark
+	Post     models.Post
+}
+
+// ブックマークを新しい順に返す。collectionIDを指定するとそのコレクションだけを返す。
+// 削除された投稿や見えなくなった投稿（ブロックした・された相手の投稿など）のブックマークはここで取り除く。
+// 次のページのカーソル（ブックマークのID）は取り除く前の一覧で決める
+func Page(userID uint, collectionID *uint, maxID uint, limit int) ([]Entry, uint, error) {
+	query := config.DB.Where("user_id = ?", userID)
+	if collectionID != nil {
+		query = query.Where("collection_id = ?", *collectionID)
+	}
+	if maxID > 0 {
+		query = query.Where("id < ?", maxID)
+	}
+	var list []models.Bookmark
+	if err := query.Order("id DESC").Limit(limit).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	var next uint
+	if len(list) == limit {
+		next = list[len(list)-1].ID
+	}
+
+	postIDs := make([]uint, len(list))
+	for i, b := range list {
+		postIDs[i] = b.PostID
+	}
+	posts, err := timeline.Hydrate(postIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	byID := make(map[uint]models.Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+
+	viewer, err := visibility.For(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := make([]Entry, 0, len(list))
+	var stale, missing []uint
+	for _, b := range list {
+		p, ok := byID[b.PostID]
+		switch {
+		case !ok:
+			missing = append(missing, b.PostID)
+		case viewer.Check(&p):
+			entries = append(entries, Entry{Bookmark: b, Post: p})
+		default:
+			stale = append(stale, b.PostID)
+		}
+	}
+	// 見つからない投稿のうち、削除されたものだけを取り除く（停止中のユーザーの投稿は再開に備えて残す）
+	if len(missing) > 0 {
+		var existing []uint
+		if err := config.DB.Model(&models.Post{}).Where("id IN ?", missing).Pluck("id", &existing).Error; err != nil {
+			return nil, 0, err
+		}
+		exists := make(map[uint]bool, len(existing))
+		for _, id := range existing {
+			exists[id] = true
+		}
+		for _, id := range missing {
+			if !exists[id] {
+				stale = append(stale, id)
+			}
+		}
+	}
+	if len(stale) > 0 {
+		if err := config.DB.Where("user_id = ? AND post_id IN ?", userID, stale).Delete(&models.Bookmark{}).Error; err != nil {
+			return nil, 0, err
+		}
+	}
+	return entries, next, nil
+}
+
+// 削除された投稿のブックマークを全員分取り除く
+func RemovePost(postID uint) error {
+	return config.DB.Where("post_id = ?", postID).Delete(&models.Bookmark{}).Error
+}
+
+// 削除されたユーザーのブックマークとコレクション、ユーザーの投稿に付いたブックマークを削除する
+func RemoveUser(userID uint) error {
+	if err := config.DB.Where("user_id = ?", userID).Delete(&models.Bookmark{}).Error; err != nil {
+		return err
+	}
+	if err := config.DB.Where("post_id IN (?)", config.DB.Unscoped().Model(&models.Post{}).Select("id").Where("user_id = ?", userID)).
+		Delete(&models.Bookmark{}).Error; err != nil {
+		return err
+	}
+	return config.DB.Where("user_id = ?", userID).Delete(&models.BookmarkCollection{}).Error
+}
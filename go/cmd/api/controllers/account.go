@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Shota0616/go-sns/accounts"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// アカウントの操作のエラーをレスポンスに変換する
+func accountError(c *gin.Context, err error) {
+	status, id := http.StatusInternalServerError, "user_update_failed"
+	switch {
+	case errors.Is(err, accounts.ErrInvalidEmail):
+		status, id = http.StatusBadRequest, "email_invalid"
+	case errors.Is(err, accounts.ErrSameEmail):
+		status, id = http.StatusBadRequest, "email_unchanged"
+	case errors.Is(err, accounts.ErrEmailTaken):
+		status, id = http.StatusConflict, "email_already_registered"
+	case errors.Is(err, accounts.ErrIncorrectPass):
+		status, id = http.StatusUnauthorized, "password_incorrect"
+	case errors.Is(err, accounts.ErrNoPendingRequest), errors.Is(err, accounts.ErrInvalidCode):
+		status, id = http.StatusBadRequest, "invalid_or_expired_verification_code"
+	case errors.Is(err, accounts.ErrTooManyAttempts):
+		status, id = http.StatusTooManyRequests, "email_change_too_many_attempts"
+	case errors.Is(err, accounts.ErrInvalidRevert):
+		status, id = http.StatusBadRequest, "email_revert_link_invalid"
+	}
+	c.JSON(status, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: id})})
+}
+
+// メールアドレスの変更を依頼する関数。新しいアドレスに確認コードを送る
+func RequestEmailChange(c *gin.Context) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := accounts.RequestEmailChange(c.Request.Context(), c.GetUint("id"), input.Email, input.Password); err != nil {
+		accountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "email_change_code_sent"})})
+}
+
+// 確認コードを入力してメールアドレスの変更を反映する関数
+func ConfirmEmailChange(c *gin.Context) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	email, err := accounts.ConfirmEmailChange(c.Request.Context(), c.GetUint("id"), input.Code)
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"email":   email,
+		"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "email_changed"}),
+	})
+}
+
+// 元のアドレスに送ったリンクからメールアドレスの変更を取り消す関数。ログインしていなくても使える
+func RevertEmailChange(c *gin.Context) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if _, err := accounts.RevertEmailChange(c.Request.Context(), input.Token); err != nil {
+		accountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "email_change_reverted"})})
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"log"
 	"math/rand"
 	"net/http"
 	"time"
@@ -12,9 +13,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"github.com/Shota0616/go-sns/accounts"
 	"github.com/Shota0616/go-sns/auth"
 	"github.com/Shota0616/go-sns/config"
 	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/search"
 	"golang.org/x/crypto/bcrypt"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
@@ -118,6 +121,16 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	// 削除の猶予期間中にログインした場合は削除を取り消す
+	deletionCancelled := false
+	if user.DeletionScheduledAt != nil {
+		if err := accounts.CancelDeletion(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_update_failed"})})
+			return
+		}
+		deletionCancelled = user.DeletionScheduledAt == nil
+	}
+
 	// ユーザがアクティブかどうかを確認
 	if (!user.IsActive) {
 		// ユーザがアクティブでない場合、認証コードの入力画面にリダイレクトする。
@@ -140,7 +153,7 @@ func Login(c *gin.Context) {
 	}
 
 	// トークンとリフレシュトークンをクライアントに返す
-	c.JSON(http.StatusOK, gin.H{"token": token, "refreshtoken": refreshtoken})
+	c.JSON(http.StatusOK, gin.H{"token": token, "refreshtoken": refreshtoken, "deletion_cancelled": deletionCancelled})
 }
 
 
@@ -162,6 +175,10 @@ func RefreshToken(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "invalid_refresh_token"})})
 		return
 	}
+	if revoked, err := accounts.IsRevoked(context.Background(), claims); err != nil || revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "invalid_refresh_token"})})
+		return
+	}
 
 	// 新しいアクセストークンを生成
 	newToken, err := auth.GenerateJWT(claims.ID)
@@ -204,12 +221,26 @@ func Verify(c *gin.Context) {
 		return
 	}
 
-	user.IsActive = true
-	if err := config.DB.Save(&user).Error; err != nil {
+	if user.DeletionScheduledAt != nil {
+		// 削除の猶予期間中でもメールアドレスを確認できたら削除を取り消す（削除が始まっていたら取り消せない）
+		if err := accounts.CancelDeletion(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_activate_user"})})
+			return
+		}
+		if user.DeletionScheduledAt != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+			return
+		}
+	} else if err := config.DB.Model(&user).Update("is_active", true).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_activate_user"})})
 		return
 	}
 
+	// 有効になったユーザーを検索の入力補完に入れる
+	if err := search.IndexUser(context.Background(), &user); err != nil {
+		log.Printf("failed to index user %d: %v", user.ID, err)
+	}
+
 	// Redisから認証コードを削除
 	if err := config.RDB.Del(context.Background(), input.Email).Err(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "verification_code_delete_failed"})})
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Shota0616/go-sns/bookmarks"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+func bookmarkCollectionJSON(c *models.BookmarkCollection, count int64) gin.H {
+	return gin.H{
+		"id":              c.ID,
+		"name":            c.Name,
+		"bookmarks_count": count,
+		"created_at":      c.CreatedAt,
+	}
+}
+
+// bookmarksパッケージのエラーをレスポンスに変換する
+func bookmarkError(c *gin.Context, err error) {
+	status, id := http.StatusInternalServerError, "failed_to_save_bookmark"
+	var data map[string]interface{}
+	switch {
+	case errors.Is(err, bookmarks.ErrInvalidName):
+		status, id = http.StatusBadRequest, "bookmark_collection_name_invalid"
+		data = map[string]interface{}{"Max": bookmarks.MaxNameLength}
+	case errors.Is(err, bookmarks.ErrNameTaken):
+		status, id = http.StatusConflict, "bookmark_collection_name_taken"
+	case errors.Is(err, bookmarks.ErrTooManyCollections):
+		status, id = http.StatusBadRequest, "too_many_bookmark_collections"
+		data = map[string]interface{}{"Max": bookmarks.MaxCollections}
+	case errors.Is(err, bookmarks.ErrCollectionNotFound):
+		status, id = http.StatusNotFound, "bookmark_collection_not_found"
+	case errors.Is(err, bookmarks.ErrNotFound):
+		status, id = http.StatusNotFound, "bookmark_not_found"
+	}
+	c.JSON(status, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: id, TemplateData: data})})
+}
+
+// ブックマークの保存先。collection_idを省略するか0を送るとどのコレクションにも入れない
+type bookmarkInput struct {
+	CollectionID *uint `json:"collection_id"`
+}
+
+func bindBookmarkInput(c *gin.Context) (bookmarkInput, bool) {
+	var input bookmarkInput
+	// 本文なしでもブックマークできる
+	if c.Request.ContentLength == 0 {
+		return input, true
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return input, false
+	}
+	return input, true
+}
+
+// 投稿をブックマークする関数。ブックマーク済みの場合は指定したコレクションに移す
+func BookmarkPost(c *gin.Context) {
+	post, ok := findContentPost(c)
+	if !ok {
+		return
+	}
+	input, ok := bindBookmarkInput(c)
+	if !ok {
+		return
+	}
+
+	b, err := bookmarks.Add(c.GetUint("id"), post.ID, input.CollectionID)
+	if err != nil {
+		bookmarkError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": b.ID, "collection_id": b.CollectionID, "post": presentPost(c.GetUint("id"), post)})
+}
+
+// ブックマークを別のコレクションに移す関数
+func MoveBookmark(c *gin.Context) {
+	post, ok := findContentPost(c)
+	if !ok {
+		return
+	}
+	input, ok := bindBookmarkInput(c)
+	if !ok {
+		return
+	}
+
+	b, err := bookmarks.Move(c.GetUint("id"), post.ID, input.CollectionID)
+	if err != nil {
+		bookmarkError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": b.ID, "collection_id": b.CollectionID, "post": presentPost(c.GetUint("id"), post)})
+}
+
+// ブックマークを解除する関数。ブックマークしていなくても成功を返す
+func UnbookmarkPost(c *gin.Context) {
+	post, ok := findContentPost(c)
+	if !ok {
+		return
+	}
+
+	if err := bookmarks.Remove(c.GetUint("id"), post.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_remove_bookmark"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, presentPost(c.GetUint("id"), post))
+}
+
+// ブックマークの一覧を新しい順に取得する関数。collection_idを指定するとそのコレクションだけを返す
+func ListBookmarks(c *gin.Context) {
+	userID := c.GetUint("id")
+	var collectionID *uint
+	if s := c.Query("collection_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || id == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+			return
+		}
+		collection := uint(id)
+		collectionID = &collection
+	}
+	maxID, limit := pageParams(c)
+
+	entries, next, err := bookmarks.Page(userID, collectionID, maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_bookmarks"})})
+		return
+	}
+
+	posts := make([]models.Post, len(entries))
+	for i, e := range entries {
+		posts[i] = e.Post
+	}
+	pp := newPostPresenter(userID, posts)
+	result := make([]gin.H, len(entries))
+	for i, e := range entries {
+		result[i] = gin.H{
+			"id":            e.Bookmark.ID,
+			"collection_id": e.Bookmark.CollectionID,
+			"created_at":    e.Bookmark.CreatedAt,
+			"post":          pp.postJSON(e.Post),
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"bookmarks":   result,
+		"next_max_id": next,
+	})
+}
+
+// ブックマークのコレクションの一覧を取得する関数
+func ListBookmarkCollections(c *gin.Context) {
+	list, counts, err := bookmarks.Collections(c.GetUint("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_bookmarks"})})
+		return
+	}
+
+	result := make([]gin.H, len(list))
+	for i := range list {
+		result[i] = bookmarkCollectionJSON(&list[i], counts[list[i].ID])
+	}
+	c.JSON(http.StatusOK, gin.H{"collections": result})
+}
+
+type bookmarkCollectionInput struct {
+	Name string `json:"name"`
+}
+
+// ブックマークのコレクションを作成する関数
+func CreateBookmarkCollection(c *gin.Context) {
+	var input bookmarkCollectionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	collection, err := bookmarks.CreateCollection(c.GetUint("id"), input.Name)
+	if err != nil {
+		bookmarkError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, bookmarkCollectionJSON(collection, 0))
+}
+
+// ブックマークのコレクションの名前を変更する関数
+func RenameBookmarkCollection(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	var input bookmarkCollectionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	userID := c.GetUint("id")
+	collection, err := bookmarks.RenameCollection(userID, id, input.Name)
+	if err != nil {
+		bookmarkError(c, err)
+		return
+	}
+	_, counts, err := bookmarks.Collections(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_bookmarks"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, bookmarkCollectionJSON(collection, counts[collection.ID]))
+}
+
+// ブックマークのコレクションを削除する関数。中のブックマークはどのコレクションにも入っていない状態に戻る
+func DeleteBookmarkCollection(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := bookmarks.DeleteCollection(c.GetUint("id"), id); err != nil {
+		bookmarkError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "bookmark_collection_deleted"})})
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/exports"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+func exportJSON(c *gin.Context, e *models.DataExport) gin.H {
+	url, err := exports.DownloadURL(c.Request.Context(), e)
+	var download interface{}
+	if err == nil && url != "" {
+		download = url
+	}
+	return gin.H{
+		"id":           e.ID,
+		"status":       e.Status,
+		"size":         e.Size,
+		"download_url": download,
+		"expires_at":   e.ExpiresAt,
+		"created_at":   e.CreatedAt,
+	}
+}
+
+// 個人データのエクスポートを依頼する関数。作成が終わるとメールとリアルタイムのイベントで知らせる
+func RequestDataExport(c *gin.Context) {
+	e, wait, err := exports.Request(c.GetUint("id"))
+	if errors.Is(err, exports.ErrTooSoon) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "export_too_soon",
+			TemplateData: map[string]interface{}{"Hours": int(math.Ceil(wait.Hours()))},
+		})})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_request_export"})})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, exportJSON(c, e))
+}
+
+// 依頼したエクスポートの一覧を取得する関数。作成済みのものにはダウンロード用のURLを付ける
+func ListDataExports(c *gin.Context) {
+	var list []models.DataExport
+	if err := config.DB.Where("user_id = ?", c.GetUint("id")).Order("id DESC").Limit(20).Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_request_export"})})
+		return
+	}
+
+	result := make([]gin.H, len(list))
+	for i := range list {
+		result[i] = exportJSON(c, &list[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"exports": result})
+}
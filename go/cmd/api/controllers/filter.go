@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/filters"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+func filterJSON(f *models.KeywordFilter) gin.H {
+	return gin.H{
+		"id":         f.ID,
+		"phrase":     f.Phrase,
+		"whole_word": f.WholeWord,
+		"wildcard":   f.Wildcard,
+		"action":     f.Action,
+		"contexts":   f.Contexts,
+		"expires_at": f.ExpiresAt,
+		"created_at": f.CreatedAt,
+	}
+}
+
+// キーワードフィルターの入力。更新では送られてきた項目だけを変更する
+type filterInput struct {
+	Phrase    *string   `json:"phrase"`
+	WholeWord *bool     `json:"whole_word"`
+	Wildcard  *bool     `json:"wildcard"`
+	Action    *string   `json:"action"`
+	Contexts  *[]string `json:"contexts"`
+	ExpiresIn *int64    `json:"expires_in"`
+}
+
+func (in *filterInput) toInput() filters.Input {
+	return filters.Input{
+		Phrase:    in.Phrase,
+		WholeWord: in.WholeWord,
+		Wildcard:  in.Wildcard,
+		Action:    in.Action,
+		Contexts:  in.Contexts,
+		ExpiresIn: in.ExpiresIn,
+	}
+}
+
+// filtersパッケージのエラーをレスポンスに変換する
+func filterError(c *gin.Context, err error) {
+	status, id := http.StatusInternalServerError, "failed_to_save_filter"
+	var data map[string]interface{}
+	switch {
+	case errors.Is(err, filters.ErrInvalidPhrase):
+		status, id = http.StatusBadRequest, "filter_phrase_invalid"
+		data = map[string]interface{}{"Max": filters.MaxPhraseLength}
+	case errors.Is(err, filters.ErrInvalidAction):
+		status, id = http.StatusBadRequest, "filter_action_invalid"
+	case errors.Is(err, filters.ErrInvalidContext):
+		status, id = http.StatusBadRequest, "filter_context_invalid"
+	case errors.Is(err, filters.ErrInvalidExpiry):
+		status, id = http.StatusBadRequest, "filter_expiry_invalid"
+		data = map[string]interface{}{"Days": int(filters.MaxDuration.Hours() / 24)}
+	case errors.Is(err, filters.ErrTooMany):
+		status, id = http.StatusBadRequest, "too_many_filters"
+		data = map[string]interface{}{"Max": filters.MaxPerUser}
+	case errors.Is(err, filters.ErrNotFound):
+		status, id = http.StatusNotFound, "filter_not_found"
+	}
+	c.JSON(status, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: id, TemplateData: data})})
+}
+
+// キーワードフィルターの一覧を取得する関数
+func ListFilters(c *gin.Context) {
+	list, err := filters.List(c.GetUint("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_filters"})})
+		return
+	}
+
+	result := make([]gin.H, len(list))
+	for i := range list {
+		result[i] = filterJSON(&list[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"filters": result})
+}
+
+// キーワードフィルターを作成する関数
+func CreateFilter(c *gin.Context) {
+	var input filterInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	f, err := filters.Create(c.Request.Context(), c.GetUint("id"), input.toInput())
+	if err != nil {
+		filterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, filterJSON(f))
+}
+
+// キーワードフィルターを更新する関数
+func UpdateFilter(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	var input filterInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	f, err := filters.Update(c.Request.Context(), c.GetUint("id"), id, input.toInput())
+	if err != nil {
+		filterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, filterJSON(f))
+}
+
+// キーワードフィルターを削除する関数
+func DeleteFilter(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := filters.Delete(c.Request.Context(), c.GetUint("id"), id); err != nil {
+		filterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "filter_deleted"})})
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/notifications"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+)
+
+// ユーザー名で指定したユーザーをフォローする関数
+func Follow(c *gin.Context) {
+	followee, ok := findUserParam(c, true)
+	if !ok {
+		return
+	}
+	followeeID := followee.ID
+
+	userID := c.GetUint("id")
+	if followeeID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "cannot_follow_yourself"})})
+		return
+	}
+
+	// ブロックしている（されている）相手はフォローできない
+	blocked, err := relations.IsBlocked(userID, followeeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_follow"})})
+		return
+	}
+	if blocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "follow_not_allowed"})})
+		return
+	}
+
+	// フォロー関係の作成とカウンタの更新を同じトランザクションで行う
+	created := false
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where(models.Follow{FollowerID: userID, FolloweeID: followeeID}).FirstOrCreate(&models.Follow{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		created = true
+		if err := tx.Model(&models.User{}).Where("id = ?", followeeID).UpdateColumn("followers_count", gorm.Expr("followers_count + 1")).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).UpdateColumn("following_count", gorm.Expr("following_count + 1")).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_follow"})})
+		return
+	}
+
+	if created {
+		go timeline.OnFollow(userID, &followee)
+		go notifications.Notify(followeeID, userID, notifications.TypeFollow, nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "followed_successfully"})})
+}
+
+// ユーザー名で指定したユーザーのフォローを解除する関数。停止中のユーザーのフォローも解除できる
+func Unfollow(c *gin.Context) {
+	followee, ok := findUserParam(c, false)
+	if !ok {
+		return
+	}
+	followeeID := followee.ID
+
+	userID := c.GetUint("id")
+	deleted := false
+	demoted := false
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("follower_id = ? AND followee_id = ?", userID, followeeID).Delete(&models.Follow{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		deleted = true
+		if err := tx.Model(&models.User{}).Where("id = ?", followeeID).UpdateColumn("followers_count", gorm.Expr("followers_count - 1")).Error; err != nil {
+			return err
+		}
+		var err error
+		if demoted, err = timeline.DroppedBelowThreshold(tx, followeeID); err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).UpdateColumn("following_count", gorm.Expr("following_count - 1")).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_unfollow"})})
+		return
+	}
+
+	if deleted {
+		go timeline.OnUnfollow(userID, followeeID)
+	}
+	if demoted {
+		go timeline.OnCelebrityEnded(followeeID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "unfollowed_successfully"})})
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/hashtags"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// ハッシュタグが付いた投稿の一覧を取得する関数
+func TagTimeline(c *gin.Context) {
+	tag := hashtags.Normalize(c.Param("tag"))
+	if tag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	maxID, limit := pageParams(c)
+	ids, err := hashtags.PostIDs(tag, maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+	posts, err := timeline.Hydrate(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	presented, err := presentPosts(c.GetUint("id"), posts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tag":         tag,
+		"posts":       presented,
+		"next_max_id": timeline.NextCursor(ids, limit),
+	})
+}
+
+// トレンドのハッシュタグを取得する関数
+func TrendingTags(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "10"), 10, 64)
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	trends, err := hashtags.Trending(context.Background(), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_trends"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": trends})
+}
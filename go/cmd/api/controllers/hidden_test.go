@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/notifications"
+	"github.com/Shota0616/go-sns/search"
+	"github.com/Shota0616/go-sns/testutil"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/gin-gonic/gin"
+)
+
+// 閲覧者と、閲覧者との関係がそれぞれ異なるユーザー。
+// blockedは閲覧者がブロックした相手、blockerは閲覧者をブロックした相手、mutedはミュート中、expiredはミュートの期限切れ
+type users struct {
+	viewer, friend, blocked, blocker, muted, expired models.User
+}
+
+func setupUsers(t *testing.T) users {
+	testutil.Setup(t)
+	u := users{
+		viewer:  testutil.CreateUser(t, "viewer"),
+		friend:  testutil.CreateUser(t, "friend"),
+		blocked: testutil.CreateUser(t, "blocked"),
+		blocker: testutil.CreateUser(t, "blocker"),
+		muted:   testutil.CreateUser(t, "muted"),
+		expired: testutil.CreateUser(t, "expired"),
+	}
+	for _, followee := range []models.User{u.friend, u.muted, u.expired} {
+		testutil.Follow(t, u.viewer.ID, followee.ID)
+	}
+	testutil.Block(t, u.viewer.ID, u.blocked.ID)
+	testutil.Block(t, u.blocker.ID, u.viewer.ID)
+	testutil.Mute(t, u.viewer.ID, u.muted.ID, 0)
+	testutil.Mute(t, u.viewer.ID, u.expired.ID, -time.Minute)
+	return u
+}
+
+// ハンドラーを呼び出してJSONのレスポンスを返す。viewerIDが0ならログインしていない状態で呼び出す
+func get(t *testing.T, route, path string, viewerID uint, handler gin.HandlerFunc) (int, map[string]interface{}) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET(route, func(c *gin.Context) {
+		if viewerID != 0 {
+			c.Set("id", viewerID)
+		}
+	}, handler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s: invalid JSON %q: %v", path, w.Body.String(), err)
+	}
+	return w.Code, body
+}
+
+// 一覧の投稿
+type listed struct {
+	ID       uint
+	HasQuote bool
+}
+
+func listedPosts(t *testing.T, posts interface{}) []listed {
+	t.Helper()
+	items, ok := posts.([]interface{})
+	if !ok {
+		t.Fatalf("posts is %T, want an array", posts)
+	}
+	result := []listed{}
+	for _, item := range items {
+		p := item.(map[string]interface{})
+		result = append(result, listed{ID: uint(p["id"].(float64)), HasQuote: p["quote_of"] != nil})
+	}
+	return result
+}
+
+func createPost(t *testing.T, author models.User, body string) models.Post {
+	return testutil.CreatePost(t, models.Post{UserID: author.ID, Body: body})
+}
+
+func createQuote(t *testing.T, author models.User, body string, quoted models.Post) models.Post {
+	return testutil.CreatePost(t, models.Post{UserID: author.ID, Body: body, QuoteOfID: &quoted.ID})
+}
+
+func TestHomeTimelineHidesBlockedAndMutedUsers(t *testing.T) {
+	u := setupUsers(t)
+	blockedPost := createPost(t, u.blocked, "blocked")
+	mutedPost := createPost(t, u.muted, "muted")
+	friendPost := createPost(t, u.friend, "friend")
+	expiredPost := createPost(t, u.expired, "expired")
+	quoteOfBlocked := createQuote(t, u.friend, "quote of blocked", blockedPost)
+	quoteOfMuted := createQuote(t, u.friend, "quote of muted", mutedPost)
+	quoteOfFriend := createQuote(t, u.expired, "quote of friend", friendPost)
+	repostOfMuted := testutil.CreatePost(t, models.Post{UserID: u.friend.ID, RepostOfID: &mutedPost.ID})
+
+	// ブロック・ミュートする前に配信されていた投稿もホームタイムラインに残っている
+	for _, p := range []models.Post{blockedPost, mutedPost, friendPost, expiredPost, quoteOfBlocked, quoteOfMuted, quoteOfFriend, repostOfMuted} {
+		if err := timeline.FanOut(context.Background(), p.ID, []uint{u.viewer.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, body := get(t, "/timeline/home", "/timeline/home", u.viewer.ID, HomeTimeline)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", code, http.StatusOK, body)
+	}
+	want := []listed{
+		{ID: quoteOfFriend.ID, HasQuote: true},
+		{ID: quoteOfMuted.ID},
+		{ID: quoteOfBlocked.ID},
+		{ID: expiredPost.ID},
+		{ID: friendPost.ID},
+	}
+	if got := listedPosts(t, body["posts"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("posts = %+v, want %+v", got, want)
+	}
+}
+
+func TestSearchPostsHidesBlockedAndMutedUsers(t *testing.T) {
+	u := setupUsers(t)
+	// 1文字の語は全文検索ではなく部分一致で探す
+	blockedPost := createPost(t, u.blocked, "猫 blocked")
+	blockerPost := createPost(t, u.blocker, "猫 blocker")
+	mutedPost := createPost(t, u.muted, "猫 muted")
+	friendPost := createPost(t, u.friend, "猫 friend")
+	expiredPost := createPost(t, u.expired, "猫 expired")
+	quoteOfBlocked := createQuote(t, u.friend, "猫 quote", blockedPost)
+	for _, p := range []models.Post{blockedPost, blockerPost, mutedPost, friendPost, expiredPost, quoteOfBlocked} {
+		if err := search.Sync(p.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q    string
+		want []listed
+	}{
+		{"猫", []listed{{ID: quoteOfBlocked.ID}, {ID: expiredPost.ID}, {ID: friendPost.ID}}},
+		{"猫 from:blocked", []listed{}},
+		{"猫 from:blocker", []listed{}},
+		{"猫 from:muted", []listed{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			code, body := get(t, "/search/posts", "/search/posts?q="+url.QueryEscape(tt.q), u.viewer.ID, SearchPosts)
+			if code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %v", code, http.StatusOK, body)
+			}
+			if got := listedPosts(t, body["posts"]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("posts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListRepliesHidesBlockedAndMutedUsers(t *testing.T) {
+	u := setupUsers(t)
+	parent := createPost(t, u.viewer, "parent")
+	reply := func(author models.User, visibility string) models.Post {
+		return testutil.CreatePost(t, models.Post{UserID: author.ID, Body: "reply", InReplyToID: &parent.ID, Visibility: visibility})
+	}
+	blockedReply := reply(u.blocked, "public")
+	reply(u.blocker, "public")
+	reply(u.muted, "public")
+	friendReply := reply(u.friend, "public")
+	expiredReply := reply(u.expired, "followers")
+	// フォローしていない相手のフォロワー限定の返信は見えない
+	reply(testutil.CreateUser(t, "stranger"), "followers")
+
+	path := fmt.Sprintf("/posts/%d/replies", parent.ID)
+	code, body := get(t, "/posts/:id/replies", path, u.viewer.ID, ListReplies)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", code, http.StatusOK, body)
+	}
+	want := []listed{{ID: expiredReply.ID}, {ID: friendReply.ID}}
+	if got := listedPosts(t, body["posts"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("replies = %+v, want %+v", got, want)
+	}
+
+	// ブロックしている相手の投稿への返信一覧は見られない
+	code, _ = get(t, "/posts/:id/replies", fmt.Sprintf("/posts/%d/replies", blockedReply.ID), u.viewer.ID, ListReplies)
+	if code != http.StatusNotFound {
+		t.Errorf("replies of a blocked user's post: status = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestListNotificationsHidesBlockedAndMutedUsers(t *testing.T) {
+	u := setupUsers(t)
+	own := createPost(t, u.viewer, "own")
+	quoteOfBlocked := createQuote(t, u.friend, "quote", createPost(t, u.blocked, "blocked"))
+	notify := func(actor models.User, kind string, post models.Post) models.Notification {
+		n := models.Notification{
+			UserID:   u.viewer.ID,
+			ActorID:  actor.ID,
+			Type:     kind,
+			GroupKey: fmt.Sprintf("%s:%d:%d", kind, post.ID, actor.ID),
+			PostID:   &post.ID,
+		}
+		if err := config.DB.Omit("Actor", "Post").Create(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	friendLike := notify(u.friend, notifications.TypeLike, own)
+	notify(u.blocked, notifications.TypeLike, own)
+	notify(u.blocker, notifications.TypeLike, own)
+	notify(u.muted, notifications.TypeLike, own)
+	expiredLike := notify(u.expired, notifications.TypeLike, own)
+	friendQuote := notify(u.friend, notifications.TypeMention, quoteOfBlocked)
+
+	code, body := get(t, "/notifications", "/notifications", u.viewer.ID, ListNotifications)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", code, http.StatusOK, body)
+	}
+	type notification struct {
+		ID       uint
+		Actor    string
+		HasQuote bool
+	}
+	var got []notification
+	for _, item := range body["notifications"].([]interface{}) {
+		n := item.(map[string]interface{})
+		actors := n["actors"].([]interface{})
+		post := n["post"].(map[string]interface{})
+		got = append(got, notification{
+			ID:       uint(n["id"].(float64)),
+			Actor:    actors[0].(map[string]interface{})["username"].(string),
+			HasQuote: post["quote_of"] != nil,
+		})
+	}
+	want := []notification{
+		{ID: friendQuote.ID, Actor: "friend"},
+		{ID: expiredLike.ID, Actor: "expired"},
+		{ID: friendLike.ID, Actor: "friend"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("notifications = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetProfileHidesBlockedUsers(t *testing.T) {
+	u := setupUsers(t)
+	tests := []struct {
+		name     string
+		username string
+		viewerID uint
+		want     int
+	}{
+		{"ブロックした相手", u.blocked.Username, u.viewer.ID, http.StatusNotFound},
+		{"ブロックされた相手", u.blocker.Username, u.viewer.ID, http.StatusNotFound},
+		{"ミュートした相手", u.muted.Username, u.viewer.ID, http.StatusOK},
+		{"フォローしている相手", u.friend.Username, u.viewer.ID, http.StatusOK},
+		{"存在しないユーザー", "nobody", u.viewer.ID, http.StatusNotFound},
+		{"ログインしていない", u.blocked.Username, 0, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := get(t, "/users/:username", "/users/"+tt.username, tt.viewerID, GetProfile)
+			if code != tt.want {
+				t.Errorf("status = %d, want %d: %v", code, tt.want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/likes"
+	"github.com/Shota0616/go-sns/notifications"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// 投稿にいいねする関数。既にいいね済みでも成功を返す
+func LikePost(c *gin.Context) {
+	post, ok := findContentPost(c)
+	if !ok {
+		return
+	}
+
+	liked, err := likes.Like(c.GetUint("id"), post.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_like_post"})})
+		return
+	}
+	if liked {
+		go notifications.Notify(post.UserID, c.GetUint("id"), notifications.TypeLike, &post.ID)
+	}
+
+	c.JSON(http.StatusOK, presentPost(c.GetUint("id"), post))
+}
+
+// 投稿のいいねを取り消す関数。いいねしていなくても成功を返す
+func UnlikePost(c *gin.Context) {
+	post, ok := findContentPost(c)
+	if !ok {
+		return
+	}
+
+	if _, err := likes.Unlike(c.GetUint("id"), post.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_unlike_post"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, presentPost(c.GetUint("id"), post))
+}
+
+// 投稿にいいねしたユーザーの一覧を取得する関数
+func ListLikes(c *gin.Context) {
+	post, ok := findContentPost(c)
+	if !ok {
+		return
+	}
+
+	maxID, limit := pageParams(c)
+	list, err := likes.Likers(post.ID, maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_likes"})})
+		return
+	}
+
+	// ブロック・ミュートしている相手は一覧に出さない（カーソルは取り除く前の一覧で進める）
+	filter, err := relations.For(c.GetUint("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_likes"})})
+		return
+	}
+	users := make([]gin.H, 0, len(list))
+	var next uint
+	for _, l := range list {
+		next = l.ID
+		if filter.Hides(l.User.ID) {
+			continue
+		}
+		users = append(users, gin.H{
+			"id":       l.User.ID,
+			"username": l.User.Username,
+			"liked_at": l.CreatedAt,
+		})
+	}
+	if len(list) < limit {
+		next = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       users,
+		"next_max_id": next,
+	})
+}
@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/filters"
+	"github.com/Shota0616/go-sns/lists"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+func listJSON(l *models.List) gin.H {
+	return gin.H{
+		"id":            l.ID,
+		"user_id":       l.UserID,
+		"name":          l.Name,
+		"description":   l.Description,
+		"visibility":    l.Visibility,
+		"members_count": l.MembersCount,
+		"created_at":    l.CreatedAt,
+	}
+}
+
+// リストの入力。更新では送られてきた項目だけを変更する
+type listInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+func (in *listInput) toInput() lists.Input {
+	return lists.Input{Name: in.Name, Description: in.Description, Visibility: in.Visibility}
+}
+
+// listsパッケージのエラーをレスポンスに変換する
+func listError(c *gin.Context, err error) {
+	status, id := http.StatusInternalServerError, "failed_to_save_list"
+	var data map[string]interface{}
+	switch {
+	case errors.Is(err, lists.ErrInvalidName):
+		status, id = http.StatusBadRequest, "list_name_invalid"
+		data = map[string]interface{}{"Max": lists.MaxNameLength}
+	case errors.Is(err, lists.ErrInvalidDescription):
+		status, id = http.StatusBadRequest, "list_description_invalid"
+		data = map[string]interface{}{"Max": lists.MaxDescriptionLength}
+	case errors.Is(err, lists.ErrInvalidVisibility):
+		status, id = http.StatusBadRequest, "list_visibility_invalid"
+	case errors.Is(err, lists.ErrTooManyLists):
+		status, id = http.StatusBadRequest, "too_many_lists"
+		data = map[string]interface{}{"Max": lists.MaxLists}
+	case errors.Is(err, lists.ErrTooManyMembers):
+		status, id = http.StatusBadRequest, "too_many_list_members"
+		data = map[string]interface{}{"Max": lists.MaxMembers}
+	case errors.Is(err, lists.ErrNotFound):
+		status, id = http.StatusNotFound, "list_not_found"
+	case errors.Is(err, lists.ErrUserNotFound):
+		status, id = http.StatusNotFound, "user_not_found"
+	case errors.Is(err, lists.ErrBlocked):
+		status, id = http.StatusForbidden, "list_member_not_allowed"
+	}
+	c.JSON(status, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: id, TemplateData: data})})
+}
+
+// URLパスの:idのリストを閲覧者が見られる場合に取得する
+func findList(c *gin.Context) (*models.List, bool) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return nil, false
+	}
+	l, err := lists.Get(c.GetUint("id"), id)
+	if err != nil {
+		listError(c, err)
+		return nil, false
+	}
+	return l, true
+}
+
+// 自分のリストの一覧を取得する関数
+func ListMyLists(c *gin.Context) {
+	userID := c.GetUint("id")
+	list, err := lists.Of(userID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_lists"})})
+		return
+	}
+
+	result := make([]gin.H, len(list))
+	for i := range list {
+		result[i] = listJSON(&list[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"lists": result})
+}
+
+// ユーザーの公開のリストの一覧を取得する関数（本人の場合は非公開のリストも含める）
+func ListUserLists(c *gin.Context) {
+	viewerID := c.GetUint("id")
+	user, ok := findProfileUser(c)
+	if !ok {
+		return
+	}
+
+	list, err := lists.Of(user.ID, viewerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_lists"})})
+		return
+	}
+
+	result := make([]gin.H, len(list))
+	for i := range list {
+		result[i] = listJSON(&list[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"lists": result})
+}
+
+// リストを作成する関数
+func CreateList(c *gin.Context) {
+	var input listInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	l, err := lists.Create(c.GetUint("id"), input.toInput())
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, listJSON(l))
+}
+
+// リストを取得する関数
+func GetList(c *gin.Context) {
+	l, ok := findList(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, listJSON(l))
+}
+
+// リストを更新する関数
+func UpdateList(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	var input listInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	l, err := lists.Update(c.GetUint("id"), id, input.toInput())
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, listJSON(l))
+}
+
+// リストを削除する関数
+func DeleteList(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := lists.Delete(c.GetUint("id"), id); err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "list_deleted"})})
+}
+
+// リストのメンバーの一覧を取得する関数。閲覧者がブロック・ミュートしている相手は一覧に出さない
+func ListListMembers(c *gin.Context) {
+	l, ok := findList(c)
+	if !ok {
+		return
+	}
+	maxID, limit := pageParams(c)
+
+	members, err := lists.Members(l.ID, maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_lists"})})
+		return
+	}
+
+	filter, err := relations.For(c.GetUint("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_lists"})})
+		return
+	}
+	users := make([]gin.H, 0, len(members))
+	var next uint
+	for _, m := range members {
+		next = m.ID
+		if !m.User.IsActive || filter.Hides(m.User.ID) {
+			continue
+		}
+		users = append(users, gin.H{
+			"id":           m.User.ID,
+			"username":     m.User.Username,
+			"display_name": m.User.DisplayName,
+			"added_at":     m.CreatedAt,
+		})
+	}
+	if len(members) < limit {
+		next = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       users,
+		"next_max_id": next,
+	})
+}
+
+// リストのIDとメンバーのユーザーIDをURLパスから取得する
+func listMemberParams(c *gin.Context) (uint, uint, bool) {
+	listID, ok := idParam(c, "id")
+	if !ok {
+		return 0, 0, false
+	}
+	userID, ok := idParam(c, "user_id")
+	return listID, userID, ok
+}
+
+// リストにメンバーを追加する関数。追加済みでも成功を返す
+func AddListMember(c *gin.Context) {
+	listID, userID, ok := listMemberParams(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if _, err := lists.AddMember(c.GetUint("id"), listID, userID); err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "list_member_added"})})
+}
+
+// リストからメンバーを外す関数。メンバーでなくても成功を返す
+func RemoveListMember(c *gin.Context) {
+	listID, userID, ok := listMemberParams(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := lists.RemoveMember(c.GetUint("id"), listID, userID); err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "list_member_removed"})})
+}
+
+// リストタイムラインを取得する関数。ホームタイムラインと同じキーワードフィルターを適用する
+func ListTimeline(c *gin.Context) {
+	l, ok := findList(c)
+	if !ok {
+		return
+	}
+	maxID, limit := pageParams(c)
+
+	posts, next, err := timeline.List(l.ID, maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	presented, err := presentFilteredPosts(c.GetUint("id"), filters.ContextHome, posts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"posts":       presented,
+		"next_max_id": next,
+	})
+}
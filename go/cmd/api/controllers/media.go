@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/media"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/storage"
+	"github.com/Shota0616/go-sns/visibility"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+)
+
+func mediaJSON(m models.Media) gin.H {
+	variants := make(gin.H, len(m.Variants))
+	for name, v := range m.Variants {
+		variants[name] = gin.H{
+			"url":    media.VariantURL(v),
+			"width":  v.Width,
+			"height": v.Height,
+		}
+	}
+	return gin.H{
+		"id":       m.ID,
+		"status":   m.Status,
+		"width":    m.Width,
+		"height":   m.Height,
+		"blurhash": m.Blurhash,
+		"variants": variants,
+	}
+}
+
+func mediaListJSON(list []models.Media) []gin.H {
+	result := make([]gin.H, len(list))
+	for i, m := range list {
+		result[i] = mediaJSON(m)
+	}
+	return result
+}
+
+// 画像をアップロードする関数。処理はバックグラウンドで行うため、statusがreadyになるまでGetMediaで確認する
+func UploadMedia(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, media.MaxUploadSize+1<<20)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	defer file.Close()
+	if header.Size > media.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "media_too_large"})})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, media.MaxUploadSize+1))
+	if err != nil || int64(len(data)) > media.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "media_too_large"})})
+		return
+	}
+
+	// privateの画像は署名付きの期限のあるURLでのみ配信する
+	private := c.Request.FormValue("private") == "true"
+	m, err := media.Upload(c.GetUint("id"), data, private)
+	switch {
+	case errors.Is(err, media.ErrUnsupportedType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "media_type_unsupported"})})
+		return
+	case errors.Is(err, media.ErrImageTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "media_dimensions_too_large"})})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_upload_media"})})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, mediaJSON(*m))
+}
+
+// アップロードした画像の処理状況を取得する関数
+func GetMedia(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	viewerID := c.GetUint("id")
+	var m models.Media
+	if err := config.DB.First(&m, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "media_not_found"})})
+		return
+	}
+	// 投稿に添付されるまでは本人だけが見られる。添付した後は投稿を見られるユーザーだけが見られる
+	if m.UserID != viewerID {
+		visible, err := canSeeMediaPost(viewerID, &m)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_media"})})
+			return
+		}
+		if !visible {
+			c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "media_not_found"})})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, mediaJSON(m))
+}
+
+// 画像を添付した投稿が閲覧者から見えるかどうか。削除された投稿や停止中のユーザーの投稿の画像は見せない
+func canSeeMediaPost(viewerID uint, m *models.Media) (bool, error) {
+	if m.PostID == nil {
+		return false, nil
+	}
+	var post models.Post
+	err := config.DB.Preload("User").First(&post, *m.PostID).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !post.User.IsActive {
+		return false, nil
+	}
+	viewer, err := visibility.For(viewerID)
+	if err != nil {
+		return false, err
+	}
+	return viewer.Check(&post), nil
+}
+
+// ローカルに保存したファイルを配信する関数。非公開のファイルは署名を確認する
+func ServeMediaFile(c *gin.Context) {
+	local, ok := config.Storage.(*storage.Local)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !storage.IsPublic(key) && !local.Verify(key, c.Query("expires"), c.Query("signature")) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	path, err := local.FilePath(key)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	// 内容のハッシュをキーにしたファイルは変わらない。非公開のファイルは共有のキャッシュに残さない
+	if storage.IsPublic(key) {
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		c.Header("Cache-Control", "private, no-store")
+	}
+	c.File(path)
+}
@@ -0,0 +1,397 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/messaging"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+)
+
+// システムメッセージを閲覧するユーザーの言語の文章にする
+func systemMessageBody(m models.Message) (string, gin.H) {
+	e, ok := messaging.ParseSystemEvent(&m)
+	if !ok {
+		return m.Body, nil
+	}
+	body := config.Localizer.MustLocalize(&i18n.LocalizeConfig{
+		MessageID: "message_event_" + e.Event,
+		TemplateData: map[string]interface{}{
+			"Actor": m.Sender.Username,
+			"Users": strings.Join(e.Users, ", "),
+			"Title": e.Title,
+		},
+	})
+	return body, gin.H{"type": e.Event, "users": e.Users, "title": e.Title}
+}
+
+func messageJSON(m models.Message) gin.H {
+	body, event := m.Body, gin.H(nil)
+	switch {
+	case m.DeletedAt.Valid:
+		// 削除したメッセージは会話の一覧（最後のメッセージ）にも本文を出さない
+		body = ""
+	case m.Kind == messaging.MessageSystem:
+		body, event = systemMessageBody(m)
+	}
+	return gin.H{
+		"id":              m.ID,
+		"conversation_id": m.ConversationID,
+		"kind":            m.Kind,
+		"body":            body,
+		"event":           event,
+		"created_at":      m.CreatedAt,
+		"deleted":         m.DeletedAt.Valid,
+		"sender": gin.H{
+			"id":       m.Sender.ID,
+			"username": m.Sender.Username,
+		},
+	}
+}
+
+func conversationJSON(s messaging.Summary) gin.H {
+	members := make([]gin.H, len(s.Conversation.Members))
+	for i, m := range s.Conversation.Members {
+		members[i] = gin.H{
+			"id":                   m.User.ID,
+			"username":             m.User.Username,
+			"role":                 m.Role,
+			"last_read_message_id": m.LastReadMessageID,
+		}
+	}
+	var last gin.H
+	if s.LastMessage != nil {
+		last = messageJSON(*s.LastMessage)
+	}
+	return gin.H{
+		"id":           s.Conversation.ID,
+		"kind":         s.Conversation.Kind,
+		"title":        s.Conversation.Title,
+		"members":      members,
+		"last_message": last,
+		"unread_count": s.UnreadCount,
+	}
+}
+
+// メッセージ関連のエラーをレスポンスに変換する
+func messagingError(c *gin.Context, err error) {
+	switch err {
+	case messaging.ErrNotMember, gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "conversation_not_found"})})
+	case messaging.ErrNotAllowed:
+		c.JSON(http.StatusForbidden, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "messages_not_allowed"})})
+	case messaging.ErrNotAdmin:
+		c.JSON(http.StatusForbidden, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "conversation_admin_required"})})
+	case messaging.ErrNotGroup:
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "conversation_not_group"})})
+	case messaging.ErrGroupFull:
+		c.JSON(http.StatusConflict, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "group_member_limit_reached"})})
+	case messaging.ErrSelfMessage:
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "cannot_message_yourself"})})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_process_message"})})
+	}
+}
+
+// 会話の一覧を取得する関数
+func ListConversations(c *gin.Context) {
+	maxID, limit := pageParams(c)
+	summaries, next, err := messaging.Inbox(c.GetUint("id"), maxID, limit)
+	if err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	result := make([]gin.H, len(summaries))
+	for i, s := range summaries {
+		result[i] = conversationJSON(s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversations": result,
+		"next_max_id":   next,
+	})
+}
+
+// 1対1の会話を開始する関数。既に会話がある場合はその会話を返す
+func StartConversation(c *gin.Context) {
+	var input struct {
+		UserID uint `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	conv, err := messaging.GetOrCreateDirect(c.GetUint("id"), input.UserID)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+		return
+	}
+	if err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": conv.ID, "kind": conv.Kind})
+}
+
+// 会話のメッセージを取得する関数
+func ListMessages(c *gin.Context) {
+	conversationID, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	maxID, limit := pageParams(c)
+	messages, err := messaging.Messages(conversationID, c.GetUint("id"), maxID, limit)
+	if err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	result := make([]gin.H, len(messages))
+	var next uint
+	for i, m := range messages {
+		result[i] = messageJSON(m)
+		next = m.ID
+	}
+	if len(messages) < limit {
+		next = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":    result,
+		"next_max_id": next,
+	})
+}
+
+// 会話にメッセージを送る関数
+func SendMessage(c *gin.Context) {
+	conversationID, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	var input struct {
+		Body string `json:"body"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	body := strings.TrimSpace(input.Body)
+	if body == "" || utf8.RuneCountInString(body) > messaging.MaxMessageLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "message_body_invalid"})})
+		return
+	}
+
+	msg, err := messaging.Send(conversationID, c.GetUint("id"), body)
+	if err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, messageJSON(*msg))
+}
+
+// 自分が送ったメッセージを削除する関数
+func DeleteMessage(c *gin.Context) {
+	conversationID, ok := idParam(c, "id")
+	messageID, ok2 := idParam(c, "message_id")
+	if !ok || !ok2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := messaging.DeleteMessage(conversationID, c.GetUint("id"), messageID); err != nil {
+		if err == messaging.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "message_not_found"})})
+			return
+		}
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "message_deleted_successfully"})})
+}
+
+// 会話を既読にする関数。message_idを省略すると最新のメッセージまで既読にする
+func MarkConversationRead(c *gin.Context) {
+	conversationID, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	var input struct {
+		MessageID uint `json:"message_id"`
+	}
+	// ボディは省略できる
+	_ = c.ShouldBindJSON(&input)
+
+	if err := messaging.MarkRead(conversationID, c.GetUint("id"), input.MessageID); err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "conversation_marked_read"})})
+}
+
+// 未読のメッセージ数を取得する関数
+func UnreadMessagesCount(c *gin.Context) {
+	conversations, messages, err := messaging.UnreadTotal(c.GetUint("id"))
+	if err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"unread_conversations": conversations,
+		"unread_messages":      messages,
+	})
+}
+
+// ダイレクトメッセージを受け付ける相手の設定を更新する関数
+func UpdateDirectMessagePolicy(c *gin.Context) {
+	var input struct {
+		Policy string `json:"policy"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || !messaging.IsValidPolicy(input.Policy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := config.DB.Model(&models.User{}).Where("id = ?", c.GetUint("id")).Update("direct_message_policy", input.Policy).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_update_failed"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policy": input.Policy})
+}
+
+// グループを作成する関数
+func CreateGroupConversation(c *gin.Context) {
+	var input struct {
+		Title   string `json:"title"`
+		UserIDs []uint `json:"user_ids"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || len(input.UserIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	title := strings.TrimSpace(input.Title)
+	if title == "" || utf8.RuneCountInString(title) > messaging.MaxTitleLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "group_title_invalid"})})
+		return
+	}
+
+	conv, err := messaging.CreateGroup(c.GetUint("id"), title, input.UserIDs)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+		return
+	}
+	if err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": conv.ID, "kind": conv.Kind, "title": conv.Title})
+}
+
+// グループにメンバーを追加する関数
+func AddConversationMembers(c *gin.Context) {
+	conversationID, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	var input struct {
+		UserIDs []uint `json:"user_ids"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || len(input.UserIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	err := messaging.AddMembers(conversationID, c.GetUint("id"), input.UserIDs)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+		return
+	}
+	if err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "group_members_added"})})
+}
+
+// グループからメンバーを外す関数
+func RemoveConversationMember(c *gin.Context) {
+	conversationID, ok := idParam(c, "id")
+	userID, ok2 := idParam(c, "user_id")
+	if !ok || !ok2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := messaging.RemoveMember(conversationID, c.GetUint("id"), userID); err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "group_member_removed"})})
+}
+
+// グループのメンバーの役割を変更する関数
+func UpdateConversationMemberRole(c *gin.Context) {
+	conversationID, ok := idParam(c, "id")
+	userID, ok2 := idParam(c, "user_id")
+	if !ok || !ok2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || (input.Role != messaging.RoleAdmin && input.Role != messaging.RoleMember) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	err := messaging.SetRole(conversationID, c.GetUint("id"), userID, input.Role)
+	if err == messaging.ErrNotAllowed {
+		c.JSON(http.StatusConflict, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "group_admin_required"})})
+		return
+	}
+	if err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"role": input.Role})
+}
+
+// グループから退出する関数
+func LeaveConversation(c *gin.Context) {
+	conversationID, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := messaging.Leave(conversationID, c.GetUint("id")); err != nil {
+		messagingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "left_conversation"})})
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/Shota0616/go-sns/auth"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/filters"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/notifications"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+)
+
+// 通知の一覧をグループごとに取得する関数
+func ListNotifications(c *gin.Context) {
+	userID := c.GetUint("id")
+	maxID, limit := pageParams(c)
+
+	groups, err := notifications.List(userID, maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_notifications"})})
+		return
+	}
+
+	// 通知に関連する投稿をまとめて取得する
+	var postIDs []uint
+	for _, g := range groups {
+		if g.PostID != nil {
+			postIDs = append(postIDs, *g.PostID)
+		}
+	}
+	posts, err := timeline.Hydrate(postIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_notifications"})})
+		return
+	}
+	// ブロック・ミュートしている相手の投稿や、隠すキーワードフィルターに一致した投稿は取り除かれ、
+	// その投稿に関する通知は出さない
+	presented, err := presentFilteredPosts(userID, filters.ContextNotifications, posts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_notifications"})})
+		return
+	}
+	postsByID := make(map[uint]gin.H, len(posts))
+	for _, h := range presented {
+		postsByID[h["id"].(uint)] = h
+	}
+
+	result := make([]gin.H, 0, len(groups))
+	var next uint
+	for _, g := range groups {
+		next = g.ID
+		var post gin.H
+		if g.PostID != nil {
+			// 削除された投稿に関する通知は表示しない
+			if post = postsByID[*g.PostID]; post == nil {
+				continue
+			}
+		}
+		result = append(result, gin.H{
+			"id":           g.ID,
+			"type":         g.Type,
+			"actors":       notificationActors(g.Actors),
+			"actors_count": g.ActorsCount,
+			"post":         post,
+			"read":         !g.Unread,
+			"created_at":   g.CreatedAt,
+			"message":      notifications.Message(g),
+		})
+	}
+	if len(groups) < limit {
+		next = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": result,
+		"next_max_id":   next,
+	})
+}
+
+func notificationActors(users []models.User) []gin.H {
+	actors := make([]gin.H, len(users))
+	for i, u := range users {
+		actors[i] = gin.H{"id": u.ID, "username": u.Username}
+	}
+	return actors
+}
+
+// 未読の通知の数を取得する関数
+func UnreadNotificationsCount(c *gin.Context) {
+	count, err := notifications.UnreadCount(c.GetUint("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_notifications"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread_count": count})
+}
+
+// 通知（とそのグループ）を既読にする関数
+func MarkNotificationRead(c *gin.Context) {
+	notificationID, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := notifications.MarkRead(c.GetUint("id"), notificationID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "notification_not_found"})})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_update_notifications"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "notifications_marked_read"})})
+}
+
+// すべての通知を既読にする関数
+func MarkAllNotificationsRead(c *gin.Context) {
+	if err := notifications.MarkAllRead(c.GetUint("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_update_notifications"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "notifications_marked_read"})})
+}
+
+// 通知の受け取り方の設定を取得する関数
+func GetNotificationPreferences(c *gin.Context) {
+	var user models.User
+	if err := config.DB.First(&user, c.GetUint("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": notifications.Preferences(&user)})
+}
+
+// 通知の受け取り方の設定を更新する関数。指定した種類だけを変更する
+func UpdateNotificationPreferences(c *gin.Context) {
+	var input map[string]string
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	for t, d := range input {
+		if !notifications.IsValidType(t) || !notifications.IsValidDelivery(d) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "invalid_notification_preference"})})
+			return
+		}
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, c.GetUint("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+		return
+	}
+	prefs := notifications.Preferences(&user)
+	for t, d := range input {
+		prefs[t] = d
+	}
+	user.NotificationPreferences = prefs
+	if err := config.DB.Model(&user).Select("notification_preferences").Updates(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_update_failed"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": prefs})
+}
+
+// メールの配信停止リンクを開いたときに確認だけを返す関数（ログイン不要）
+// メールのリンクはプレビューやセキュリティスキャナにも開かれるため、GETでは設定を変更しない
+func ConfirmUnsubscribeNotifications(c *gin.Context) {
+	token := c.Query("token")
+	if _, err := auth.ValidatePurposeToken(notifications.UnsubscribePurpose, token); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "invalid_token"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "unsubscribe_confirmation"}),
+		"token":   token,
+	})
+}
+
+// 通知メールを停止する関数（ログイン不要）
+// 確認画面からのPOSTと、List-Unsubscribe-Postによるワンクリック停止の両方で使う
+func UnsubscribeNotifications(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		token = c.PostForm("token")
+	}
+
+	claims, err := auth.ValidatePurposeToken(notifications.UnsubscribePurpose, token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "invalid_token"})})
+		return
+	}
+
+	if err := notifications.Unsubscribe(claims.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_update_failed"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "unsubscribed_successfully"})})
+}
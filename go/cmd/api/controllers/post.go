@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Shota0616/go-sns/bookmarks"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/filters"
+	"github.com/Shota0616/go-sns/hashtags"
+	"github.com/Shota0616/go-sns/media"
+	"github.com/Shota0616/go-sns/mentions"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/notifications"
+	"github.com/Shota0616/go-sns/search"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/Shota0616/go-sns/visibility"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+)
+
+// 投稿本文の最大文字数
+const maxPostLength = 500
+
+// 投稿を作成する関数
+func CreatePost(c *gin.Context) {
+	var input struct {
+		Body        string `json:"body"`
+		QuoteOfID   *uint  `json:"quote_of_id"`
+		InReplyToID *uint  `json:"in_reply_to_id"`
+		MediaIDs    []uint `json:"media_ids"`
+		Visibility  string `json:"visibility"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	// 本文が空（画像だけの投稿は除く）、または長すぎる場合はエラー
+	body := strings.TrimSpace(input.Body)
+	if (body == "" && len(input.MediaIDs) == 0) || utf8.RuneCountInString(body) > maxPostLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_body_invalid"})})
+		return
+	}
+
+	if input.Visibility == "" {
+		input.Visibility = visibility.Public
+	}
+	if !visibility.IsValid(input.Visibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_visibility_invalid"})})
+		return
+	}
+
+	post := models.Post{
+		UserID:     c.GetUint("id"),
+		Body:       body,
+		Visibility: input.Visibility,
+	}
+	viewer, err := visibility.For(post.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_create_post"})})
+		return
+	}
+
+	// 引用元がリポストの場合は元の投稿を引用する。フォロワー限定・ダイレクトの投稿は引用できない
+	if input.QuoteOfID != nil {
+		var quoted models.Post
+		if err := config.DB.Preload("RepostOf").First(&quoted, *input.QuoteOfID).Error; err != nil || !viewer.Check(&quoted) {
+			c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_not_found"})})
+			return
+		}
+		if quoted.RepostOf != nil {
+			quoted = *quoted.RepostOf
+		}
+		if !visibility.Shareable(&quoted) {
+			c.JSON(http.StatusForbidden, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_not_shareable"})})
+			return
+		}
+		post.QuoteOfID = &quoted.ID
+	}
+
+	// 返信先がリポストの場合は元の投稿への返信とする
+	var parent models.Post
+	if input.InReplyToID != nil {
+		if err := config.DB.Preload("RepostOf").First(&parent, *input.InReplyToID).Error; err != nil || !viewer.Check(&parent) {
+			c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_not_found"})})
+			return
+		}
+		if parent.RepostOf != nil {
+			parent = *parent.RepostOf
+		}
+		post.InReplyToID = &parent.ID
+	}
+
+	if len(input.MediaIDs) > media.MaxPerPost {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "media_attachment_invalid"})})
+		return
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&post).Error; err != nil {
+			return err
+		}
+		return media.Attach(tx, post.UserID, post.ID, input.MediaIDs)
+	})
+	if err == media.ErrInvalidAttachment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "media_attachment_invalid"})})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_create_post"})})
+		return
+	}
+	config.DB.Preload("User").Preload("QuoteOf.User").First(&post, post.ID)
+
+	// ハッシュタグの保存に失敗しても投稿自体は成功とする。トレンドには公開の投稿だけを数える
+	if names, err := hashtags.Attach(&post); err != nil {
+		log.Printf("failed to attach hashtags to post %d: %v", post.ID, err)
+	} else if post.Visibility == visibility.Public {
+		if err := hashtags.RecordUsage(context.Background(), post.UserID, names, post.CreatedAt); err != nil {
+			log.Printf("failed to record hashtag usage of post %d: %v", post.ID, err)
+		}
+	}
+
+	// 返信先の投稿者と、メンションされたユーザーに通知する。
+	// 返信先の投稿者が公開範囲に含まれない返信（フォロワー限定など）は通知しない
+	mentioned, err := mentions.Attach(&post)
+	if err != nil {
+		log.Printf("failed to attach mentions to post %d: %v", post.ID, err)
+	}
+	if post.InReplyToID != nil {
+		reply := post
+		if parentViewer, err := visibility.For(parent.UserID); err != nil {
+			log.Printf("failed to check visibility of reply %d: %v", post.ID, err)
+		} else if parentViewer.Check(&reply) {
+			go notifications.Notify(parent.UserID, post.UserID, notifications.TypeReply, &post.ID)
+		}
+	}
+	for _, u := range mentioned {
+		// 返信先の投稿者には返信の通知だけを送る
+		if post.InReplyToID != nil && u.ID == parent.UserID {
+			continue
+		}
+		go notifications.Notify(u.ID, post.UserID, notifications.TypeMention, &post.ID)
+	}
+
+	// タイムラインへの配信はフォロワー数に比例して重くなるためバックグラウンドで行う。
+	// ダイレクトの投稿はメンションしたユーザーにだけ配信する
+	if post.Visibility == visibility.Direct {
+		recipientIDs := make([]uint, len(mentioned))
+		for i, u := range mentioned {
+			recipientIDs[i] = u.ID
+		}
+		go timeline.PublishTo(&post, recipientIDs)
+	} else {
+		go timeline.Publish(&post)
+	}
+	search.Enqueue(post.ID)
+
+	c.JSON(http.StatusCreated, presentPost(post.UserID, post))
+}
+
+// 投稿を1件取得する関数
+func GetPost(c *gin.Context) {
+	post, ok := findPost(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, presentPost(c.GetUint("id"), post))
+}
+
+// URLパスの:idの投稿を取得する。見つからない場合はエラーレスポンスを返してfalseを返す
+func findPost(c *gin.Context) (models.Post, bool) {
+	var post models.Post
+	postID, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return post, false
+	}
+
+	err := config.DB.Preload("User").
+		Preload("RepostOf.User").
+		Preload("RepostOf.QuoteOf.User").
+		Preload("QuoteOf.User").
+		First(&post, postID).Error
+	// 停止中（削除の猶予期間中など）のユーザーの投稿と、閲覧者から見えない投稿
+	// （ブロックしている・されている相手の投稿や、公開範囲に含まれない投稿）は見つからないものとして扱う
+	if err == nil && !post.User.IsActive {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_not_found"})})
+		return post, false
+	}
+	viewer, err := visibility.For(c.GetUint("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_post"})})
+		return post, false
+	}
+	if !viewer.Check(&post) {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_not_found"})})
+		return post, false
+	}
+	return post, true
+}
+
+// 投稿への返信の一覧を取得する関数
+func ListReplies(c *gin.Context) {
+	post, ok := findContentPost(c)
+	if !ok {
+		return
+	}
+
+	maxID, limit := pageParams(c)
+	query := config.DB.Model(&models.Post{}).Where("in_reply_to_id = ?", post.ID).Scopes(visibility.Scope(c.GetUint("id")))
+	if maxID > 0 {
+		query = query.Where("id < ?", maxID)
+	}
+	var ids []uint
+	if err := query.Order("id DESC").Limit(limit).Pluck("id", &ids).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+	replies, err := timeline.Hydrate(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	presented, err := presentPosts(c.GetUint("id"), replies)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"posts":       presented,
+		"next_max_id": timeline.NextCursor(ids, limit),
+	})
+}
+
+// findPostと同様だが、リポストの場合は元の投稿を返す（いいねなどはリポスト元に対して行う）
+func findContentPost(c *gin.Context) (models.Post, bool) {
+	post, ok := findPost(c)
+	if ok && post.RepostOf != nil {
+		return *post.RepostOf, true
+	}
+	return post, ok
+}
+
+// 自分の投稿を削除する関数
+func DeletePost(c *gin.Context) {
+	postID, ok := idParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	var post models.Post
+	if err := config.DB.Where("id = ? AND user_id = ?", postID, c.GetUint("id")).First(&post).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_not_found"})})
+		return
+	}
+
+	// リポストの削除はリポストの取り消しとして扱う
+	if post.RepostOfID != nil {
+		if err := removeRepost(post); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_delete_post"})})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_deleted_successfully"})})
+		return
+	}
+
+	if err := config.DB.Delete(&post).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_delete_post"})})
+		return
+	}
+	timeline.Unpublish(&post)
+	search.Enqueue(post.ID)
+	if err := bookmarks.RemovePost(post.ID); err != nil {
+		log.Printf("failed to remove bookmarks of post %d: %v", post.ID, err)
+	}
+	go removeRepostsOf(post.ID)
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_deleted_successfully"})})
+}
+
+// ホームタイムラインを取得する関数
+func HomeTimeline(c *gin.Context) {
+	maxID, limit := pageParams(c)
+
+	posts, next, err := timeline.Home(c.GetUint("id"), maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	presented, err := presentFilteredPosts(c.GetUint("id"), filters.ContextHome, posts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"posts":       presented,
+		"next_max_id": next,
+	})
+}
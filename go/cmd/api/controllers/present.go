@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+	"log"
+
+	"github.com/Shota0616/go-sns/bookmarks"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/filters"
+	"github.com/Shota0616/go-sns/hashtags"
+	"github.com/Shota0616/go-sns/likes"
+	"github.com/Shota0616/go-sns/media"
+	"github.com/Shota0616/go-sns/mentions"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/visibility"
+	"github.com/gin-gonic/gin"
+)
+
+// 投稿一覧をJSONに変換する際に、閲覧者ごとの情報をまとめて取得して保持する
+type postPresenter struct {
+	likesCounts map[uint]int64
+	liked       map[uint]bool
+	reposted    map[uint]bool
+	bookmarked  map[uint]bool
+	mentions    map[uint][]mentions.Entity
+	media       map[uint][]models.Media
+}
+
+func newPostPresenter(viewerID uint, posts []models.Post) *postPresenter {
+	// リポスト元・引用元（リポスト元が引用している投稿を含む）の投稿も含めて一度に取得する
+	all := make([]models.Post, 0, len(posts))
+	for _, p := range posts {
+		all = append(all, p)
+		if p.RepostOf != nil {
+			all = append(all, *p.RepostOf)
+			if p.RepostOf.QuoteOf != nil {
+				all = append(all, *p.RepostOf.QuoteOf)
+			}
+		}
+		if p.QuoteOf != nil {
+			all = append(all, *p.QuoteOf)
+		}
+	}
+	ids := make([]uint, len(all))
+	for i, p := range all {
+		ids[i] = p.ID
+	}
+
+	return &postPresenter{
+		likesCounts: likes.Counts(all),
+		liked:       likes.LikedBy(viewerID, ids),
+		reposted:    repostedBy(viewerID, ids),
+		bookmarked:  bookmarks.BookmarkedBy(viewerID, ids),
+		mentions:    mentions.ForPosts(all),
+		media:       media.ForPosts(ids),
+	}
+}
+
+// 投稿をレスポンス用のJSONに変換する
+func (pp *postPresenter) postJSON(post models.Post) gin.H {
+	h := gin.H{
+		"id":               post.ID,
+		"body":             post.Body,
+		"created_at":       post.CreatedAt,
+		"likes_count":      pp.likesCounts[post.ID],
+		"liked_by_me":      pp.liked[post.ID],
+		"reposts_count":    post.RepostsCount,
+		"reposted_by_me":   pp.reposted[post.ID],
+		"bookmarked_by_me": pp.bookmarked[post.ID],
+		"in_reply_to_id":   post.InReplyToID,
+		"visibility":       visibility.Of(&post),
+		"user": gin.H{
+			"id":           post.User.ID,
+			"username":     post.User.Username,
+			"display_name": post.User.DisplayName,
+		},
+		"entities": gin.H{
+			"hashtags": postHashtags(post.Body),
+			"mentions": pp.mentions[post.ID],
+		},
+		"media":     mediaListJSON(pp.media[post.ID]),
+		"repost_of": nil,
+		"quote_of":  nil,
+		"filtered":  nil,
+	}
+	if post.RepostOf != nil {
+		h["repost_of"] = pp.postJSON(*post.RepostOf)
+	}
+	if post.QuoteOf != nil {
+		h["quote_of"] = pp.postJSON(*post.QuoteOf)
+	}
+	return h
+}
+
+// 閲覧者ごとの情報（いいね済みかどうかなど）を付けて投稿の一覧をJSONに変換する。
+// ブロック・ミュートしている相手の投稿と、公開範囲に閲覧者が含まれない投稿は取り除く
+func presentPosts(viewerID uint, posts []models.Post) ([]gin.H, error) {
+	viewer, err := visibility.For(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	posts = viewer.Posts(posts)
+	pp := newPostPresenter(viewerID, posts)
+	result := make([]gin.H, len(posts))
+	for i, p := range posts {
+		result[i] = pp.postJSON(p)
+	}
+	return result, nil
+}
+
+// キーワードフィルターを適用して投稿の一覧をJSONに変換する。隠すフィルターに一致した投稿は取り除き、
+// 警告するフィルターに一致した投稿にはfilteredを付ける（クライアントで折りたたんで表示する）
+func presentFilteredPosts(viewerID uint, filterContext string, posts []models.Post) ([]gin.H, error) {
+	set, err := filters.ForUser(context.Background(), viewerID)
+	if err != nil {
+		// フィルターを読み込めなくてもタイムライン自体は表示する
+		log.Printf("failed to load filters of user %d: %v", viewerID, err)
+	}
+	if set.Empty() {
+		return presentPosts(viewerID, posts)
+	}
+
+	visible := make([]models.Post, 0, len(posts))
+	results := make(map[uint]filters.Result)
+	for i := range posts {
+		// 自分の投稿にはフィルターを適用しない
+		if posts[i].UserID == viewerID {
+			visible = append(visible, posts[i])
+			continue
+		}
+		result := set.CheckPost(filterContext, &posts[i])
+		if result.Action == filters.ActionHide {
+			continue
+		}
+		if result.Action == filters.ActionWarn {
+			results[posts[i].ID] = result
+		}
+		visible = append(visible, posts[i])
+	}
+	presented, err := presentPosts(viewerID, visible)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range presented {
+		if result, ok := results[h["id"].(uint)]; ok {
+			h["filtered"] = gin.H{"action": result.Action, "phrases": result.Phrases}
+		}
+	}
+	return presented, nil
+}
+
+// 投稿を1件JSONに変換する。見せてよいかどうかは呼び出し元（findPostなど）で確認済みとする
+func presentPost(viewerID uint, post models.Post) gin.H {
+	return newPostPresenter(viewerID, []models.Post{post}).postJSON(post)
+}
+
+// 本文中のハッシュタグの位置（クライアントでリンクにするために使う）
+func postHashtags(body string) []hashtags.Tag {
+	tags := hashtags.Extract(body)
+	if tags == nil {
+		return []hashtags.Tag{}
+	}
+	return tags
+}
+
+// 指定した投稿のうち、viewerIDのユーザーがリポストしている投稿IDの集合を返す
+func repostedBy(viewerID uint, postIDs []uint) map[uint]bool {
+	reposted := make(map[uint]bool)
+	if viewerID == 0 || len(postIDs) == 0 {
+		return reposted
+	}
+	var ids []uint
+	config.DB.Model(&models.Post{}).
+		Where("user_id = ? AND repost_of_id IN ?", viewerID, postIDs).
+		Pluck("repost_of_id", &ids)
+	for _, id := range ids {
+		reposted[id] = true
+	}
+	return reposted
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/profiles"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/Shota0616/go-sns/search"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/Shota0616/go-sns/visibility"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// プロフィールをJSONに変換する。メールアドレスは本人にも返さない（本人はGetUserで取得する）
+func profileJSON(viewerID uint, user *models.User) gin.H {
+	links := user.Links
+	if links == nil {
+		links = []string{}
+	}
+	h := gin.H{
+		"id":                  user.ID,
+		"username":            user.Username,
+		"display_name":        user.DisplayName,
+		"bio":                 user.Bio,
+		"location":            user.Location,
+		"links":               links,
+		"avatar":              nil,
+		"header":              nil,
+		"followers_count":     user.FollowersCount,
+		"following_count":     user.FollowingCount,
+		"language":            user.Language,
+		"created_at":          user.CreatedAt,
+		"birthday":            nil,
+		"birthday_visibility": nil,
+	}
+	avatar, header := profiles.Media(user)
+	if avatar != nil {
+		h["avatar"] = mediaJSON(*avatar)
+	}
+	if header != nil {
+		h["header"] = mediaJSON(*header)
+	}
+	if user.Birthday != nil {
+		if ok, err := profiles.CanSeeBirthday(viewerID, user); err == nil && ok {
+			h["birthday"] = user.Birthday.Format(profiles.BirthdayLayout)
+		}
+	}
+	// 公開範囲の設定は本人にだけ返す
+	if viewerID == user.ID {
+		h["birthday_visibility"] = user.BirthdayVisibility
+	}
+	return h
+}
+
+// URLパスの:usernameのユーザーを取得する。activeOnlyなら停止中のユーザーは見つからないものとして扱う。
+// 見つからない場合はエラーレスポンスを返してfalseを返す
+func findUserParam(c *gin.Context, activeOnly bool) (models.User, bool) {
+	var user models.User
+	query := config.DB.Where("username = ?", c.Param("username"))
+	if activeOnly {
+		query = query.Where("is_active = ?", true)
+	}
+	if err := query.First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+		return user, false
+	}
+	return user, true
+}
+
+// URLパスの:usernameのユーザーをプロフィールを見せる相手として取得する。
+// 停止中のユーザーと、ブロックしている（されている）相手は見つからないものとして扱う
+func findProfileUser(c *gin.Context) (models.User, bool) {
+	user, ok := findUserParam(c, true)
+	if !ok {
+		return user, false
+	}
+	filter, err := relations.For(c.GetUint("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_profile"})})
+		return user, false
+	}
+	if filter.Blocks(user.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+		return user, false
+	}
+	return user, true
+}
+
+// ユーザー名でプロフィールを取得する関数。ログインしていなくても見られる
+func GetProfile(c *gin.Context) {
+	user, ok := findProfileUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, profileJSON(c.GetUint("id"), &user))
+}
+
+// ユーザーの投稿一覧（プロフィールページ）を取得する関数。閲覧者に見えない公開範囲の投稿は含めない
+func ListUserPosts(c *gin.Context) {
+	viewerID := c.GetUint("id")
+	user, ok := findProfileUser(c)
+	if !ok {
+		return
+	}
+
+	maxID, limit := pageParams(c)
+	query := config.DB.Model(&models.Post{}).Where("user_id = ?", user.ID).Scopes(visibility.Scope(viewerID))
+	if maxID > 0 {
+		query = query.Where("id < ?", maxID)
+	}
+	var ids []uint
+	if err := query.Order("id DESC").Limit(limit).Pluck("id", &ids).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+	posts, err := timeline.Hydrate(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	presented, err := presentPosts(viewerID, posts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_timeline"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"posts":       presented,
+		"next_max_id": timeline.NextCursor(ids, limit),
+	})
+}
+
+// プロフィールを更新する関数。送られてきた項目だけを更新し、不正な項目は項目ごとにエラーを返す
+func UpdateProfile(c *gin.Context) {
+	var input struct {
+		DisplayName *string   `json:"display_name"`
+		Bio         *string   `json:"bio"`
+		Location    *string   `json:"location"`
+		Links       *[]string `json:"links"`
+		// 0を送るとアイコン・ヘッダーを外す
+		AvatarMediaID      *uint   `json:"avatar_media_id"`
+		HeaderMediaID      *uint   `json:"header_media_id"`
+		Birthday           *string `json:"birthday"`
+		BirthdayVisibility *string `json:"birthday_visibility"`
+		Language           *string `json:"language"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	userID := c.GetUint("id")
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+		return
+	}
+
+	fields := gin.H{}
+	invalid := func(field string, messageID string, data map[string]interface{}) {
+		fields[field] = config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: messageID, TemplateData: data})
+	}
+	columns := []string{}
+
+	if input.DisplayName != nil {
+		name, ok := profiles.Clean(*input.DisplayName, profiles.MaxDisplayNameLength)
+		if ok {
+			user.DisplayName = name
+			columns = append(columns, "DisplayName")
+		} else {
+			invalid("display_name", "profile_field_too_long", map[string]interface{}{"Max": profiles.MaxDisplayNameLength})
+		}
+	}
+	if input.Bio != nil {
+		bio, ok := profiles.CleanBio(*input.Bio)
+		if ok {
+			user.Bio = bio
+			columns = append(columns, "Bio")
+		} else {
+			invalid("bio", "profile_field_too_long", map[string]interface{}{"Max": profiles.MaxBioLength})
+		}
+	}
+	if input.Location != nil {
+		location, ok := profiles.Clean(*input.Location, profiles.MaxLocationLength)
+		if ok {
+			user.Location = location
+			columns = append(columns, "Location")
+		} else {
+			invalid("location", "profile_field_too_long", map[string]interface{}{"Max": profiles.MaxLocationLength})
+		}
+	}
+	if input.Links != nil {
+		links, ok := profiles.CleanLinks(*input.Links)
+		if ok {
+			user.Links = links
+			columns = append(columns, "Links")
+		} else {
+			invalid("links", "profile_links_invalid", map[string]interface{}{"Max": profiles.MaxLinks, "MaxLength": profiles.MaxLinkLength})
+		}
+	}
+	if input.AvatarMediaID != nil {
+		if *input.AvatarMediaID == 0 {
+			user.AvatarMediaID = nil
+			columns = append(columns, "AvatarMediaID")
+		} else if m, err := profiles.OwnedMedia(userID, *input.AvatarMediaID); err == nil {
+			user.AvatarMediaID = &m.ID
+			columns = append(columns, "AvatarMediaID")
+		} else {
+			invalid("avatar_media_id", "profile_media_invalid", nil)
+		}
+	}
+	if input.HeaderMediaID != nil {
+		if *input.HeaderMediaID == 0 {
+			user.HeaderMediaID = nil
+			columns = append(columns, "HeaderMediaID")
+		} else if m, err := profiles.OwnedMedia(userID, *input.HeaderMediaID); err == nil {
+			user.HeaderMediaID = &m.ID
+			columns = append(columns, "HeaderMediaID")
+		} else {
+			invalid("header_media_id", "profile_media_invalid", nil)
+		}
+	}
+	if input.Birthday != nil {
+		birthday, ok := profiles.ParseBirthday(*input.Birthday)
+		if ok {
+			user.Birthday = birthday
+			columns = append(columns, "Birthday")
+		} else {
+			invalid("birthday", "profile_birthday_invalid", nil)
+		}
+	}
+	if input.BirthdayVisibility != nil {
+		if profiles.IsValidVisibility(*input.BirthdayVisibility) {
+			user.BirthdayVisibility = *input.BirthdayVisibility
+			columns = append(columns, "BirthdayVisibility")
+		} else {
+			invalid("birthday_visibility", "profile_birthday_visibility_invalid", nil)
+		}
+	}
+	if input.Language != nil {
+		if lang, ok := profiles.ParseLanguage(*input.Language); ok {
+			user.Language = lang
+			columns = append(columns, "Language")
+		} else {
+			invalid("language", "profile_language_invalid", nil)
+		}
+	}
+
+	if len(fields) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"}),
+			"fields": fields,
+		})
+		return
+	}
+
+	if len(columns) > 0 {
+		if err := config.DB.Model(&user).Select(columns).Updates(&user).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_update_failed"})})
+			return
+		}
+	}
+	// 表示名を変えた場合はユーザー検索の入力補完に反映する
+	if input.DisplayName != nil {
+		if err := search.IndexUser(c.Request.Context(), &user); err != nil {
+			log.Printf("failed to index user %d: %v", user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, profileJSON(userID, &user))
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/Shota0616/go-sns/accounts"
+	"github.com/Shota0616/go-sns/auth"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// CORSと同じオリジンからの接続だけを受け付ける
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || origin == os.Getenv("APP_URL") || origin == "http://localhost:5173"
+	},
+}
+
+// リクエストのトークンを検証してユーザーIDを返す。
+// ブラウザのWebSocketやEventSourceはヘッダーを付けられないため、クエリパラメータのticketも受け付ける。
+// URLはアクセスログに残るため、クエリパラメータではログインに使うトークンを受け付けない
+func streamUserID(c *gin.Context) (uint, bool) {
+	if ticket := c.Query("ticket"); ticket != "" {
+		userID, err := realtime.RedeemTicket(c.Request.Context(), ticket)
+		if errors.Is(err, realtime.ErrInvalidTicket) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return 0, false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_connect_events"})})
+			return 0, false
+		}
+		return userID, true
+	}
+
+	token := c.GetHeader("Authorization")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token required"})
+		return 0, false
+	}
+	claims, err := auth.ValidateJWT(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return 0, false
+	}
+	if revoked, err := accounts.IsRevoked(c.Request.Context(), claims); err != nil || revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return 0, false
+	}
+	return claims.ID, true
+}
+
+// EventsWebSocketやEventsStreamに接続するためのチケットを発行する関数
+func CreateStreamTicket(c *gin.Context) {
+	ticket, err := realtime.IssueTicket(c.GetUint("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_generate_token"})})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_in": int(realtime.TicketTTL.Seconds())})
+}
+
+// 通知・ダイレクトメッセージ・タイムラインの更新をWebSocketで配信する関数
+func EventsWebSocket(c *gin.Context) {
+	userID, ok := streamUserID(c)
+	if !ok {
+		return
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgradeがエラーレスポンスを返している
+		return
+	}
+	realtime.Serve(conn, userID)
+}
+
+// EventsWebSocketと同じイベントをServer-Sent Eventsで配信する関数。
+// 再接続時はLast-Event-IDヘッダー（またはlast_event_idパラメータ）以降のイベントから送る
+func EventsStream(c *gin.Context) {
+	userID, ok := streamUserID(c)
+	if !ok {
+		return
+	}
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	if lastID != "" && !realtime.IsValidID(lastID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	if err := realtime.ServeSSE(c.Request.Context(), c.Writer, userID, lastID); err != nil {
+		log.Printf("failed to stream events to user %d: %v", userID, err)
+	}
+}
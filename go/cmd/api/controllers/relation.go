@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/lists"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// URLパスの:usernameのユーザーを取得する。自分自身や見つからない場合はエラーレスポンスを返してfalseを返す
+func findRelationTarget(c *gin.Context) (models.User, bool) {
+	user, ok := findUserParam(c, false)
+	if !ok {
+		return user, false
+	}
+	if user.ID == c.GetUint("id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "cannot_block_or_mute_yourself"})})
+		return user, false
+	}
+	return user, true
+}
+
+// ユーザーをブロックする関数。お互いのフォローも解除する
+func BlockUser(c *gin.Context) {
+	target, ok := findRelationTarget(c)
+	if !ok {
+		return
+	}
+
+	if err := relations.Block(c.GetUint("id"), target.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_block"})})
+		return
+	}
+	// お互いのリストからも外す
+	if err := lists.RemoveBetween(c.GetUint("id"), target.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_block"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "blocked_successfully"})})
+}
+
+// ユーザーのブロックを解除する関数。ブロックしていなくても成功を返す
+func UnblockUser(c *gin.Context) {
+	target, ok := findRelationTarget(c)
+	if !ok {
+		return
+	}
+
+	if err := relations.Unblock(c.GetUint("id"), target.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_unblock"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "unblocked_successfully"})})
+}
+
+// ユーザーをミュートする関数。expires_inに秒数を指定すると期限付きのミュートになる（0または省略で期限なし）
+func MuteUser(c *gin.Context) {
+	target, ok := findRelationTarget(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ExpiresIn int64 `json:"expires_in"`
+	}
+	// 本文なしで送られた場合は期限なしとする
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+			return
+		}
+	}
+	duration := time.Duration(input.ExpiresIn) * time.Second
+	if input.ExpiresIn < 0 || duration > relations.MaxMuteDuration {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "mute_duration_invalid",
+			TemplateData: map[string]interface{}{"Days": int(relations.MaxMuteDuration.Hours() / 24)},
+		})})
+		return
+	}
+
+	if err := relations.Mute(c.GetUint("id"), target.ID, duration); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_mute"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "muted_successfully"})})
+}
+
+// ユーザーのミュートを解除する関数。ミュートしていなくても成功を返す
+func UnmuteUser(c *gin.Context) {
+	target, ok := findRelationTarget(c)
+	if !ok {
+		return
+	}
+
+	if err := relations.Unmute(c.GetUint("id"), target.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_unmute"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "unmuted_successfully"})})
+}
+
+// ブロックしているユーザーの一覧を取得する関数
+func ListBlocks(c *gin.Context) {
+	maxID, limit := pageParams(c)
+	list, err := relations.Blocks(c.GetUint("id"), maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_relations"})})
+		return
+	}
+
+	users := make([]gin.H, len(list))
+	var next uint
+	for i, b := range list {
+		users[i] = gin.H{
+			"id":         b.Blocked.ID,
+			"username":   b.Blocked.Username,
+			"blocked_at": b.CreatedAt,
+		}
+		next = b.ID
+	}
+	if len(list) < limit {
+		next = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       users,
+		"next_max_id": next,
+	})
+}
+
+// ミュートしているユーザーの一覧を取得する関数。期限の切れたミュートは含まない
+func ListMutes(c *gin.Context) {
+	maxID, limit := pageParams(c)
+	list, err := relations.Mutes(c.GetUint("id"), maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_relations"})})
+		return
+	}
+
+	users := make([]gin.H, len(list))
+	var next uint
+	for i, m := range list {
+		users[i] = gin.H{
+			"id":         m.Muted.ID,
+			"username":   m.Muted.Username,
+			"muted_at":   m.CreatedAt,
+			"expires_at": m.ExpiresAt,
+		}
+		next = m.ID
+	}
+	if len(list) < limit {
+		next = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       users,
+		"next_max_id": next,
+	})
+}
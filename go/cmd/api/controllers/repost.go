@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/notifications"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/Shota0616/go-sns/visibility"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+)
+
+// 投稿をリポストする関数。既にリポスト済みの場合は既存のリポストを返す
+func Repost(c *gin.Context) {
+	post, ok := findPost(c)
+	if !ok {
+		return
+	}
+
+	// リポストのリポストは元の投稿のリポストとして扱う
+	original := post
+	if post.RepostOf != nil {
+		original = *post.RepostOf
+	}
+	// フォロワー限定・ダイレクトの投稿はリポストできない
+	if !visibility.Shareable(&original) {
+		c.JSON(http.StatusForbidden, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "post_not_shareable"})})
+		return
+	}
+
+	userID := c.GetUint("id")
+	repost := models.Post{UserID: userID, RepostOfID: &original.ID}
+	created := false
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		// リポストの公開範囲は元の投稿に合わせる（未収載の投稿のリポストは未収載）
+		result := tx.Where(models.Post{UserID: userID, RepostOfID: &original.ID}).
+			Attrs(models.Post{Visibility: visibility.Of(&original)}).
+			FirstOrCreate(&repost)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		created = true
+		return tx.Model(&models.Post{}).Where("id = ?", original.ID).UpdateColumn("reposts_count", gorm.Expr("reposts_count + 1")).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_repost"})})
+		return
+	}
+
+	config.DB.Preload("User").Preload("RepostOf.User").Preload("RepostOf.QuoteOf.User").First(&repost, repost.ID)
+
+	if created {
+		// フォロワーのタイムラインには投稿者ではなくリポストしたユーザーの投稿として配信される
+		go timeline.Publish(&repost)
+		go notifications.Notify(original.UserID, userID, notifications.TypeRepost, &original.ID)
+	}
+	c.JSON(http.StatusOK, presentPost(userID, repost))
+}
+
+// リポストを取り消す関数。リポストしていなくても成功を返す
+func Unrepost(c *gin.Context) {
+	post, ok := findPost(c)
+	if !ok {
+		return
+	}
+
+	originalID := post.ContentID()
+	var repost models.Post
+	err := config.DB.Where("user_id = ? AND repost_of_id = ?", c.GetUint("id"), originalID).First(&repost).Error
+	if err == nil {
+		err = removeRepost(repost)
+	} else if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_unrepost"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "unreposted_successfully"})})
+}
+
+// リポストを物理削除し、元の投稿のリポスト数を減らす。
+// 物理削除なので、同じ投稿を再度リポストしてもユニーク制約に当たらない
+func removeRepost(repost models.Post) error {
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Delete(&repost)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Model(&models.Post{}).Unscoped().Where("id = ?", *repost.RepostOfID).UpdateColumn("reposts_count", gorm.Expr("reposts_count - 1")).Error
+	})
+	if err != nil {
+		return err
+	}
+	timeline.Unpublish(&repost)
+	return nil
+}
+
+// 削除された投稿のリポストをすべて取り除く
+func removeRepostsOf(postID uint) {
+	var reposts []models.Post
+	if err := config.DB.Where("repost_of_id = ?", postID).Find(&reposts).Error; err != nil {
+		log.Printf("failed to load reposts of post %d: %v", postID, err)
+		return
+	}
+	for _, r := range reposts {
+		if err := config.DB.Unscoped().Delete(&r).Error; err != nil {
+			log.Printf("failed to delete repost %d: %v", r.ID, err)
+			continue
+		}
+		timeline.Unpublish(&r)
+	}
+}
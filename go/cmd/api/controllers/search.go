@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/filters"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/search"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// 投稿を全文検索する関数。検索語の書き方はsearch.Queryを参照
+func SearchPosts(c *gin.Context) {
+	q, err := search.Parse(c.Query("q"))
+	if errors.Is(err, search.ErrEmptyQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "search_query_empty"})})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "search_query_invalid",
+			TemplateData: map[string]interface{}{"Max": search.MaxQueryLength},
+		})})
+		return
+	}
+
+	viewerID := c.GetUint("id")
+	maxID, limit := pageParams(c)
+	ids, err := search.PostIDs(viewerID, q, maxID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_search"})})
+		return
+	}
+	posts, err := timeline.Hydrate(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_search"})})
+		return
+	}
+
+	presented, err := presentFilteredPosts(viewerID, filters.ContextSearch, posts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_search"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"posts":       presented,
+		"next_max_id": timeline.NextCursor(ids, limit),
+	})
+}
+
+// 検索結果のユーザーをJSONに変換する。閲覧者との関係もまとめて返す
+func searchUsersJSON(viewerID uint, users []models.User) []gin.H {
+	ids := make([]uint, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	following, followedBy := make(map[uint]bool), make(map[uint]bool)
+	if len(ids) > 0 {
+		var list []uint
+		config.DB.Model(&models.Follow{}).Where("follower_id = ? AND followee_id IN ?", viewerID, ids).Pluck("followee_id", &list)
+		for _, id := range list {
+			following[id] = true
+		}
+		list = nil
+		config.DB.Model(&models.Follow{}).Where("followee_id = ? AND follower_id IN ?", viewerID, ids).Pluck("follower_id", &list)
+		for _, id := range list {
+			followedBy[id] = true
+		}
+	}
+
+	result := make([]gin.H, len(users))
+	for i, u := range users {
+		result[i] = gin.H{
+			"id":              u.ID,
+			"username":        u.Username,
+			"display_name":    u.DisplayName,
+			"followers_count": u.FollowersCount,
+			"following":       following[u.ID],
+			"followed_by":     followedBy[u.ID],
+		}
+	}
+	return result
+}
+
+// ユーザー名・表示名でユーザーを検索する関数。フォローしている相手やお互いにフォローしている相手を先に並べる
+func SearchUsers(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	viewerID := c.GetUint("id")
+	users, err := search.Users(viewerID, c.Query("q"), limit)
+	if errors.Is(err, search.ErrEmptyQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "search_query_empty"})})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_search"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": searchUsersJSON(viewerID, users)})
+}
+
+// 入力中の文字列で始まるユーザーを返す関数（メンションなどの入力補完用）。空の場合は空の一覧を返す
+func AutocompleteUsers(c *gin.Context) {
+	viewerID := c.GetUint("id")
+	users, err := search.Autocomplete(c.Request.Context(), viewerID, c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_search"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": searchUsersJSON(viewerID, users)})
+}
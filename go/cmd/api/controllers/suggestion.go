@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/suggestions"
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// おすすめのユーザーを取得する関数
+func ListSuggestions(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = suggestions.DefaultLimit
+	}
+	if limit > suggestions.MaxSuggestions {
+		limit = suggestions.MaxSuggestions
+	}
+
+	list, err := suggestions.For(c.Request.Context(), c.GetUint("id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_suggestions"})})
+		return
+	}
+
+	ids := make([]uint, len(list))
+	for i, s := range list {
+		ids[i] = s.UserID
+	}
+	users := make(map[uint]models.User, len(ids))
+	if len(ids) > 0 {
+		var found []models.User
+		if err := config.DB.Where("id IN ? AND is_active = ?", ids, true).Find(&found).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_load_suggestions"})})
+			return
+		}
+		for _, u := range found {
+			users[u.ID] = u
+		}
+	}
+
+	result := make([]gin.H, 0, len(list))
+	for _, s := range list {
+		u, ok := users[s.UserID]
+		if !ok {
+			continue
+		}
+		result = append(result, gin.H{
+			"id":              u.ID,
+			"username":        u.Username,
+			"display_name":    u.DisplayName,
+			"followers_count": u.FollowersCount,
+			"reason":          s.Reason,
+			"mutual_count":    s.MutualCount,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"users": result})
+}
+
+// ユーザーをおすすめから外す関数。外したユーザーは再びおすすめに出さない
+func DismissSuggestion(c *gin.Context) {
+	targetID, ok := idParam(c, "id")
+	if !ok || targetID == c.GetUint("id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+	var user models.User
+	if err := config.DB.Select("id").First(&user, targetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
+		return
+	}
+
+	if err := suggestions.Dismiss(c.GetUint("id"), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "failed_to_dismiss_suggestion"})})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "suggestion_dismissed"})})
+}
@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"github.com/gin-gonic/gin"
 	"github.com/Shota0616/go-sns/config"
 	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/accounts"
 	"github.com/Shota0616/go-sns/auth"
 	"golang.org/x/crypto/bcrypt"
 	"log"
@@ -43,9 +45,9 @@ func GetUser(c *gin.Context) {
 	})
 }
 
+// メールアドレスは確認が必要なため、RequestEmailChangeとConfirmEmailChangeで変更する
 func UpdateUser(c *gin.Context) {
 	var input struct {
-		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 
@@ -74,10 +76,6 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	if input.Email != "" {
-		user.Email = input.Email
-	}
-
 	if input.Password != "" {
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 		if err != nil {
@@ -95,31 +93,31 @@ func UpdateUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_updated_successfully"})})
 }
 
+// アカウントの削除を依頼する関数。猶予期間が過ぎるまでにログインすると削除を取り消せる
 func DeleteUser(c *gin.Context) {
-    tokenStr := c.GetHeader("Authorization")
-    if tokenStr == "" {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "authorization_token_not_provided"})})
-        return
-    }
-
-    claims, err := auth.ValidateJWT(tokenStr)
-    if err != nil {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "invalid_token"})})
-        return
-    }
-
-    userID := claims.ID
-
-    var user models.User
-    if err := config.DB.Where("id = ?", userID).First(&user).Error; err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_not_found"})})
-        return
-    }
-
-    if err := config.DB.Delete(&user).Error; err != nil {
+	var input struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "input_data_invalid"})})
+		return
+	}
+
+	deleteAt, err := accounts.ScheduleDeletion(c.Request.Context(), c.GetUint("id"), input.Password)
+	if errors.Is(err, accounts.ErrIncorrectPass) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "password_incorrect"})})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_deletion_failed"})})
-        return
-    }
+		return
+	}
 
-    c.JSON(http.StatusOK, gin.H{"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "user_deleted_successfully"})})
+	c.JSON(http.StatusOK, gin.H{
+		"delete_at": deleteAt,
+		"message": config.Localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "user_deletion_scheduled",
+			TemplateData: map[string]interface{}{"Days": int(accounts.DeletionGracePeriod.Hours() / 24)},
+		}),
+	})
 }
@@ -2,8 +2,18 @@ package main
 
 import (
 	// "github.com/gin-gonic/gin"
+	"time"
+
+	"github.com/Shota0616/go-sns/accounts"
 	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/exports"
+	"github.com/Shota0616/go-sns/likes"
+	"github.com/Shota0616/go-sns/media"
+	"github.com/Shota0616/go-sns/notifications"
+	"github.com/Shota0616/go-sns/realtime"
 	"github.com/Shota0616/go-sns/routes"
+	"github.com/Shota0616/go-sns/search"
+	"github.com/Shota0616/go-sns/suggestions"
 	// "log"
 )
 
@@ -12,6 +22,26 @@ func main() {
 	config.ConnectDatabase()
 	config.MigrateDatabase()
 	config.ConnectRedis()
+	config.ConnectStorage()
+
+	// いいね数をRedisからMySQLへ定期的に反映する
+	likes.StartFlusher(10 * time.Second)
+	// 通知のダイジェストメールを毎日送る
+	notifications.StartDigestScheduler()
+	// 他のインスタンスで発生したイベントを受け取り、接続中のクライアントに配信する
+	realtime.Start()
+	// アップロードされた画像をバックグラウンドで処理する
+	media.StartWorkers(2)
+	// どこからも使われていない画像を定期的に削除する
+	media.StartGC(time.Hour)
+	// 猶予期間の過ぎたアカウントを削除する
+	accounts.StartPurger(time.Hour)
+	// 個人データのエクスポートをバックグラウンドで作成する
+	exports.StartWorkers(1)
+	// 検索のインデックスをバックグラウンドで更新する
+	search.StartIndexer(1)
+	// おすすめのユーザーを定期的に計算し直す
+	suggestions.StartRefresher(6 * time.Hour)
 
 	router := routes.SetupRouter()
 	router.Run(":8080")
@@ -0,0 +1,153 @@
+// タイムラインのファンアウト（書き込み）とマージ（読み込み）のレイテンシを
+// 合成データで計測するベンチマーク。
+//
+//	go run ./cmd/timelinebench -redis localhost:6379 -db 15
+//
+// 指定したRedisのDBは計測前後にFLUSHDBされるので、アプリと同じDBを指定しないこと。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/timeline"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	redisAddr          = flag.String("redis", "localhost:6379", "Redisのアドレス")
+	redisDB            = flag.Int("db", 15, "計測に使うRedisのDB番号（FLUSHDBされる）")
+	readers            = flag.Int("readers", 1000, "ホームタイムラインを読むユーザー数")
+	celebrities        = flag.Int("celebrities", 50, "セレブアカウント数")
+	celebrityFollowers = flag.Int("celebrity-followers", 300000, "セレブ1人あたりのフォロワー数")
+	normalFollowers    = flag.Int("normal-followers", 300, "通常ユーザー1人あたりのフォロワー数")
+	followedCelebs     = flag.Int("followed-celebrities", 20, "読み手1人がフォローしているセレブ数")
+	postsPerCelebrity  = flag.Int("posts-per-celebrity", 200, "セレブ1人あたりの事前投稿数")
+	writes             = flag.Int("writes", 200, "書き込みの計測回数")
+	reads              = flag.Int("reads", 2000, "読み込みの計測回数")
+	limit              = flag.Int("limit", 20, "1ページの件数")
+)
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	config.RDB = redis.NewClient(&redis.Options{Addr: *redisAddr, DB: *redisDB})
+	if err := config.RDB.Ping(ctx).Err(); err != nil {
+		log.Fatalf("failed to connect to Redis: %v", err)
+	}
+	config.RDB.FlushDB(ctx)
+	defer config.RDB.FlushDB(ctx)
+
+	// ユーザーIDの割り当て: 1..readers が読み手、その後ろがセレブ
+	celebBase := uint(*readers) + 1
+	var nextPostID uint
+
+	fmt.Println("seeding...")
+	for i := 0; i < *celebrities; i++ {
+		for j := 0; j < *postsPerCelebrity; j++ {
+			nextPostID++
+			if err := timeline.PushOwn(ctx, celebBase+uint(i), nextPostID); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	allReaders := make([]uint, *readers)
+	for i := range allReaders {
+		allReaders[i] = uint(i + 1)
+	}
+	for i := 0; i < int(timeline.MaxEntries); i++ {
+		nextPostID++
+		if err := timeline.FanOut(ctx, nextPostID, allReaders); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 書き込み: 通常ユーザーの投稿（フォロワーへファンアウト）
+	followers := make([]uint, *normalFollowers)
+	for i := range followers {
+		followers[i] = uint(rand.Intn(*readers) + 1)
+	}
+	report("write: fan-out (normal user)", *writes, func() {
+		nextPostID++
+		if err := timeline.FanOut(ctx, nextPostID, followers); err != nil {
+			log.Fatal(err)
+		}
+	})
+
+	// 書き込み: セレブの投稿（自分のタイムラインのみ）
+	report("write: celebrity (hybrid)", *writes, func() {
+		nextPostID++
+		if err := timeline.PushOwn(ctx, celebBase, nextPostID); err != nil {
+			log.Fatal(err)
+		}
+	})
+
+	// 比較用: セレブの投稿を全フォロワーにファンアウトした場合
+	celebFollowerIDs := make([]uint, *celebrityFollowers)
+	for i := range celebFollowerIDs {
+		celebFollowerIDs[i] = uint(1000000 + i)
+	}
+	report("write: celebrity (pure fan-out)", 3, func() {
+		nextPostID++
+		if err := timeline.FanOut(ctx, nextPostID, celebFollowerIDs); err != nil {
+			log.Fatal(err)
+		}
+	})
+
+	// 読み込み: セレブをフォローしていない場合と、複数フォローしている場合
+	report("read: home only", *reads, func() {
+		if _, err := timeline.Merge(ctx, uint(rand.Intn(*readers)+1), nil, 0, *limit); err != nil {
+			log.Fatal(err)
+		}
+	})
+	report(fmt.Sprintf("read: home + %d celebrities", *followedCelebs), *reads, func() {
+		celebs := make([]uint, *followedCelebs)
+		for i := range celebs {
+			celebs[i] = celebBase + uint(rand.Intn(*celebrities))
+		}
+		if _, err := timeline.Merge(ctx, uint(rand.Intn(*readers)+1), celebs, 0, *limit); err != nil {
+			log.Fatal(err)
+		}
+	})
+	report(fmt.Sprintf("read: home + %d celebrities (5 pages)", *followedCelebs), *reads, func() {
+		celebs := make([]uint, *followedCelebs)
+		for i := range celebs {
+			celebs[i] = celebBase + uint(rand.Intn(*celebrities))
+		}
+		// 5ページ分をカーソルで順に読み進める時間を計測する
+		reader := uint(rand.Intn(*readers) + 1)
+		var maxID uint
+		for p := 0; p < 5; p++ {
+			ids, err := timeline.Merge(ctx, reader, celebs, maxID, *limit)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if next := timeline.NextCursor(ids, *limit); next != 0 {
+				maxID = next
+			}
+		}
+	})
+}
+
+// fnをn回実行し、レイテンシのパーセンタイルを表示する
+func report(name string, n int, fn func()) {
+	durations := make([]time.Duration, n)
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		fn()
+		durations[i] = time.Since(start)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	pct := func(p float64) time.Duration {
+		return durations[int(float64(n-1)*p)]
+	}
+	fmt.Printf("%-40s n=%-6d p50=%-12v p95=%-12v p99=%-12v max=%v\n",
+		name, n, pct(0.50), pct(0.95), pct(0.99), durations[n-1])
+}
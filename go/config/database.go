@@ -23,7 +23,30 @@ func MigrateDatabase() {
 	if DB == nil {
 		panic("Database connection is not initialized!")
 	}
-	DB.AutoMigrate(&models.User{})
+	DB.AutoMigrate(
+		&models.User{},
+		&models.Post{},
+		&models.Follow{},
+		&models.Like{},
+		&models.Hashtag{},
+		&models.PostHashtag{},
+		&models.Mention{},
+		&models.Notification{},
+		&models.Conversation{},
+		&models.ConversationMember{},
+		&models.Message{},
+		&models.Media{},
+		&models.DataExport{},
+		&models.Block{},
+		&models.Mute{},
+		&models.KeywordFilter{},
+		&models.SearchDocument{},
+		&models.SuggestionDismissal{},
+		&models.BookmarkCollection{},
+		&models.Bookmark{},
+		&models.List{},
+		&models.ListMember{},
+	)
 	fmt.Println("Database migrated!")
 }
 
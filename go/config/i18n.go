@@ -9,6 +9,12 @@ import (
 
 var Localizer *i18n.Localizer
 
+// ユーザーの言語でメッセージを作るときに使う
+var (
+    Bundle  *i18n.Bundle
+    appLang string
+)
+
 func InitI18n() {
     // i18n バンドルを作成
     bundle := i18n.NewBundle(language.English)
@@ -32,4 +38,15 @@ func InitI18n() {
 
     // ローカライザーを初期化
     Localizer = i18n.NewLocalizer(bundle, lang)
+    Bundle = bundle
+    appLang = lang
+}
+
+// 指定した言語（ユーザーが設定した言語など）のローカライザーを返す。
+// 言語が空の場合や翻訳がない場合はAPP_LANGの言語にする
+func LocalizerFor(lang string) *i18n.Localizer {
+    if lang == "" || Bundle == nil {
+        return Localizer
+    }
+    return i18n.NewLocalizer(Bundle, lang, appLang)
 }
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Shota0616/go-sns/storage"
+)
+
+// アップロードされたファイルの保存先
+var Storage storage.Storage
+
+// STORAGE_DRIVERがs3ならS3互換のストレージ、それ以外はローカルのディレクトリに保存する
+func ConnectStorage() {
+	switch os.Getenv("STORAGE_DRIVER") {
+	case "s3":
+		endpoint := os.Getenv("S3_ENDPOINT")
+		bucket := os.Getenv("S3_BUCKET")
+		useSSL := os.Getenv("S3_USE_SSL") == "true"
+		publicURL := os.Getenv("S3_PUBLIC_URL")
+		if publicURL == "" {
+			scheme := "http"
+			if useSSL {
+				scheme = "https"
+			}
+			publicURL = fmt.Sprintf("%s://%s/%s", scheme, endpoint, bucket)
+		}
+		s3, err := storage.NewS3(storage.S3Options{
+			Endpoint:      endpoint,
+			AccessKey:     os.Getenv("S3_ACCESS_KEY"),
+			SecretKey:     os.Getenv("S3_SECRET_KEY"),
+			Bucket:        bucket,
+			Region:        os.Getenv("S3_REGION"),
+			UseSSL:        useSSL,
+			PublicBaseURL: publicURL,
+		})
+		if err != nil {
+			panic("Failed to connect to storage: " + err.Error())
+		}
+		if err := s3.EnsureBucket(context.Background()); err != nil {
+			panic("Failed to prepare storage bucket: " + err.Error())
+		}
+		Storage = s3
+	default:
+		root := os.Getenv("MEDIA_DIR")
+		if root == "" {
+			root = "uploads"
+		}
+		key := os.Getenv("STORAGE_SIGNING_KEY")
+		if key == "" {
+			key = os.Getenv("JWT_SECRET")
+		}
+		Storage = storage.NewLocal(root, strings.TrimRight(os.Getenv("APP_URL"), "/")+"/api/media/files", []byte(key))
+	}
+}
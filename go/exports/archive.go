@@ -0,0 +1,325 @@
+package exports
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/Shota0616/go-sns/accounts"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/media"
+	"github.com/Shota0616/go-sns/models"
+	"gorm.io/gorm"
+)
+
+// 一度に読み込む行数
+const batchSize = 1000
+
+// ユーザーのデータをまとめたzipファイルを書き込む
+func writeArchive(ctx context.Context, w io.Writer, userID uint) error {
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, section := range []struct {
+		name  string
+		build func(*models.User) (interface{}, error)
+	}{
+		{"profile.json", profileData},
+		{"posts.json", postsData},
+		{"follows.json", followsData},
+		{"likes.json", likesData},
+		{"bookmarks.json", bookmarksData},
+		{"lists.json", listsData},
+		{"messages.json", messagesData},
+		{"sessions.json", func(u *models.User) (interface{}, error) { return sessionsData(ctx, u) }},
+	} {
+		data, err := section.build(&user)
+		if err != nil {
+			return err
+		}
+		if err := writeJSON(zw, section.name, data); err != nil {
+			return err
+		}
+	}
+	if err := writeMedia(ctx, zw, userID); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeJSON(zw *zip.Writer, name string, data interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
+}
+
+func profileData(u *models.User) (interface{}, error) {
+	var birthday *string
+	if u.Birthday != nil {
+		s := u.Birthday.Format("2006-01-02")
+		birthday = &s
+	}
+	return map[string]interface{}{
+		"id":                       u.ID,
+		"username":                 u.Username,
+		"email":                    u.Email,
+		"display_name":             u.DisplayName,
+		"bio":                      u.Bio,
+		"location":                 u.Location,
+		"links":                    u.Links,
+		"avatar_media_id":          u.AvatarMediaID,
+		"header_media_id":          u.HeaderMediaID,
+		"birthday":                 birthday,
+		"birthday_visibility":      u.BirthdayVisibility,
+		"language":                 u.Language,
+		"direct_message_policy":    u.DirectMessagePolicy,
+		"notification_preferences": u.NotificationPreferences,
+		"followers_count":          u.FollowersCount,
+		"following_count":          u.FollowingCount,
+		"created_at":               u.CreatedAt,
+	}, nil
+}
+
+type postData struct {
+	ID          uint      `json:"id"`
+	Body        string    `json:"body"`
+	Visibility  string    `json:"visibility"`
+	RepostOfID  *uint     `json:"repost_of_id,omitempty"`
+	QuoteOfID   *uint     `json:"quote_of_id,omitempty"`
+	InReplyToID *uint     `json:"in_reply_to_id,omitempty"`
+	MediaIDs    []uint    `json:"media_ids,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func postsData(u *models.User) (interface{}, error) {
+	mediaIDs := make(map[uint][]uint)
+	var attached []models.Media
+	err := config.DB.Select("id", "post_id").Where("user_id = ? AND post_id IS NOT NULL", u.ID).
+		Order("position").Find(&attached).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range attached {
+		mediaIDs[*m.PostID] = append(mediaIDs[*m.PostID], m.ID)
+	}
+
+	result := []postData{}
+	var batch []models.Post
+	err = config.DB.Where("user_id = ?", u.ID).Order("id").
+		FindInBatches(&batch, batchSize, func(tx *gorm.DB, n int) error {
+			for _, p := range batch {
+				result = append(result, postData{
+					ID:          p.ID,
+					Body:        p.Body,
+					Visibility:  p.Visibility,
+					RepostOfID:  p.RepostOfID,
+					QuoteOfID:   p.QuoteOfID,
+					InReplyToID: p.InReplyToID,
+					MediaIDs:    mediaIDs[p.ID],
+					CreatedAt:   p.CreatedAt,
+				})
+			}
+			return nil
+		}).Error
+	return result, err
+}
+
+type followData struct {
+	UserID    uint      `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func followsData(u *models.User) (interface{}, error) {
+	load := func(column string, other string) ([]followData, error) {
+		result := []followData{}
+		err := config.DB.Table("follows").
+			Select("users.id AS user_id, users.username, follows.created_at").
+			Joins("JOIN users ON users.id = follows."+other).
+			Where("follows."+column+" = ?", u.ID).
+			Order("follows.id").
+			Scan(&result).Error
+		return result, err
+	}
+	following, err := load("follower_id", "followee_id")
+	if err != nil {
+		return nil, err
+	}
+	followers, err := load("followee_id", "follower_id")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"following": following, "followers": followers}, nil
+}
+
+type likeData struct {
+	PostID    uint      `json:"post_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func likesData(u *models.User) (interface{}, error) {
+	result := []likeData{}
+	err := config.DB.Model(&models.Like{}).Select("post_id", "created_at").
+		Where("user_id = ?", u.ID).Order("id").Scan(&result).Error
+	return result, err
+}
+
+type bookmarkData struct {
+	PostID     uint      `json:"post_id"`
+	Collection string    `json:"collection,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ブックマークと、入っているコレクションの名前
+func bookmarksData(u *models.User) (interface{}, error) {
+	result := []bookmarkData{}
+	err := config.DB.Model(&models.Bookmark{}).
+		Select("bookmarks.post_id, COALESCE(bookmark_collections.name, '') AS collection, bookmarks.created_at").
+		Joins("LEFT JOIN bookmark_collections ON bookmark_collections.id = bookmarks.collection_id").
+		Where("bookmarks.user_id = ?", u.ID).Order("bookmarks.id").Scan(&result).Error
+	return result, err
+}
+
+type listData struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Visibility  string    `json:"visibility"`
+	Members     []string  `json:"members"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// 作成したリストと、メンバーのユーザー名
+func listsData(u *models.User) (interface{}, error) {
+	var lists []models.List
+	if err := config.DB.Where("user_id = ?", u.ID).Order("id").Find(&lists).Error; err != nil {
+		return nil, err
+	}
+	result := make([]listData, len(lists))
+	for i, l := range lists {
+		members := []string{}
+		err := config.DB.Model(&models.ListMember{}).
+			Joins("JOIN users ON users.id = list_members.user_id").
+			Where("list_members.list_id = ?", l.ID).
+			Order("list_members.id").
+			Pluck("users.username", &members).Error
+		if err != nil {
+			return nil, err
+		}
+		result[i] = listData{Name: l.Name, Description: l.Description, Visibility: l.Visibility, Members: members, CreatedAt: l.CreatedAt}
+	}
+	return result, nil
+}
+
+type messageData struct {
+	ID        uint      `json:"id"`
+	Sender    string    `json:"sender"`
+	Kind      string    `json:"kind"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type conversationData struct {
+	ID       uint          `json:"id"`
+	Kind     string        `json:"kind"`
+	Title    string        `json:"title,omitempty"`
+	Members  []string      `json:"members"`
+	Messages []messageData `json:"messages"`
+}
+
+// 参加している会話と、そのメッセージ
+func messagesData(u *models.User) (interface{}, error) {
+	var conversationIDs []uint
+	err := config.DB.Model(&models.ConversationMember{}).Where("user_id = ?", u.ID).Pluck("conversation_id", &conversationIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := []conversationData{}
+	for _, id := range conversationIDs {
+		var conv models.Conversation
+		if err := config.DB.Preload("Members.User").First(&conv, id).Error; err != nil {
+			return nil, err
+		}
+		data := conversationData{ID: conv.ID, Kind: conv.Kind, Title: conv.Title, Members: []string{}, Messages: []messageData{}}
+		for _, m := range conv.Members {
+			data.Members = append(data.Members, m.User.Username)
+		}
+		var batch []models.Message
+		err := config.DB.Preload("Sender").Where("conversation_id = ?", conv.ID).Order("id").
+			FindInBatches(&batch, batchSize, func(tx *gorm.DB, n int) error {
+				for _, m := range batch {
+					data.Messages = append(data.Messages, messageData{
+						ID:        m.ID,
+						Sender:    m.Sender.Username,
+						Kind:      m.Kind,
+						Body:      m.Body,
+						CreatedAt: m.CreatedAt,
+					})
+				}
+				return nil
+			}).Error
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+// ログインのセッションはトークンだけで管理しており、サーバーには個別の記録を持たない。
+// 持っているのは「すべての端末からログアウト」した日時だけなので、それを出力する
+func sessionsData(ctx context.Context, u *models.User) (interface{}, error) {
+	revokedAt, err := accounts.RevokedAt(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"signed_out_everywhere_at": revokedAt}, nil
+}
+
+// アップロードした画像のファイル。サイズ別の画像のうち最も大きいものを入れる
+func writeMedia(ctx context.Context, zw *zip.Writer, userID uint) error {
+	var list []models.Media
+	if err := config.DB.Where("user_id = ? AND status = ?", userID, media.StatusReady).Order("id").Find(&list).Error; err != nil {
+		return err
+	}
+	for _, m := range list {
+		var best *models.MediaVariant
+		for name := range m.Variants {
+			v := m.Variants[name]
+			if best == nil || v.Width*v.Height > best.Width*best.Height {
+				best = &v
+			}
+		}
+		if best == nil {
+			continue
+		}
+		r, err := config.Storage.Get(ctx, best.Key)
+		if err != nil {
+			return err
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     fmt.Sprintf("media/%d%s", m.ID, path.Ext(best.Key)),
+			Method:   zip.Store,
+			Modified: m.CreatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(f, r)
+		}
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
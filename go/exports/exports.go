@@ -0,0 +1,271 @@
+package exports
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Shota0616/go-sns/auth"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/realtime"
+	"github.com/Shota0616/go-sns/storage"
+	"github.com/go-redis/redis/v8"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// 作成の状態
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusFailed     = "failed"
+	StatusExpired    = "expired"
+)
+
+var (
+	// エクスポートを依頼できる間隔
+	Cooldown = 24 * time.Hour
+	// 作成したファイルをダウンロードできる期間
+	Retention = 7 * 24 * time.Hour
+	// 作成中のまま止まったエクスポートを作り直すまでの時間（ワーカーが落ちた場合など）
+	StaleAfter = 30 * time.Minute
+)
+
+var ErrTooSoon = errors.New("an export was requested recently")
+
+// 作成待ちのエクスポートIDのキュー
+const queueKey = "exports:queue"
+
+func cooldownKey(userID uint) string {
+	return fmt.Sprintf("exports:cooldown:%d", userID)
+}
+
+func randomKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// エクスポートを依頼する。一度依頼するとCooldownの間は依頼できない。
+// 依頼できない場合はErrTooSoonと次に依頼できるまでの時間を返す
+func Request(userID uint) (*models.DataExport, time.Duration, error) {
+	ctx := context.Background()
+	ok, err := config.RDB.SetNX(ctx, cooldownKey(userID), 1, Cooldown).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !ok {
+		ttl, err := config.RDB.TTL(ctx, cooldownKey(userID)).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		return nil, ttl, ErrTooSoon
+	}
+
+	e := models.DataExport{UserID: userID, Status: StatusPending}
+	if err := config.DB.Create(&e).Error; err != nil {
+		config.RDB.Del(ctx, cooldownKey(userID))
+		return nil, 0, err
+	}
+	enqueue(ctx, e.ID)
+	return &e, 0, nil
+}
+
+func enqueue(ctx context.Context, id uint) {
+	if err := config.RDB.RPush(ctx, queueKey, id).Err(); err != nil {
+		// キューに入らなかったエクスポートは次回の起動時に拾い直す
+		log.Printf("exports: failed to enqueue export %d: %v", id, err)
+	}
+}
+
+// エクスポートを作成するワーカーと、期限の過ぎたファイルを削除する処理を起動する
+func StartWorkers(n int) {
+	requeue()
+	for i := 0; i < n; i++ {
+		go work()
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			removeExpired()
+		}
+	}()
+}
+
+// 作成待ちのまま残っているエクスポートと、作成中のまま止まったエクスポートをキューに入れ直す
+func requeue() {
+	config.DB.Model(&models.DataExport{}).
+		Where("status = ? AND updated_at < ?", StatusProcessing, time.Now().Add(-StaleAfter)).
+		Update("status", StatusPending)
+
+	var ids []uint
+	if err := config.DB.Model(&models.DataExport{}).Where("status = ?", StatusPending).Pluck("id", &ids).Error; err != nil {
+		log.Printf("exports: failed to load pending exports: %v", err)
+		return
+	}
+	for _, id := range ids {
+		enqueue(context.Background(), id)
+	}
+}
+
+func work() {
+	ctx := context.Background()
+	for {
+		vals, err := config.RDB.BLPop(ctx, 5*time.Second, queueKey).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			log.Printf("exports: failed to read queue: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		var id uint
+		if _, err := fmt.Sscan(vals[1], &id); err != nil {
+			continue
+		}
+		if err := Process(id); err != nil {
+			log.Printf("exports: failed to process export %d: %v", id, err)
+		}
+	}
+}
+
+// エクスポートのzipファイルを作成して保存し、ダウンロードできるようになったことを知らせる
+func Process(id uint) error {
+	// 同じエクスポートが複数のワーカーで処理されないよう、状態を変えられたワーカーだけが処理する
+	result := config.DB.Model(&models.DataExport{}).
+		Where("id = ? AND status = ?", id, StatusPending).
+		Update("status", StatusProcessing)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	var e models.DataExport
+	if err := config.DB.First(&e, id).Error; err != nil {
+		return err
+	}
+	if err := build(&e); err != nil {
+		msg := err.Error()
+		if len(msg) > 255 {
+			msg = msg[:255]
+		}
+		config.DB.Model(&e).Updates(map[string]interface{}{"status": StatusFailed, "error": msg})
+		// 作成に失敗した場合は待たずにもう一度依頼できるようにする
+		if err := config.RDB.Del(context.Background(), cooldownKey(e.UserID)).Err(); err != nil {
+			log.Printf("exports: failed to clear cooldown for user %d: %v", e.UserID, err)
+		}
+		return err
+	}
+	notifyReady(&e)
+	return nil
+}
+
+func build(e *models.DataExport) error {
+	ctx := context.Background()
+	f, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := writeArchive(ctx, f, e.UserID); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+
+	name, err := randomKey()
+	if err != nil {
+		return err
+	}
+	key := storage.PrivatePrefix + "exports/" + name + ".zip"
+	if err := config.Storage.Put(ctx, key, f, size, "application/zip"); err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(Retention)
+	e.Status = StatusReady
+	e.Key = key
+	e.Size = size
+	e.ExpiresAt = &expiresAt
+	return config.DB.Model(e).Select("Status", "Key", "Size", "ExpiresAt").Updates(e).Error
+}
+
+// ダウンロード用の署名付きURL。期限の過ぎたエクスポートは空文字を返す
+func DownloadURL(ctx context.Context, e *models.DataExport) (string, error) {
+	if e.Status != StatusReady || e.ExpiresAt == nil {
+		return "", nil
+	}
+	ttl := time.Until(*e.ExpiresAt)
+	if ttl <= 0 {
+		return "", nil
+	}
+	return config.Storage.SignedURL(ctx, e.Key, ttl)
+}
+
+// 作成したことをリアルタイムのイベントとメールで知らせる
+func notifyReady(e *models.DataExport) {
+	realtime.Publish(e.UserID, realtime.TypeExport, exportEvent{ID: e.ID, Status: e.Status, ExpiresAt: e.ExpiresAt})
+
+	var user models.User
+	if err := config.DB.Select("id", "username", "email").First(&user, e.UserID).Error; err != nil {
+		log.Printf("exports: failed to load user %d: %v", e.UserID, err)
+		return
+	}
+	url, err := DownloadURL(context.Background(), e)
+	if err != nil {
+		log.Printf("exports: failed to sign export %d: %v", e.ID, err)
+		return
+	}
+	subject := config.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "export_ready_subject"})
+	body := config.Localizer.MustLocalize(&i18n.LocalizeConfig{
+		MessageID: "export_ready_body",
+		TemplateData: map[string]interface{}{
+			"Username": user.Username,
+			"URL":      url,
+			"Days":     int(Retention.Hours() / 24),
+		},
+	})
+	if err := auth.SendEmail(user.Email, subject, body); err != nil {
+		log.Printf("exports: failed to send email for export %d: %v", e.ID, err)
+	}
+}
+
+// リアルタイムで配信するエクスポートのイベント
+type exportEvent struct {
+	ID        uint       `json:"id"`
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// 期限の過ぎたエクスポートのファイルを削除する
+func removeExpired() {
+	var expired []models.DataExport
+	err := config.DB.Where("status = ? AND expires_at < ?", StatusReady, time.Now()).Find(&expired).Error
+	if err != nil {
+		log.Printf("exports: failed to load expired exports: %v", err)
+		return
+	}
+	for _, e := range expired {
+		if err := config.Storage.Delete(context.Background(), e.Key); err != nil {
+			log.Printf("exports: failed to delete export %d: %v", e.ID, err)
+			continue
+		}
+		config.DB.Model(&e).Updates(map[string]interface{}{"status": StatusExpired, "key": ""})
+	}
+}
@@ -0,0 +1,240 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/go-redis/redis/v8"
+)
+
+// 一致したときの動作
+const (
+	ActionHide = "hide"
+	ActionWarn = "warn"
+)
+
+// フィルターを適用する画面
+const (
+	ContextHome          = "home"
+	ContextNotifications = "notifications"
+	ContextSearch        = "search"
+)
+
+var Contexts = []string{ContextHome, ContextNotifications, ContextSearch}
+
+const (
+	// 1ユーザーが登録できるフィルターの数
+	MaxPerUser = 100
+	// フレーズの最大文字数
+	MaxPhraseLength = 100
+)
+
+// 期限付きで登録できる最長の期間
+var MaxDuration = 365 * 24 * time.Hour
+
+var (
+	ErrInvalidPhrase  = errors.New("invalid filter phrase")
+	ErrInvalidAction  = errors.New("invalid filter action")
+	ErrInvalidContext = errors.New("invalid filter context")
+	ErrInvalidExpiry  = errors.New("invalid filter expiry")
+	ErrTooMany        = errors.New("too many filters")
+	ErrNotFound       = errors.New("filter not found")
+)
+
+// フィルターの変更を他のインスタンスのキャッシュに知らせるためのバージョン
+func versionKey(userID uint) string {
+	return fmt.Sprintf("filters:version:%d", userID)
+}
+
+// 作成・更新の入力。更新では指定された項目だけを変更する
+type Input struct {
+	Phrase    *string
+	WholeWord *bool
+	Wildcard  *bool
+	Action    *string
+	Contexts  *[]string
+	// 秒数。0を指定すると期限なしにする
+	ExpiresIn *int64
+}
+
+func (in *Input) apply(f *models.KeywordFilter) error {
+	if in.Phrase != nil {
+		f.Phrase = strings.TrimSpace(*in.Phrase)
+	}
+	if in.WholeWord != nil {
+		f.WholeWord = *in.WholeWord
+	}
+	if in.Wildcard != nil {
+		f.Wildcard = *in.Wildcard
+	}
+	if in.Action != nil {
+		f.Action = *in.Action
+	}
+	if in.Contexts != nil {
+		f.Contexts = uniqueContexts(*in.Contexts)
+	}
+	if in.ExpiresIn != nil {
+		if *in.ExpiresIn < 0 || time.Duration(*in.ExpiresIn)*time.Second > MaxDuration {
+			return ErrInvalidExpiry
+		}
+		f.ExpiresAt = nil
+		if *in.ExpiresIn > 0 {
+			expiresAt := time.Now().Add(time.Duration(*in.ExpiresIn) * time.Second)
+			f.ExpiresAt = &expiresAt
+		}
+	}
+
+	if f.Phrase == "" || utf8.RuneCountInString(f.Phrase) > MaxPhraseLength {
+		return ErrInvalidPhrase
+	}
+	if _, err := compile(f.Phrase, f.Wildcard); err != nil {
+		return ErrInvalidPhrase
+	}
+	if f.Action != ActionHide && f.Action != ActionWarn {
+		return ErrInvalidAction
+	}
+	if len(f.Contexts) == 0 {
+		return ErrInvalidContext
+	}
+	for _, c := range f.Contexts {
+		if c != ContextHome && c != ContextNotifications && c != ContextSearch {
+			return ErrInvalidContext
+		}
+	}
+	return nil
+}
+
+func uniqueContexts(list []string) []string {
+	result := make([]string, 0, len(list))
+	seen := make(map[string]bool)
+	for _, c := range list {
+		if !seen[c] {
+			seen[c] = true
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// フィルターを作成する。動作と画面を省略した場合はすべての画面で隠す
+func Create(ctx context.Context, userID uint, in Input) (*models.KeywordFilter, error) {
+	var count int64
+	if err := config.DB.Model(&models.KeywordFilter{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= MaxPerUser {
+		return nil, ErrTooMany
+	}
+
+	f := models.KeywordFilter{UserID: userID, Action: ActionHide, Contexts: append([]string(nil), Contexts...)}
+	if err := in.apply(&f); err != nil {
+		return nil, err
+	}
+	if err := config.DB.Create(&f).Error; err != nil {
+		return nil, err
+	}
+	changed(ctx, userID)
+	return &f, nil
+}
+
+// フィルターを更新する
+func Update(ctx context.Context, userID uint, id uint, in Input) (*models.KeywordFilter, error) {
+	var f models.KeywordFilter
+	if err := config.DB.Where("id = ? AND user_id = ?", id, userID).First(&f).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	if err := in.apply(&f); err != nil {
+		return nil, err
+	}
+	err := config.DB.Model(&f).
+		Select("Phrase", "WholeWord", "Wildcard", "Action", "Contexts", "ExpiresAt").
+		Updates(&f).Error
+	if err != nil {
+		return nil, err
+	}
+	changed(ctx, userID)
+	return &f, nil
+}
+
+// フィルターを削除する
+func Delete(ctx context.Context, userID uint, id uint) error {
+	result := config.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.KeywordFilter{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	changed(ctx, userID)
+	return nil
+}
+
+// 登録しているフィルターの一覧。期限の切れたフィルターも含む
+func List(userID uint) ([]models.KeywordFilter, error) {
+	var list []models.KeywordFilter
+	err := config.DB.Where("user_id = ?", userID).Order("id DESC").Find(&list).Error
+	return list, err
+}
+
+// キャッシュするユーザー数の上限。超えたらキャッシュを空にする
+const maxCachedUsers = 10000
+
+type cacheEntry struct {
+	version int64
+	set     *Set
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = make(map[uint]cacheEntry)
+)
+
+// 変更を知らせて、各インスタンスのキャッシュを作り直させる
+func changed(ctx context.Context, userID uint) {
+	config.RDB.Incr(ctx, versionKey(userID))
+	cacheMu.Lock()
+	delete(cache, userID)
+	cacheMu.Unlock()
+}
+
+// ユーザーの有効なフィルターを取得する。タイムラインを表示するたびに呼ばれるため、
+// 変換済みのフィルターをインスタンスごとにキャッシュし、Redisのバージョンが変わったときだけ読み込み直す
+func ForUser(ctx context.Context, userID uint) (*Set, error) {
+	if userID == 0 {
+		return &Set{}, nil
+	}
+	version, err := config.RDB.Get(ctx, versionKey(userID)).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	cacheMu.Lock()
+	entry, ok := cache[userID]
+	cacheMu.Unlock()
+	if ok && entry.version == version && (entry.set.nextExpiry == nil || entry.set.nextExpiry.After(time.Now())) {
+		return entry.set, nil
+	}
+
+	var list []models.KeywordFilter
+	err = config.DB.Where("user_id = ? AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	set := newSet(list)
+
+	cacheMu.Lock()
+	if len(cache) >= maxCachedUsers {
+		cache = make(map[uint]cacheEntry)
+	}
+	cache[userID] = cacheEntry{version: version, set: set}
+	cacheMu.Unlock()
+	return set, nil
+}
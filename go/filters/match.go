@@ -0,0 +1,189 @@
+package filters
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Shota0616/go-sns/models"
+	"golang.org/x/text/unicode/norm"
+)
+
+// 大文字・小文字と全角・半角の違いを無視して比較するため、本文とフレーズを同じ形に揃える
+func Normalize(s string) string {
+	return strings.ToLower(norm.NFKC.String(s))
+}
+
+// 単語の一部とみなす文字。日本語（漢字・ひらがな・カタカナ）には単語の区切りがないため含めず、
+// 日本語のフレーズは単語単位の指定があっても部分一致と同じになる
+func isWordRune(r rune) bool {
+	if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) {
+		return false
+	}
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// ワイルドカードで一致させる文字（単語の一部になる文字なら日本語も含む）
+const wildcardClass = `[\p{L}\p{N}_]`
+
+// フレーズを正規表現に変換する。ワイルドカードを使う場合は「*」を0文字以上、「?」を1文字、
+// 「|」をいずれかのフレーズとして扱い、それ以外の文字はそのまま一致させる
+func compile(phrase string, wildcard bool) (*regexp.Regexp, error) {
+	phrase = Normalize(phrase)
+	if !wildcard {
+		return regexp.Compile(regexp.QuoteMeta(phrase))
+	}
+	var alternatives []string
+	for _, alt := range strings.Split(phrase, "|") {
+		alt = strings.TrimSpace(alt)
+		if strings.Trim(alt, "*?") == "" {
+			return nil, ErrInvalidPhrase
+		}
+		var b strings.Builder
+		for _, r := range alt {
+			switch r {
+			case '*':
+				b.WriteString(wildcardClass + "*")
+			case '?':
+				b.WriteString(wildcardClass)
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		alternatives = append(alternatives, b.String())
+	}
+	return regexp.Compile("(?:" + strings.Join(alternatives, "|") + ")")
+}
+
+// 変換済みのフィルター
+type matcher struct {
+	filter models.KeywordFilter
+	re     *regexp.Regexp
+}
+
+func (m *matcher) appliesTo(context string) bool {
+	for _, c := range m.filter.Contexts {
+		if c == context {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *matcher) active(now time.Time) bool {
+	return m.filter.ExpiresAt == nil || m.filter.ExpiresAt.After(now)
+}
+
+// 正規化済みの本文に一致するかどうか
+func (m *matcher) matches(text string) bool {
+	if !m.filter.WholeWord {
+		return m.re.MatchString(text)
+	}
+	for _, loc := range m.re.FindAllStringIndex(text, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		before, _ := utf8.DecodeLastRuneInString(text[:loc[0]])
+		after, _ := utf8.DecodeRuneInString(text[loc[1]:])
+		first, _ := utf8.DecodeRuneInString(text[loc[0]:loc[1]])
+		last, _ := utf8.DecodeLastRuneInString(text[loc[0]:loc[1]])
+		// 一致した部分の端が単語の文字で、その外側も単語の文字なら単語の途中とみなす
+		if loc[0] > 0 && isWordRune(first) && isWordRune(before) {
+			continue
+		}
+		if loc[1] < len(text) && isWordRune(last) && isWordRune(after) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// 一致した結果
+type Result struct {
+	// ActionHideなら表示しない、ActionWarnなら警告付きで折りたたむ。一致しなければ空文字
+	Action string
+	// 一致したフィルターのフレーズ（警告に表示する）
+	Phrases []string
+}
+
+// ユーザーのフィルターをまとめたもの
+type Set struct {
+	matchers []*matcher
+	// 最も早く期限が切れるフィルターの期限（キャッシュを作り直す目安）
+	nextExpiry *time.Time
+}
+
+func newSet(list []models.KeywordFilter) *Set {
+	s := &Set{}
+	for _, f := range list {
+		re, err := compile(f.Phrase, f.Wildcard)
+		if err != nil {
+			// 保存時に確認しているため通常は起こらない
+			continue
+		}
+		s.matchers = append(s.matchers, &matcher{filter: f, re: re})
+		if f.ExpiresAt != nil && (s.nextExpiry == nil || f.ExpiresAt.Before(*s.nextExpiry)) {
+			s.nextExpiry = f.ExpiresAt
+		}
+	}
+	return s
+}
+
+// フィルターが1件もないかどうか
+func (s *Set) Empty() bool {
+	return s == nil || len(s.matchers) == 0
+}
+
+// 本文のいずれかが指定した画面のフィルターに一致するかどうか。
+// 隠すフィルターと警告するフィルターの両方に一致した場合は隠す
+func (s *Set) Check(context string, texts ...string) Result {
+	var result Result
+	if s.Empty() {
+		return result
+	}
+	normalized := make([]string, 0, len(texts))
+	for _, t := range texts {
+		if t != "" {
+			normalized = append(normalized, Normalize(t))
+		}
+	}
+	now := time.Now()
+	for _, m := range s.matchers {
+		if !m.active(now) || !m.appliesTo(context) {
+			continue
+		}
+		for _, t := range normalized {
+			if !m.matches(t) {
+				continue
+			}
+			if m.filter.Action == ActionHide {
+				return Result{Action: ActionHide}
+			}
+			result.Action = ActionWarn
+			result.Phrases = append(result.Phrases, m.filter.Phrase)
+			break
+		}
+	}
+	return result
+}
+
+// 投稿（リポスト元・引用元の本文も含む）を確認する
+func (s *Set) CheckPost(context string, p *models.Post) Result {
+	if s.Empty() {
+		return Result{}
+	}
+	texts := []string{p.Body}
+	if p.RepostOf != nil {
+		texts = append(texts, p.RepostOf.Body)
+		if p.RepostOf.QuoteOf != nil {
+			texts = append(texts, p.RepostOf.QuoteOf.Body)
+		}
+	}
+	if p.QuoteOf != nil {
+		texts = append(texts, p.QuoteOf.Body)
+	}
+	return s.Check(context, texts...)
+}
@@ -0,0 +1,105 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/Shota0616/go-sns/models"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		phrase   string
+		wildcard bool
+		wantErr  bool
+	}{
+		{"spoiler", false, false},
+		// ワイルドカードを使わない場合、記号はそのまま一致させる
+		{"*", false, false},
+		{"a.b(c)", false, false},
+		{"spoil*", true, false},
+		{"col?r|colour", true, false},
+		// ワイルドカードだけのフレーズはすべての投稿に一致してしまうため受け付けない
+		{"*", true, true},
+		{"?*", true, true},
+		{"spoiler|*", true, true},
+		{"spoiler| ", true, true},
+	}
+	for _, tt := range tests {
+		_, err := compile(tt.phrase, tt.wildcard)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("compile(%q, %v) error = %v, wantErr %v", tt.phrase, tt.wildcard, err, tt.wantErr)
+		}
+		if tt.wantErr && err != ErrInvalidPhrase {
+			t.Errorf("compile(%q, %v) error = %v, want ErrInvalidPhrase", tt.phrase, tt.wildcard, err)
+		}
+	}
+}
+
+func TestMatcherMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		phrase    string
+		wholeWord bool
+		wildcard  bool
+		text      string
+		want      bool
+	}{
+		{"部分一致", "cat", false, false, "concatenate", true},
+		{"一致しない", "dog", false, false, "concatenate", false},
+		{"大文字・小文字を区別しない", "Spoiler", false, false, "SPOILER alert", true},
+		{"全角の英字を半角と同じに扱う", "spoiler", false, false, "ＳＰＯＩＬＥＲ", true},
+		{"半角カナを全角と同じに扱う", "ネタバレ", false, false, "ﾈﾀﾊﾞﾚ注意", true},
+		{"記号はそのまま一致させる", "a.b", false, false, "axb", false},
+		{"単語単位で一致", "cat", true, false, "a cat sat", true},
+		{"単語単位では単語の途中に一致しない", "cat", true, false, "concatenate", false},
+		{"単語単位では記号を区切りにする", "cat", true, false, "#cat!", true},
+		{"単語単位で後ろの位置に一致", "cat", true, false, "cats and a cat", true},
+		{"単語単位でも日本語は部分一致", "ネタバレ", true, false, "これはネタバレです", true},
+		{"ワイルドカード*", "spoil*", false, true, "spoiled", true},
+		{"ワイルドカード*は0文字にも一致", "spoil*", false, true, "spoil", true},
+		{"ワイルドカード?は1文字", "col?r", false, true, "color", true},
+		{"ワイルドカード?は0文字に一致しない", "col?r", false, true, "colr", false},
+		{"ワイルドカード|", "cat|dog", false, true, "hotdog", true},
+		{"ワイルドカードは空白をまたがない", "a*b", false, true, "a b", false},
+		{"ワイルドカードと単語単位", "spoil*", true, true, "unspoiled", false},
+		{"ワイルドカードと単語単位で一致", "spoil*", true, true, "it spoiled", true},
+		{"ワイルドカードで全角を半角と同じに扱う", "ｇｏ*", false, true, "golang", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := compile(tt.phrase, tt.wildcard)
+			if err != nil {
+				t.Fatalf("compile(%q) error = %v", tt.phrase, err)
+			}
+			m := &matcher{filter: models.KeywordFilter{Phrase: tt.phrase, WholeWord: tt.wholeWord, Wildcard: tt.wildcard}, re: re}
+			if got := m.matches(Normalize(tt.text)); got != tt.want {
+				t.Errorf("matches(%q) with phrase %q = %v, want %v", tt.text, tt.phrase, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetCheck(t *testing.T) {
+	set := newSet([]models.KeywordFilter{
+		{Phrase: "spoiler", Action: ActionWarn, Contexts: []string{ContextHome}},
+		{Phrase: "nsfw", Action: ActionHide, Contexts: []string{ContextHome}},
+	})
+	tests := []struct {
+		name    string
+		context string
+		texts   []string
+		want    string
+	}{
+		{"一致しない", ContextHome, []string{"hello"}, ""},
+		{"警告", ContextHome, []string{"spoiler ahead"}, ActionWarn},
+		{"隠すフィルターを優先する", ContextHome, []string{"spoiler", "nsfw"}, ActionHide},
+		{"対象外の画面", ContextNotifications, []string{"nsfw"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := set.Check(tt.context, tt.texts...); got.Action != tt.want {
+				t.Errorf("Check(%q, %q).Action = %q, want %q", tt.context, tt.texts, got.Action, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package hashtags
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ハッシュタグの最大文字数
+const MaxLength = 100
+
+// 本文中のハッシュタグ。Start/Endは本文の先頭からのUTF-16のコード単位での位置で、
+// Startは「#」の位置、Endはタグの直後の位置。クライアント（JavaScriptの文字列）でそのまま切り出せるよう、
+// 絵文字などのサロゲートペアは2として数える
+type Tag struct {
+	Name  string `json:"tag"`
+	Text  string `json:"text"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// ハッシュタグの一部として扱う文字。
+// 日本語のタグのため、かな・漢字（Letter）や長音記号、中黒も含める
+func isTagChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) || r == '_' || r == '・'
+}
+
+func isHashMark(r rune) bool {
+	return r == '#' || r == '＃'
+}
+
+// ハッシュタグを正規化する。全角英数字や半角カナを揃えて（NFKC）小文字にする
+func Normalize(tag string) string {
+	tag = strings.TrimLeftFunc(tag, isHashMark)
+	return strings.ToLower(norm.NFKC.String(tag))
+}
+
+// 「#」の直前にあるとハッシュタグとして扱わない文字（URLのフラグメントやHTMLの文字参照）
+func isTagBoundaryBreaker(r rune) bool {
+	return isTagChar(r) || isHashMark(r) || r == '/' || r == '&'
+}
+
+// runesの各位置までのUTF-16のコード単位での長さ
+func utf16Offsets(runes []rune) []int {
+	offsets := make([]int, len(runes)+1)
+	for i, r := range runes {
+		offsets[i+1] = offsets[i] + 1
+		if r >= 0x10000 {
+			offsets[i+1]++
+		}
+	}
+	return offsets
+}
+
+// 本文からハッシュタグを出現順に取り出す。
+// 「a#b」のように直前がタグに使える文字の場合や、数字だけのタグは対象外とする
+func Extract(body string) []Tag {
+	var tags []Tag
+	runes := []rune(body)
+	offsets := utf16Offsets(runes)
+	for i := 0; i < len(runes); i++ {
+		if !isHashMark(runes[i]) {
+			continue
+		}
+		if i > 0 && isTagBoundaryBreaker(runes[i-1]) {
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && isTagChar(runes[j]) {
+			j++
+		}
+		text := string(runes[i+1 : j])
+		if text == "" || j-i-1 > MaxLength || isNumeric(text) {
+			i = j - 1
+			continue
+		}
+		tags = append(tags, Tag{Name: Normalize(text), Text: text, Start: offsets[i], End: offsets[j]})
+		i = j - 1
+	}
+	return tags
+}
+
+// 本文中のハッシュタグの正規化済みの名前を重複なしで返す
+func Names(body string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, t := range Extract(body) {
+		if seen[t.Name] {
+			continue
+		}
+		seen[t.Name] = true
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func isNumeric(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
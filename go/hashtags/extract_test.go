@@ -0,0 +1,37 @@
+package hashtags
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Tag
+	}{
+		{"タグなし", "こんにちは", nil},
+		{"英字のタグ", "hello #Go world", []Tag{{Name: "go", Text: "Go", Start: 6, End: 9}}},
+		{"日本語のタグ", "#東京・カフェ巡り です", []Tag{{Name: "東京・カフェ巡り", Text: "東京・カフェ巡り", Start: 0, End: 9}}},
+		{"全角の＃と全角英数字", "＃ＧｏＬａｎｇ", []Tag{{Name: "golang", Text: "ＧｏＬａｎｇ", Start: 0, End: 7}}},
+		{"複数のタグ", "#a #b", []Tag{{Name: "a", Text: "a", Start: 0, End: 2}, {Name: "b", Text: "b", Start: 3, End: 5}}},
+		{"数字だけのタグは対象外", "#123 #1a", []Tag{{Name: "1a", Text: "1a", Start: 5, End: 8}}},
+		{"直前が文字なら対象外", "a#b", nil},
+		{"URLのフラグメントは対象外", "https://example.com/#top", nil},
+		{"HTMLの文字参照は対象外", "&#123;", nil},
+		{"#だけ", "# #", nil},
+		{"連続した#", "##tag", nil},
+		// 位置はUTF-16で数えるため、絵文字は2つ分になる
+		{"絵文字の後ろ", "😀 #go #猫", []Tag{{Name: "go", Text: "go", Start: 3, End: 6}, {Name: "猫", Text: "猫", Start: 7, End: 9}}},
+		{"絵文字を含むタグの後ろ", "#a😀b #c", []Tag{{Name: "a", Text: "a", Start: 0, End: 2}, {Name: "c", Text: "c", Start: 6, End: 8}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Extract(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Extract(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
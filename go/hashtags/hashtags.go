@@ -0,0 +1,57 @@
+package hashtags
+
+import (
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/visibility"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 投稿本文のハッシュタグを保存し、投稿と関連付ける。保存したタグの名前を返す
+func Attach(post *models.Post) ([]string, error) {
+	names := Names(post.Body)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		tags := make([]models.Hashtag, len(names))
+		for i, name := range names {
+			tags[i] = models.Hashtag{Name: name}
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tags).Error; err != nil {
+			return err
+		}
+
+		// 既存のタグはINSERTで IDが返らないため、改めて名前で引き直す
+		var ids []uint
+		if err := tx.Model(&models.Hashtag{}).Where("name IN ?", names).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		links := make([]models.PostHashtag, len(ids))
+		for i, id := range ids {
+			links[i] = models.PostHashtag{PostID: post.ID, HashtagID: id}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// ハッシュタグが付いた公開の投稿のIDを新しい順に取得する。maxIDは投稿IDによるカーソル
+func PostIDs(tag string, maxID uint, limit int) ([]uint, error) {
+	query := config.DB.Model(&models.Post{}).
+		Joins("JOIN post_hashtags ON post_hashtags.post_id = posts.id").
+		Joins("JOIN hashtags ON hashtags.id = post_hashtags.hashtag_id").
+		Where("hashtags.name = ?", Normalize(tag)).
+		Scopes(visibility.Listed)
+	if maxID > 0 {
+		query = query.Where("posts.id < ?", maxID)
+	}
+	var ids []uint
+	err := query.Order("posts.id DESC").Limit(limit).Pluck("posts.id", &ids).Error
+	return ids, err
+}
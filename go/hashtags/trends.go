@@ -0,0 +1,110 @@
+package hashtags
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// 集計の単位となる時間枠
+	BucketSize = 5 * time.Minute
+	// トレンドの集計対象とする期間（この期間の時間枠を合算する）
+	Window = 2 * time.Hour
+	// 減衰の半減期。古い時間枠ほど重みを小さくして「今」の話題を優先する
+	HalfLife = 30 * time.Minute
+	// 集計結果をキャッシュする時間
+	CacheTTL = time.Minute
+)
+
+const trendsCacheKey = "trends:tags:current"
+
+func bucketOf(t time.Time) int64 {
+	return t.Unix() / int64(BucketSize/time.Second)
+}
+
+func bucketKey(bucket int64) string {
+	return fmt.Sprintf("trends:tags:%d", bucket)
+}
+
+// 同じユーザーが同じ時間枠に同じタグを何度使っても1回として数える
+//
+// KEYS[1]: 時間枠のスコア, KEYS[2]: 時間枠内で数えたユーザーとタグの組
+// ARGV[1]: ユーザーID, ARGV[2]: キーの有効期限（秒）, ARGV[3..]: タグ
+var recordScript = redis.NewScript(`
+for i = 3, #ARGV do
+	if redis.call("SADD", KEYS[2], ARGV[i] .. ":" .. ARGV[1]) == 1 then
+		redis.call("ZINCRBY", KEYS[1], 1, ARGV[i])
+	end
+end
+redis.call("EXPIRE", KEYS[1], ARGV[2])
+redis.call("EXPIRE", KEYS[2], ARGV[2])
+return 1
+`)
+
+// ハッシュタグの使用をトレンドの集計に加える
+func RecordUsage(ctx context.Context, userID uint, names []string, at time.Time) error {
+	if len(names) == 0 {
+		return nil
+	}
+	bucket := bucketOf(at)
+	ttl := int64((Window + BucketSize) / time.Second)
+	args := make([]interface{}, 0, len(names)+2)
+	args = append(args, userID, ttl)
+	for _, n := range names {
+		args = append(args, n)
+	}
+	keys := []string{bucketKey(bucket), bucketKey(bucket) + ":users"}
+	return recordScript.Run(ctx, config.RDB, keys, args...).Err()
+}
+
+type Trend struct {
+	Tag   string  `json:"tag"`
+	Score float64 `json:"score"`
+}
+
+// 直近の期間で使われているハッシュタグを、時間枠ごとに減衰させたスコアの高い順に返す
+func Trending(ctx context.Context, limit int64) ([]Trend, error) {
+	exists, err := config.RDB.Exists(ctx, trendsCacheKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		if err := aggregate(ctx, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	vals, err := config.RDB.ZRevRangeWithScores(ctx, trendsCacheKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	trends := make([]Trend, len(vals))
+	for i, v := range vals {
+		trends[i] = Trend{Tag: v.Member.(string), Score: v.Score}
+	}
+	return trends, nil
+}
+
+// 期間内の時間枠を減衰の重みを付けて合算し、キャッシュに保存する
+func aggregate(ctx context.Context, now time.Time) error {
+	current := bucketOf(now)
+	n := int64(Window / BucketSize)
+	keys := make([]string, 0, n)
+	weights := make([]float64, 0, n)
+	for age := int64(0); age < n; age++ {
+		keys = append(keys, bucketKey(current-age))
+		elapsed := time.Duration(age) * BucketSize
+		weights = append(weights, math.Pow(0.5, float64(elapsed)/float64(HalfLife)))
+	}
+
+	pipe := config.RDB.TxPipeline()
+	pipe.ZUnionStore(ctx, trendsCacheKey, &redis.ZStore{Keys: keys, Weights: weights, Aggregate: "SUM"})
+	pipe.Expire(ctx, trendsCacheKey, CacheTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
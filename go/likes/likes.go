@@ -0,0 +1,182 @@
+package likes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MySQLにまだ反映していないいいね数の増減を持つ投稿IDの集合
+const dirtyKey = "likes:dirty"
+
+// 1回のフラッシュで処理する投稿数
+var FlushBatchSize int64 = 500
+
+// 投稿ごとの未反映のいいね数の増減
+func deltaKey(postID uint) string {
+	return fmt.Sprintf("likes:delta:%d", postID)
+}
+
+// いいね数の増減をRedisに積む。MySQLの posts.likes_count はフラッシュ時にまとめて更新する
+func addDelta(ctx context.Context, postID uint, delta int64) error {
+	pipe := config.RDB.TxPipeline()
+	pipe.IncrBy(ctx, deltaKey(postID), delta)
+	pipe.SAdd(ctx, dirtyKey, postID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 投稿にいいねする。既にいいね済みの場合は何もせずfalseを返す
+func Like(userID uint, postID uint) (bool, error) {
+	result := config.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.Like{UserID: userID, PostID: postID})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	if err := addDelta(context.Background(), postID, 1); err != nil {
+		log.Printf("likes: failed to count like on post %d: %v", postID, err)
+	}
+	return true, nil
+}
+
+// いいねを取り消す。いいねしていない場合は何もせずfalseを返す
+func Unlike(userID uint, postID uint) (bool, error) {
+	result := config.DB.Where("user_id = ? AND post_id = ?", userID, postID).Delete(&models.Like{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	if err := addDelta(context.Background(), postID, -1); err != nil {
+		log.Printf("likes: failed to count unlike on post %d: %v", postID, err)
+	}
+	return true, nil
+}
+
+// 投稿ごとのいいね数を返す（MySQLの値にRedisの未反映分を加える）
+func Counts(posts []models.Post) map[uint]int64 {
+	counts := make(map[uint]int64, len(posts))
+	if len(posts) == 0 {
+		return counts
+	}
+	keys := make([]string, len(posts))
+	for i, p := range posts {
+		counts[p.ID] = p.LikesCount
+		keys[i] = deltaKey(p.ID)
+	}
+	vals, err := config.RDB.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		log.Printf("likes: failed to load pending counts: %v", err)
+		return counts
+	}
+	for i, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if delta, err := strconv.ParseInt(s, 10, 64); err == nil {
+			counts[posts[i].ID] += delta
+		}
+	}
+	return counts
+}
+
+// 指定した投稿のうち、userIDのユーザーがいいねしている投稿IDの集合を返す
+func LikedBy(userID uint, postIDs []uint) map[uint]bool {
+	liked := make(map[uint]bool)
+	if userID == 0 || len(postIDs) == 0 {
+		return liked
+	}
+	var ids []uint
+	if err := config.DB.Model(&models.Like{}).
+		Where("user_id = ? AND post_id IN ?", userID, postIDs).
+		Pluck("post_id", &ids).Error; err != nil {
+		log.Printf("likes: failed to load likes of user %d: %v", userID, err)
+		return liked
+	}
+	for _, id := range ids {
+		liked[id] = true
+	}
+	return liked
+}
+
+// 投稿にいいねしたユーザーを新しい順に取得する。maxIDはいいねのIDによるカーソル
+func Likers(postID uint, maxID uint, limit int) ([]models.Like, error) {
+	query := config.DB.Preload("User").Where("post_id = ?", postID)
+	if maxID > 0 {
+		query = query.Where("id < ?", maxID)
+	}
+	var likes []models.Like
+	err := query.Order("id DESC").Limit(limit).Find(&likes).Error
+	return likes, err
+}
+
+// Redisに積まれたいいね数の増減をMySQLへ反映する
+func Flush(ctx context.Context) error {
+	for {
+		members, err := config.RDB.SPopN(ctx, dirtyKey, FlushBatchSize).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if len(members) == 0 {
+			return nil
+		}
+		for _, m := range members {
+			id, err := strconv.ParseUint(m, 10, 64)
+			if err != nil {
+				continue
+			}
+			if err := flushPost(ctx, uint(id)); err != nil {
+				log.Printf("likes: failed to flush post %d: %v", id, err)
+			}
+		}
+	}
+}
+
+func flushPost(ctx context.Context, postID uint) error {
+	// 増減分をRedisから取り出すと同時にキーを消す。複数のインスタンスのフラッシュが同じ増減分を
+	// 二重に反映しないよう、読み出しと削除は1つのコマンドで行う。フラッシュ中に積まれた分は次回に回す
+	delta, err := config.RDB.GetDel(ctx, deltaKey(postID)).Int64()
+	if err == redis.Nil || (err == nil && delta == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = config.DB.Model(&models.Post{}).Unscoped().Where("id = ?", postID).
+		UpdateColumn("likes_count", gorm.Expr("likes_count + ?", delta)).Error
+	if err != nil {
+		// 反映できなかった分は戻して次回に再試行する
+		if rerr := addDelta(ctx, postID, delta); rerr != nil {
+			log.Printf("likes: failed to restore delta of post %d: %v", postID, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// interval毎にいいね数をMySQLへ反映するワーカーを起動する
+func StartFlusher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := Flush(context.Background()); err != nil {
+				log.Printf("likes: flush failed: %v", err)
+			}
+		}
+	}()
+}
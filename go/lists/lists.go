@@ -0,0 +1,315 @@
+package lists
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/Shota0616/go-sns/timeline"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// リストの公開範囲
+const (
+	Public  = "public"
+	Private = "private"
+)
+
+const (
+	// 1ユーザーが作れるリストの数
+	MaxLists = 100
+	// 1つのリストに入れられるメンバーの数
+	MaxMembers = 1000
+	// リスト名・説明の最大文字数
+	MaxNameLength        = 50
+	MaxDescriptionLength = 160
+)
+
+var (
+	ErrInvalidName        = errors.New("invalid list name")
+	ErrInvalidDescription = errors.New("invalid list description")
+	ErrInvalidVisibility  = errors.New("invalid list visibility")
+	ErrTooManyLists       = errors.New("too many lists")
+	ErrTooManyMembers     = errors.New("too many list members")
+	ErrNotFound           = errors.New("list not found")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrBlocked            = errors.New("blocked user cannot be added to the list")
+)
+
+func IsValidVisibility(v string) bool {
+	return v == Public || v == Private
+}
+
+// 作成・更新の入力。更新では指定された項目だけを変更する
+type Input struct {
+	Name        *string
+	Description *string
+	Visibility  *string
+}
+
+func (in *Input) apply(l *models.List) error {
+	if in.Name != nil {
+		l.Name = strings.TrimSpace(*in.Name)
+	}
+	if in.Description != nil {
+		l.Description = strings.TrimSpace(*in.Description)
+	}
+	if in.Visibility != nil {
+		l.Visibility = *in.Visibility
+	}
+	if l.Name == "" || utf8.RuneCountInString(l.Name) > MaxNameLength {
+		return ErrInvalidName
+	}
+	if utf8.RuneCountInString(l.Description) > MaxDescriptionLength {
+		return ErrInvalidDescription
+	}
+	if !IsValidVisibility(l.Visibility) {
+		return ErrInvalidVisibility
+	}
+	return nil
+}
+
+// リストを作成する。公開範囲を指定しなければ非公開にする
+func Create(userID uint, in Input) (*models.List, error) {
+	l := models.List{UserID: userID, Visibility: Private}
+	if err := in.apply(&l); err != nil {
+		return nil, err
+	}
+	var count int64
+	if err := config.DB.Model(&models.List{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= MaxLists {
+		return nil, ErrTooManyLists
+	}
+	if err := config.DB.Create(&l).Error; err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// 自分のリストを取得する
+func Owned(userID, listID uint) (*models.List, error) {
+	var l models.List
+	err := config.DB.Where("id = ? AND user_id = ?", listID, userID).First(&l).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// 閲覧者が見られるリストを取得する。他人の非公開のリストと、作成者とブロックし合っている場合は見つからないものとして扱う
+func Get(viewerID, listID uint) (*models.List, error) {
+	var l models.List
+	err := config.DB.First(&l, listID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l.UserID == viewerID {
+		return &l, nil
+	}
+	if l.Visibility != Public {
+		return nil, ErrNotFound
+	}
+	blocked, err := relations.IsBlocked(viewerID, l.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrNotFound
+	}
+	return &l, nil
+}
+
+// リストを更新する
+func Update(userID, listID uint, in Input) (*models.List, error) {
+	l, err := Owned(userID, listID)
+	if err != nil {
+		return nil, err
+	}
+	if err := in.apply(l); err != nil {
+		return nil, err
+	}
+	if err := config.DB.Model(l).Select("Name", "Description", "Visibility").Updates(l).Error; err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// リストとメンバー、リストタイムラインを削除する
+func Delete(userID, listID uint) error {
+	l, err := Owned(userID, listID)
+	if err != nil {
+		return err
+	}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("list_id = ?", l.ID).Delete(&models.ListMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(l).Error
+	})
+	if err != nil {
+		return err
+	}
+	return config.RDB.Del(context.Background(), timeline.ListKey(l.ID)).Err()
+}
+
+// ユーザーのリストの一覧。本人以外には公開のリストだけを返す
+func Of(ownerID, viewerID uint) ([]models.List, error) {
+	query := config.DB.Where("user_id = ?", ownerID)
+	if ownerID != viewerID {
+		query = query.Where("visibility = ?", Public)
+	}
+	var list []models.List
+	err := query.Order("id DESC").Find(&list).Error
+	return list, err
+}
+
+// リストのメンバーを追加した順の新しい順に返す
+func Members(listID uint, maxID uint, limit int) ([]models.ListMember, error) {
+	query := config.DB.Preload("User").Where("list_id = ?", listID)
+	if maxID > 0 {
+		query = query.Where("id < ?", maxID)
+	}
+	var members []models.ListMember
+	err := query.Order("id DESC").Limit(limit).Find(&members).Error
+	return members, err
+}
+
+// リストにメンバーを追加する。追加済みならfalseを返す。
+// リストタイムラインは作り直さず、追加したメンバーの直近の投稿だけを取り込む
+func AddMember(userID, listID, memberID uint) (bool, error) {
+	l, err := Owned(userID, listID)
+	if err != nil {
+		return false, err
+	}
+	var member models.User
+	if err := config.DB.Where("id = ? AND is_active = ?", memberID, true).First(&member).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+	blocked, err := relations.IsBlocked(userID, member.ID)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, ErrBlocked
+	}
+
+	added := false
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		// メンバー数の上限を守るため、メンバーの追加はリストごとに直列にする
+		var locked models.List
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, l.ID).Error; err == gorm.ErrRecordNotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.ListMember{}).Where("list_id = ? AND user_id = ?", l.ID, member.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		if locked.MembersCount >= MaxMembers {
+			return ErrTooManyMembers
+		}
+		if err := tx.Create(&models.ListMember{ListID: l.ID, UserID: member.ID}).Error; err != nil {
+			return err
+		}
+		added = true
+		return tx.Model(l).UpdateColumn("members_count", gorm.Expr("members_count + 1")).Error
+	})
+	if err != nil || !added {
+		return false, err
+	}
+	go timeline.OnListAdd(l.ID, &member)
+	return true, nil
+}
+
+// リストからメンバーを外す。外したメンバーの投稿だけをリストタイムラインから取り除く
+func RemoveMember(userID, listID, memberID uint) error {
+	l, err := Owned(userID, listID)
+	if err != nil {
+		return err
+	}
+	removed, err := removeMember(l.ID, memberID)
+	if err != nil {
+		return err
+	}
+	if removed {
+		go timeline.OnListRemove(l.ID, memberID)
+	}
+	return nil
+}
+
+func removeMember(listID, memberID uint) (bool, error) {
+	removed := false
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("list_id = ? AND user_id = ?", listID, memberID).Delete(&models.ListMember{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		removed = true
+		return tx.Model(&models.List{}).Where("id = ?", listID).UpdateColumn("members_count", gorm.Expr("members_count - 1")).Error
+	})
+	return removed, err
+}
+
+// ブロックしたとき、お互いのリストから相手を外す
+func RemoveBetween(a, b uint) error {
+	for _, pair := range [][2]uint{{a, b}, {b, a}} {
+		var listIDs []uint
+		if err := config.DB.Model(&models.List{}).Where("user_id = ?", pair[0]).Pluck("id", &listIDs).Error; err != nil {
+			return err
+		}
+		for _, id := range listIDs {
+			removed, err := removeMember(id, pair[1])
+			if err != nil {
+				return err
+			}
+			if removed {
+				go timeline.OnListRemove(id, pair[1])
+			}
+		}
+	}
+	return nil
+}
+
+// 削除されたユーザーのリストと、他のユーザーのリストのメンバーから外す
+func RemoveUser(userID uint) error {
+	var listIDs []uint
+	if err := config.DB.Model(&models.List{}).Where("user_id = ?", userID).Pluck("id", &listIDs).Error; err != nil {
+		return err
+	}
+	for _, id := range listIDs {
+		if err := Delete(userID, id); err != nil {
+			return err
+		}
+	}
+	var memberOf []uint
+	if err := config.DB.Model(&models.ListMember{}).Where("user_id = ?", userID).Pluck("list_id", &memberOf).Error; err != nil {
+		return err
+	}
+	for _, id := range memberOf {
+		if _, err := removeMember(id, userID); err != nil {
+			return err
+		}
+		timeline.OnListRemove(id, userID)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package media
+
+import (
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// 向きのタグ（とその前に置く別のタグ）を持つTIFFを作る
+func tiffData(order binary.ByteOrder, orientation uint16, otherTags int) []byte {
+	b := make([]byte, 8)
+	if order == binary.LittleEndian {
+		copy(b, "II")
+	} else {
+		copy(b, "MM")
+	}
+	order.PutUint16(b[2:], 42)
+	order.PutUint32(b[4:], 8)
+
+	count := otherTags + 1
+	ifd := make([]byte, 2+count*12+4)
+	order.PutUint16(ifd, uint16(count))
+	for i := 0; i < count; i++ {
+		entry := ifd[2+i*12:]
+		tag, value := uint16(0x010F), uint16(0) // メーカー名
+		if i == otherTags {
+			tag, value = 0x0112, orientation
+		}
+		order.PutUint16(entry, tag)
+		order.PutUint16(entry[2:], 3) // SHORT
+		order.PutUint32(entry[4:], 1)
+		order.PutUint16(entry[8:], value)
+	}
+	return append(b, ifd...)
+}
+
+// JPEGのセグメントを作る
+func jpegSegment(marker byte, payload []byte) []byte {
+	s := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(s[2:], uint16(len(payload)+2))
+	return append(s, payload...)
+}
+
+func jpegFile(segments ...[]byte) []byte {
+	data := []byte{0xFF, 0xD8}
+	for _, s := range segments {
+		data = append(data, s...)
+	}
+	return append(data, 0xFF, 0xD9)
+}
+
+func exifSegment(t []byte) []byte {
+	return jpegSegment(0xE1, append([]byte("Exif\x00\x00"), t...))
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	jfif := jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	truncated := jpegFile(exifSegment(tiffData(binary.BigEndian, 6, 0)))
+	truncated = truncated[:len(truncated)-8]
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"空", nil, 1},
+		{"JPEGでない", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"EXIFなし", jpegFile(jfif), 1},
+		{"リトルエンディアン", jpegFile(exifSegment(tiffData(binary.LittleEndian, 6, 0))), 6},
+		{"ビッグエンディアン", jpegFile(exifSegment(tiffData(binary.BigEndian, 8, 0))), 8},
+		{"他のタグの後ろ", jpegFile(exifSegment(tiffData(binary.LittleEndian, 3, 2))), 3},
+		{"JFIFの後ろのEXIF", jpegFile(jfif, exifSegment(tiffData(binary.BigEndian, 5, 0))), 5},
+		{"範囲外の値", jpegFile(exifSegment(tiffData(binary.BigEndian, 9, 0))), 1},
+		{"0", jpegFile(exifSegment(tiffData(binary.BigEndian, 0, 0))), 1},
+		{"EXIF以外のAPP1", jpegFile(jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"))), 1},
+		{"途中で切れている", truncated, 1},
+		{"TIFFのヘッダーが不正", jpegFile(exifSegment([]byte("XX\x00\x2a\x00\x00\x00\x08"))), 1},
+		{"画像データの後ろのEXIFは読まない", jpegFile(jpegSegment(0xDA, []byte{0}), exifSegment(tiffData(binary.BigEndian, 6, 0))), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOrientationSize(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for orientation := 1; orientation <= 8; orientation++ {
+		b := applyOrientation(img, orientation).Bounds()
+		w, h := 4, 2
+		// 5〜8は90度回転するため縦横が入れ替わる
+		if orientation >= 5 {
+			w, h = 2, 4
+		}
+		if b.Dx() != w || b.Dy() != h {
+			t.Errorf("applyOrientation(%d) size = %dx%d, want %dx%d", orientation, b.Dx(), b.Dy(), w, h)
+		}
+	}
+}
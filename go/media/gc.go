@@ -0,0 +1,105 @@
+package media
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/storage"
+	"gorm.io/gorm"
+)
+
+var (
+	// アップロードしたまま投稿に添付されなかった画像を削除するまでの時間
+	OrphanAfter = 24 * time.Hour
+	// 保存してからこの時間が経っていないファイルは削除しない（処理中の画像のファイルを消さないため）
+	GCGrace = time.Hour
+)
+
+// 複数のインスタンスで同時に実行しないためのロック
+const gcLockKey = "media:gc:lock"
+
+// 定期的にCollectGarbageを実行する
+func StartGC(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx := context.Background()
+			ok, err := config.RDB.SetNX(ctx, gcLockKey, 1, interval).Result()
+			if err != nil || !ok {
+				continue
+			}
+			if err := CollectGarbage(ctx); err != nil {
+				log.Printf("media: garbage collection failed: %v", err)
+			}
+		}
+	}()
+}
+
+// どこからも使われていない画像とファイルを削除する
+func CollectGarbage(ctx context.Context) error {
+	if err := deleteUnreferenced(); err != nil {
+		return err
+	}
+
+	// 残っている画像が使っているファイル
+	referenced := make(map[string]bool)
+	var batch []models.Media
+	err := config.DB.Select("id", "original_key", "variants").
+		FindInBatches(&batch, 1000, func(tx *gorm.DB, n int) error {
+			for _, m := range batch {
+				if m.OriginalKey != "" {
+					referenced[m.OriginalKey] = true
+				}
+				for _, v := range m.Variants {
+					referenced[v.Key] = true
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-GCGrace)
+	removed := 0
+	for _, prefix := range []string{
+		storage.PublicPrefix + "blobs/",
+		storage.PrivatePrefix + "blobs/",
+		storage.PrivatePrefix + "originals/",
+	} {
+		err := config.Storage.List(ctx, prefix, func(o storage.Object) error {
+			if referenced[o.Key] || o.LastModified.After(cutoff) {
+				return nil
+			}
+			if err := config.Storage.Delete(ctx, o.Key); err != nil {
+				log.Printf("media: failed to delete %s: %v", o.Key, err)
+				return nil
+			}
+			removed++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if removed > 0 {
+		log.Printf("media: removed %d unreferenced files", removed)
+	}
+	return nil
+}
+
+// 投稿に添付されずに時間が経った画像と、削除された投稿の画像を削除する。
+// 処理中の画像と、プロフィールのアイコン・ヘッダーに使われている画像は残す
+func deleteUnreferenced() error {
+	return config.DB.
+		Where("status IN ? AND created_at < ?", []string{StatusReady, StatusFailed}, time.Now().Add(-OrphanAfter)).
+		Where("post_id IS NULL OR post_id IN (?)",
+			config.DB.Unscoped().Model(&models.Post{}).Select("id").Where("deleted_at IS NOT NULL")).
+		Where("id NOT IN (?)", config.DB.Model(&models.User{}).Select("avatar_media_id").Where("avatar_media_id IS NOT NULL")).
+		Where("id NOT IN (?)", config.DB.Model(&models.User{}).Select("header_media_id").Where("header_media_id IS NOT NULL")).
+		Delete(&models.Media{}).Error
+}
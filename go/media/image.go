@@ -0,0 +1,144 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// アップロードを受け付ける画像の種類
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+var (
+	// 画像の縦横それぞれの最大ピクセル数
+	MaxDimension = 10000
+	// 画像の最大画素数。ファイルは小さくても展開すると巨大になる画像（解凍爆弾）を弾く
+	MaxPixels = 40_000_000
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type")
+	ErrImageTooLarge   = errors.New("image dimensions are too large")
+)
+
+// サイズ別の画像。長辺がMaxSizeを超える場合だけ縮小する
+type variantSpec struct {
+	Name    string
+	MaxSize int
+}
+
+var variantSpecs = []variantSpec{
+	{Name: "thumb", MaxSize: 150},
+	{Name: "small", MaxSize: 400},
+	{Name: "medium", MaxSize: 1200},
+	{Name: "large", MaxSize: 2048},
+}
+
+// JPEGで書き出す時の画質
+var JPEGQuality = 85
+
+// ファイルの先頭から実際の画像の種類を判定する（クライアントが送るContent-Typeは信用しない）
+func sniff(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if !allowedTypes[contentType] {
+		return "", ErrUnsupportedType
+	}
+	return contentType, nil
+}
+
+// 画像全体を展開せずにサイズだけを読み取り、大きすぎる画像を弾く
+func checkDimensions(contentType string, data []byte) (image.Config, error) {
+	var cfg image.Config
+	var err error
+	r := bytes.NewReader(data)
+	switch contentType {
+	case "image/jpeg":
+		cfg, err = jpeg.DecodeConfig(r)
+	case "image/png":
+		cfg, err = png.DecodeConfig(r)
+	case "image/gif":
+		cfg, err = gif.DecodeConfig(r)
+	case "image/webp":
+		cfg, err = webp.DecodeConfig(r)
+	default:
+		return cfg, ErrUnsupportedType
+	}
+	if err != nil {
+		return cfg, ErrUnsupportedType
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > MaxDimension || cfg.Height > MaxDimension ||
+		cfg.Width*cfg.Height > MaxPixels {
+		return cfg, ErrImageTooLarge
+	}
+	return cfg, nil
+}
+
+// 画像を展開する。GIFアニメーションは最初のフレームだけを使う
+func decode(contentType string, data []byte) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch contentType {
+	case "image/jpeg":
+		img, err := jpeg.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return applyOrientation(img, jpegOrientation(data)), nil
+	case "image/png":
+		return png.Decode(r)
+	case "image/gif":
+		return gif.Decode(r)
+	case "image/webp":
+		return webp.Decode(r)
+	}
+	return nil, ErrUnsupportedType
+}
+
+// 長辺がmaxSize以下になるように縮小する（拡大はしない）
+func resize(img image.Image, maxSize int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*maxSize/w)
+		w = maxSize
+	} else {
+		w = max(1, w*maxSize/h)
+		h = maxSize
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// 画像を書き出す。透過を使う可能性のある形式はPNG、それ以外はJPEGにする。
+// 書き出した画像にはEXIFなどのメタデータは含まれない
+func encode(img image.Image, sourceType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if sourceType == "image/png" || sourceType == "image/gif" {
+		err := png.Encode(&buf, img)
+		return buf.Bytes(), "image/png", err
+	}
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEGQuality})
+	return buf.Bytes(), "image/jpeg", err
+}
+
+func extension(contentType string) string {
+	if contentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
@@ -0,0 +1,303 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/storage"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 処理の状態
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusFailed     = "failed"
+)
+
+var (
+	// アップロードできるファイルの最大サイズ
+	MaxUploadSize int64 = 10 << 20
+	// 1つの投稿に添付できる画像の数
+	MaxPerPost = 4
+	// 処理中のまま止まった画像を処理し直すまでの時間（ワーカーが落ちた場合など）
+	StaleAfter = 10 * time.Minute
+)
+
+var ErrInvalidAttachment = errors.New("media cannot be attached")
+
+// 処理待ちの画像IDのキュー
+const queueKey = "media:queue"
+
+func randomKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// アップロードされた画像を保存し、処理待ちのキューに入れる。
+// 同じファイルを処理済みであれば、処理せずにその結果を使う
+func Upload(userID uint, data []byte, private bool) (*models.Media, error) {
+	contentType, err := sniff(data)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := checkDimensions(contentType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	m := models.Media{
+		UserID:      userID,
+		Status:      StatusPending,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Hash:        hex.EncodeToString(sum[:]),
+		Private:     private,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+	}
+
+	var done models.Media
+	err = config.DB.Where("hash = ? AND private = ? AND status = ?", m.Hash, private, StatusReady).First(&done).Error
+	if err == nil {
+		m.Status = StatusReady
+		m.Width = done.Width
+		m.Height = done.Height
+		m.Blurhash = done.Blurhash
+		m.Variants = done.Variants
+		if err := config.DB.Create(&m).Error; err != nil {
+			return nil, err
+		}
+		return &m, nil
+	}
+
+	// 元のファイルは処理が終わると消すため、内容のハッシュではなくランダムなキーで保存する
+	name, err := randomKey()
+	if err != nil {
+		return nil, err
+	}
+	m.OriginalKey = storage.PrivatePrefix + "originals/" + name
+	ctx := context.Background()
+	if err := config.Storage.Put(ctx, m.OriginalKey, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return nil, err
+	}
+	if err := config.DB.Create(&m).Error; err != nil {
+		config.Storage.Delete(ctx, m.OriginalKey)
+		return nil, err
+	}
+	enqueue(ctx, m.ID)
+	return &m, nil
+}
+
+func enqueue(ctx context.Context, id uint) {
+	if err := config.RDB.RPush(ctx, queueKey, id).Err(); err != nil {
+		// キューに入らなかった画像は次回の起動時に拾い直す
+		log.Printf("media: failed to enqueue media %d: %v", id, err)
+	}
+}
+
+// 画像を処理するワーカーを起動する
+func StartWorkers(n int) {
+	requeue()
+	for i := 0; i < n; i++ {
+		go work()
+	}
+}
+
+// 処理待ちのまま残っている画像と、処理中のまま止まった画像をキューに入れ直す
+func requeue() {
+	config.DB.Model(&models.Media{}).
+		Where("status = ? AND updated_at < ?", StatusProcessing, time.Now().Add(-StaleAfter)).
+		Update("status", StatusPending)
+
+	var ids []uint
+	if err := config.DB.Model(&models.Media{}).Where("status = ?", StatusPending).Pluck("id", &ids).Error; err != nil {
+		log.Printf("media: failed to load pending media: %v", err)
+		return
+	}
+	for _, id := range ids {
+		enqueue(context.Background(), id)
+	}
+}
+
+func work() {
+	ctx := context.Background()
+	for {
+		vals, err := config.RDB.BLPop(ctx, 5*time.Second, queueKey).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			log.Printf("media: failed to read queue: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		var id uint
+		if _, err := fmt.Sscan(vals[1], &id); err != nil {
+			continue
+		}
+		if err := Process(id); err != nil {
+			log.Printf("media: failed to process media %d: %v", id, err)
+		}
+	}
+}
+
+// 画像を処理する。メタデータを取り除いたサイズ別の画像とBlurHashを作り、元のファイルを削除する
+func Process(id uint) error {
+	// 同じ画像が複数のワーカーで処理されないよう、状態を変えられたワーカーだけが処理する
+	result := config.DB.Model(&models.Media{}).
+		Where("id = ? AND status = ?", id, StatusPending).
+		Update("status", StatusProcessing)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	var m models.Media
+	if err := config.DB.First(&m, id).Error; err != nil {
+		return err
+	}
+	originalKey := m.OriginalKey
+	if err := process(&m); err != nil {
+		config.DB.Model(&m).Updates(map[string]interface{}{"status": StatusFailed, "error": truncate(err.Error(), 255), "original_key": ""})
+		removeOriginal(&m, originalKey)
+		return err
+	}
+	removeOriginal(&m, originalKey)
+	return nil
+}
+
+// 元のファイルにはEXIF（位置情報を含む）が残っているため、処理が終わったら成否に関わらず消す
+func removeOriginal(m *models.Media, key string) {
+	if err := config.Storage.Delete(context.Background(), key); err != nil {
+		log.Printf("media: failed to remove original of media %d: %v", m.ID, err)
+	}
+}
+
+func process(m *models.Media) error {
+	ctx := context.Background()
+	r, err := config.Storage.Get(ctx, m.OriginalKey)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	img, err := decode(m.ContentType, data)
+	if err != nil {
+		return err
+	}
+
+	prefix := storage.PublicPrefix
+	if m.Private {
+		prefix = storage.PrivatePrefix
+	}
+
+	variants := make(map[string]models.MediaVariant, len(variantSpecs))
+	for _, spec := range variantSpecs {
+		resized := resize(img, spec.MaxSize)
+		encoded, contentType, err := encode(resized, m.ContentType)
+		if err != nil {
+			return err
+		}
+		// 同じ内容の画像は同じキーになるため、既に保存されていれば保存しない
+		key := storage.ContentKey(prefix, encoded, extension(contentType))
+		if exists, err := config.Storage.Exists(ctx, key); err != nil {
+			return err
+		} else if !exists {
+			if err := config.Storage.Put(ctx, key, bytes.NewReader(encoded), int64(len(encoded)), contentType); err != nil {
+				return err
+			}
+		}
+		b := resized.Bounds()
+		variants[spec.Name] = models.MediaVariant{Key: key, ContentType: contentType, Width: b.Dx(), Height: b.Dy()}
+	}
+
+	b := img.Bounds()
+	m.Status = StatusReady
+	m.Width = b.Dx()
+	m.Height = b.Dy()
+	m.Blurhash = Blurhash(resize(img, 32), 4, 3)
+	m.Variants = variants
+	m.OriginalKey = ""
+	// JSONのシリアライザを通すため構造体で更新する
+	return config.DB.Model(m).
+		Select("status", "width", "height", "blurhash", "variants", "original_key").
+		Updates(m).Error
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// 非公開の画像の署名付きURLの有効期限
+var SignedURLTTL = time.Hour
+
+// 画像のURL。非公開の画像は期限付きの署名付きURLになる
+func VariantURL(v models.MediaVariant) string {
+	url, err := storage.URL(context.Background(), config.Storage, v.Key, SignedURLTTL)
+	if err != nil {
+		log.Printf("media: failed to sign url of %s: %v", v.Key, err)
+		return ""
+	}
+	return url
+}
+
+// 投稿に画像を添付する。自分がアップロードした、まだ添付していない画像だけを添付できる
+func Attach(tx *gorm.DB, userID uint, postID uint, mediaIDs []uint) error {
+	if len(mediaIDs) == 0 {
+		return nil
+	}
+	if len(mediaIDs) > MaxPerPost {
+		return ErrInvalidAttachment
+	}
+	for i, id := range mediaIDs {
+		result := tx.Model(&models.Media{}).
+			Where("id = ? AND user_id = ? AND post_id IS NULL AND status <> ?", id, userID, StatusFailed).
+			Updates(map[string]interface{}{"post_id": postID, "position": i})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidAttachment
+		}
+	}
+	return nil
+}
+
+// 投稿ごとに添付された画像を並び順で返す
+func ForPosts(postIDs []uint) map[uint][]models.Media {
+	byPost := make(map[uint][]models.Media)
+	if len(postIDs) == 0 {
+		return byPost
+	}
+	var list []models.Media
+	if err := config.DB.Where("post_id IN ?", postIDs).Order("position").Find(&list).Error; err != nil {
+		log.Printf("media: failed to load media of posts: %v", err)
+		return byPost
+	}
+	for _, m := range list {
+		byPost[*m.PostID] = append(byPost[*m.PostID], m)
+	}
+	return byPost
+}
@@ -0,0 +1,182 @@
+package mentions
+
+import (
+	"strings"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/relations"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm/clause"
+)
+
+// 1投稿で解決するメンションの最大数
+const MaxPerPost = 20
+
+// 本文中の@メンション。Start/Endは本文の先頭からのUTF-16のコード単位での位置で、
+// Startは「@」の位置、Endはユーザー名の直後の位置。クライアント（JavaScriptの文字列）でそのまま切り出せるよう、
+// 絵文字などのサロゲートペアは2として数える
+type Entity struct {
+	Username string `json:"username"`
+	UserID   uint   `json:"user_id"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+func isAtMark(r rune) bool {
+	return r == '@' || r == '＠'
+}
+
+// ユーザー名に使える文字。全角英数字もNFKCで半角に揃えてから判定する
+func isUsernameChar(r rune) bool {
+	r = normalizeRune(r)
+	return r == '_' || ('0' <= r && r <= '9') || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+func normalizeRune(r rune) rune {
+	if s := []rune(norm.NFKC.String(string(r))); len(s) == 1 {
+		return s[0]
+	}
+	return r
+}
+
+// runesの各位置までのUTF-16のコード単位での長さ
+func utf16Offsets(runes []rune) []int {
+	offsets := make([]int, len(runes)+1)
+	for i, r := range runes {
+		offsets[i+1] = offsets[i] + 1
+		if r >= 0x10000 {
+			offsets[i+1]++
+		}
+	}
+	return offsets
+}
+
+// ユーザー名の途中に現れうる区切り文字
+func isUsernameSeparator(r rune) bool {
+	r = normalizeRune(r)
+	return r == '.' || r == '-'
+}
+
+// 本文から@メンションを出現順に取り出す（ユーザーIDは未解決）。
+// メールアドレスのように直前が英数字の場合は対象外とする。
+// 「@john.doe」のように区切り文字の後ろにユーザー名が続く場合は、
+// 途中までの「john」を別のユーザーと取り違えないよう対象外とする
+func Extract(body string) []Entity {
+	var entities []Entity
+	runes := []rune(body)
+	offsets := utf16Offsets(runes)
+	for i := 0; i < len(runes); i++ {
+		if !isAtMark(runes[i]) {
+			continue
+		}
+		if i > 0 && (isUsernameChar(runes[i-1]) || isAtMark(runes[i-1]) || runes[i-1] == '/') {
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && isUsernameChar(runes[j]) {
+			j++
+		}
+		if j == i+1 {
+			continue
+		}
+		if j+1 < len(runes) && isUsernameSeparator(runes[j]) && isUsernameChar(runes[j+1]) {
+			i = j
+			continue
+		}
+		username := norm.NFKC.String(string(runes[i+1 : j]))
+		entities = append(entities, Entity{Username: username, Start: offsets[i], End: offsets[j]})
+		i = j - 1
+	}
+	return entities
+}
+
+// 本文中の@メンションを実在するユーザーに解決して保存し、メンションされたユーザーを返す
+func Attach(post *models.Post) ([]models.User, error) {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, e := range Extract(post.Body) {
+		key := strings.ToLower(e.Username)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		usernames = append(usernames, e.Username)
+		if len(usernames) == MaxPerPost {
+			break
+		}
+	}
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	var users []models.User
+	if err := config.DB.Where("username IN ? AND is_active = ?", usernames, true).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	// 投稿者とどちらかがブロックしているユーザーはメンションしない（リンクにも通知にもならない）
+	filter, err := relations.For(post.UserID)
+	if err != nil {
+		return nil, err
+	}
+	visible := users[:0]
+	for _, u := range users {
+		if !filter.Blocks(u.ID) {
+			visible = append(visible, u)
+		}
+	}
+	users = visible
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	rows := make([]models.Mention, len(users))
+	for i, u := range users {
+		rows[i] = models.Mention{PostID: post.ID, UserID: u.ID}
+	}
+	if err := config.DB.Clauses(clause.OnConflict{DoNothing: true}).Omit("User").Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// 投稿ごとに、解決済みのメンションを本文中の位置付きで返す
+func ForPosts(posts []models.Post) map[uint][]Entity {
+	result := make(map[uint][]Entity, len(posts))
+	ids := make([]uint, 0, len(posts))
+	for _, p := range posts {
+		result[p.ID] = []Entity{}
+		if strings.ContainsAny(p.Body, "@＠") {
+			ids = append(ids, p.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return result
+	}
+
+	var rows []models.Mention
+	if err := config.DB.Preload("User").Where("post_id IN ?", ids).Find(&rows).Error; err != nil {
+		return result
+	}
+	resolved := make(map[uint]map[string]uint)
+	for _, r := range rows {
+		if resolved[r.PostID] == nil {
+			resolved[r.PostID] = make(map[string]uint)
+		}
+		resolved[r.PostID][strings.ToLower(r.User.Username)] = r.UserID
+	}
+
+	for _, p := range posts {
+		users := resolved[p.ID]
+		if users == nil {
+			continue
+		}
+		for _, e := range Extract(p.Body) {
+			if id, ok := users[strings.ToLower(e.Username)]; ok {
+				e.UserID = id
+				result[p.ID] = append(result[p.ID], e)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package mentions
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Entity
+	}{
+		{"メンションなし", "こんにちは", nil},
+		{"先頭のメンション", "@alice hi", []Entity{{Username: "alice", Start: 0, End: 6}}},
+		{"文中のメンション", "hi @bob_1!", []Entity{{Username: "bob_1", Start: 3, End: 9}}},
+		{"全角の＠と全角英数字", "＠ａｌｉｃｅ さん", []Entity{{Username: "alice", Start: 0, End: 6}}},
+		{"日本語の直後", "こんにちは@alice", []Entity{{Username: "alice", Start: 5, End: 11}}},
+		{"複数のメンション", "@a @b", []Entity{{Username: "a", Start: 0, End: 2}, {Username: "b", Start: 3, End: 5}}},
+		{"メールアドレスは対象外", "mail@example.com", nil},
+		{"URLのパスは対象外", "https://example.com/@alice", nil},
+		{"連続した@は対象外", "@@alice", nil},
+		{"@だけ", "@ @", nil},
+		{"文末の句点", "thanks @alice.", []Entity{{Username: "alice", Start: 7, End: 13}}},
+		{"直後のハイフン", "@alice- hi", []Entity{{Username: "alice", Start: 0, End: 6}}},
+		// 先頭の「john」「foo」だけを別のユーザーとして解決しない
+		{"ドットを含む名前", "@john.doe hi", nil},
+		{"ハイフンを含む名前", "@foo-bar hi", nil},
+		{"全角のドットを含む名前", "@john．doe", nil},
+		// 位置はUTF-16で数えるため、絵文字は2つ分になる
+		{"絵文字の後ろ", "🎉 @alice 🎉 @bob", []Entity{{Username: "alice", Start: 3, End: 9}, {Username: "bob", Start: 13, End: 17}}},
+		{"区切り文字を含む名前の後ろのメンション", "@john.doe @bob", []Entity{{Username: "bob", Start: 10, End: 14}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Extract(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Extract(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
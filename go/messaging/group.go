@@ -0,0 +1,370 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// グループの最大人数
+var MaxGroupMembers = 256
+
+// グループ名の最大文字数
+const MaxTitleLength = 100
+
+// システムメッセージの種類
+const (
+	EventCreated       = "created"
+	EventMembersAdded  = "members_added"
+	EventMemberRemoved = "member_removed"
+	EventMemberLeft    = "member_left"
+)
+
+// システムメッセージの本文。表示する言語は閲覧するユーザーごとに異なるため、
+// 文章ではなく操作の内容をJSONで保存する。ユーザー名は操作時点のもの
+type SystemEvent struct {
+	Event string   `json:"event"`
+	Users []string `json:"users,omitempty"`
+	Title string   `json:"title,omitempty"`
+}
+
+// システムメッセージの本文を読み取る
+func ParseSystemEvent(m *models.Message) (SystemEvent, bool) {
+	var e SystemEvent
+	if m.Kind != MessageSystem || json.Unmarshal([]byte(m.Body), &e) != nil {
+		return e, false
+	}
+	return e, true
+}
+
+func postSystem(conv *models.Conversation, actorID uint, e SystemEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := post(conv, actorID, MessageSystem, string(body)); err != nil {
+		log.Printf("messaging: failed to post system message to conversation %d: %v", conv.ID, err)
+	}
+}
+
+// グループに追加できるユーザーを読み込む。存在しないユーザーや、
+// 追加するユーザーからのメッセージを受け付けていないユーザーがいればエラーを返す
+func loadInvitees(actorID uint, userIDs []uint) ([]models.User, error) {
+	seen := make(map[uint]bool, len(userIDs))
+	ids := make([]uint, 0, len(userIDs))
+	for _, id := range userIDs {
+		if id != actorID && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var users []models.User
+	if err := config.DB.Where("id IN ? AND is_active = ?", ids, true).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) != len(ids) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	for i := range users {
+		if ok, err := CanMessage(actorID, &users[i]); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, ErrNotAllowed
+		}
+	}
+	return users, nil
+}
+
+func usernames(users []models.User) []string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.Username
+	}
+	return names
+}
+
+// グループを作成する。作成したユーザーは管理者になる
+func CreateGroup(creatorID uint, title string, memberIDs []uint) (*models.Conversation, error) {
+	users, err := loadInvitees(creatorID, memberIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(users)+1 > MaxGroupMembers {
+		return nil, ErrGroupFull
+	}
+
+	conv := models.Conversation{Kind: KindGroup, Title: title, CreatedByID: creatorID}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Members").Create(&conv).Error; err != nil {
+			return err
+		}
+		members := []models.ConversationMember{{ConversationID: conv.ID, UserID: creatorID, Role: RoleAdmin}}
+		for _, u := range users {
+			members = append(members, models.ConversationMember{ConversationID: conv.ID, UserID: u.ID, Role: RoleMember})
+		}
+		return tx.Omit("User").Create(&members).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	postSystem(&conv, creatorID, SystemEvent{Event: EventCreated, Title: title, Users: usernames(users)})
+	return &conv, nil
+}
+
+// グループを読み込み、操作するユーザーが管理者であることを確認する
+func adminGroup(tx *gorm.DB, conversationID uint, actorID uint) (*models.Conversation, error) {
+	var conv models.Conversation
+	// 人数の上限を守るため、メンバーの変更は会話ごとに直列にする
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&conv, conversationID).Error; err != nil {
+		return nil, err
+	}
+	m, err := memberTx(tx, conversationID, actorID)
+	if err == ErrNotFound {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	if conv.Kind != KindGroup {
+		return nil, ErrNotGroup
+	}
+	if m.Role != RoleAdmin {
+		return nil, ErrNotAdmin
+	}
+	return &conv, nil
+}
+
+// グループにメンバーを追加する。既に参加しているユーザーは無視する
+func AddMembers(conversationID uint, actorID uint, userIDs []uint) error {
+	users, err := loadInvitees(actorID, userIDs)
+	if err != nil {
+		return err
+	}
+
+	var conv *models.Conversation
+	var added []models.User
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if conv, err = adminGroup(tx, conversationID, actorID); err != nil {
+			return err
+		}
+
+		var existing []uint
+		if err := tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ?", conversationID).
+			Pluck("user_id", &existing).Error; err != nil {
+			return err
+		}
+		isMember := make(map[uint]bool, len(existing))
+		for _, id := range existing {
+			isMember[id] = true
+		}
+		var members []models.ConversationMember
+		for _, u := range users {
+			if !isMember[u.ID] {
+				added = append(added, u)
+				members = append(members, models.ConversationMember{ConversationID: conversationID, UserID: u.ID, Role: RoleMember})
+			}
+		}
+		if len(members) == 0 {
+			return nil
+		}
+		if len(existing)+len(members) > MaxGroupMembers {
+			return ErrGroupFull
+		}
+		return tx.Omit("User").Create(&members).Error
+	})
+	if err != nil || len(added) == 0 {
+		return err
+	}
+
+	postSystem(conv, actorID, SystemEvent{Event: EventMembersAdded, Users: usernames(added)})
+	return nil
+}
+
+// グループからメンバーを外す。自分自身を外す場合はLeaveを使う
+func RemoveMember(conversationID uint, actorID uint, userID uint) error {
+	if actorID == userID {
+		return Leave(conversationID, userID)
+	}
+
+	var conv *models.Conversation
+	var user models.User
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if conv, err = adminGroup(tx, conversationID, actorID); err != nil {
+			return err
+		}
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		result := tx.Where("conversation_id = ? AND user_id = ?", conversationID, userID).Delete(&models.ConversationMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	removeFromInbox(conversationID, userID)
+	postSystem(conv, actorID, SystemEvent{Event: EventMemberRemoved, Users: []string{user.Username}})
+	return nil
+}
+
+// グループから退出する。最後の管理者が退出する場合は、最も古いメンバーを管理者にする
+func Leave(conversationID uint, userID uint) error {
+	var conv models.Conversation
+	var user models.User
+	remaining := int64(0)
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&conv, conversationID).Error; err != nil {
+			return err
+		}
+		m, err := memberTx(tx, conversationID, userID)
+		if err == ErrNotFound {
+			return ErrNotMember
+		}
+		if err != nil {
+			return err
+		}
+		if conv.Kind != KindGroup {
+			return ErrNotGroup
+		}
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(m).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.ConversationMember{}).Where("conversation_id = ?", conversationID).Count(&remaining).Error; err != nil {
+			return err
+		}
+		if m.Role != RoleAdmin || remaining == 0 {
+			return nil
+		}
+		var admins int64
+		if err := tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ? AND role = ?", conversationID, RoleAdmin).
+			Count(&admins).Error; err != nil {
+			return err
+		}
+		if admins > 0 {
+			return nil
+		}
+		var next models.ConversationMember
+		if err := tx.Where("conversation_id = ?", conversationID).Order("created_at, user_id").First(&next).Error; err != nil {
+			return err
+		}
+		return tx.Model(&next).Update("role", RoleAdmin).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	removeFromInbox(conversationID, userID)
+	if remaining > 0 {
+		postSystem(&conv, userID, SystemEvent{Event: EventMemberLeft, Users: []string{user.Username}})
+	}
+	return nil
+}
+
+// メンバーの役割を変更する。管理者だけが変更できる
+func SetRole(conversationID uint, actorID uint, userID uint, role string) error {
+	if role != RoleAdmin && role != RoleMember {
+		return ErrNotAllowed
+	}
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := adminGroup(tx, conversationID, actorID); err != nil {
+			return err
+		}
+		// 管理者がいなくならないよう、自分以外の管理者がいる場合だけ自分を降格できる
+		if userID == actorID && role == RoleMember {
+			var admins int64
+			if err := tx.Model(&models.ConversationMember{}).
+				Where("conversation_id = ? AND role = ?", conversationID, RoleAdmin).
+				Count(&admins).Error; err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return ErrNotAllowed
+			}
+		}
+		result := tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+			Update("role", role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 既に同じ役割の場合もRowsAffectedは0になる
+			_, err := memberTx(tx, conversationID, userID)
+			return err
+		}
+		return nil
+	})
+}
+
+func memberTx(tx *gorm.DB, conversationID uint, userID uint) (*models.ConversationMember, error) {
+	var m models.ConversationMember
+	err := tx.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&m).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	return &m, err
+}
+
+// 退出したユーザーの受信箱から会話を取り除く
+func removeFromInbox(conversationID uint, userID uint) {
+	if err := config.RDB.ZRem(context.Background(), inboxKey(userID), conversationID).Err(); err != nil {
+		log.Printf("messaging: failed to remove conversation %d from inbox of user %d: %v", conversationID, userID, err)
+	}
+}
+
+// 削除するユーザーをすべての会話から外し、送ったメッセージの本文を消す。
+// グループは退出として扱い、1対1の会話は相手の側に残す
+func RemoveUser(userID uint) error {
+	err := config.DB.Unscoped().Model(&models.Message{}).
+		Where("sender_id = ? AND kind = ?", userID, MessageText).
+		Updates(map[string]interface{}{"body": "", "deleted_at": time.Now()}).Error
+	if err != nil {
+		return err
+	}
+
+	var members []models.ConversationMember
+	if err := config.DB.Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return err
+	}
+	for _, m := range members {
+		var conv models.Conversation
+		if err := config.DB.Select("id", "kind").First(&conv, m.ConversationID).Error; err != nil {
+			return err
+		}
+		if conv.Kind == KindGroup {
+			if err := Leave(conv.ID, userID); err != nil && err != ErrNotMember {
+				return err
+			}
+			continue
+		}
+		if err := config.DB.Delete(&m).Error; err != nil {
+			return err
+		}
+	}
+	return config.RDB.Del(context.Background(), inboxKey(userID)).Err()
+}
@@ -0,0 +1,157 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/go-redis/redis/v8"
+)
+
+// 受信箱に表示する会話
+type Summary struct {
+	Conversation models.Conversation
+	LastMessage  *models.Message
+	UnreadCount  int64
+}
+
+// 受信箱の会話を最新のメッセージが新しい順に取得する。maxIDは最新のメッセージIDによるカーソル。
+// 退出済みの会話などを除くと件数がlimitに満たなくても続きがあるため、
+// 次のカーソルは除く前の受信箱のページから決める（続きがなければ0）
+func Inbox(userID uint, maxID uint, limit int) ([]Summary, uint, error) {
+	ctx := context.Background()
+	if err := ensureInbox(ctx, userID); err != nil {
+		return nil, 0, err
+	}
+
+	max := "+inf"
+	if maxID > 0 {
+		max = fmt.Sprintf("(%d", maxID)
+	}
+	vals, err := config.RDB.ZRevRangeByScoreWithScores(ctx, inboxKey(userID), &redis.ZRangeBy{
+		Min: "-inf", Max: max, Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	var next uint
+	if len(vals) == limit {
+		next = uint(vals[len(vals)-1].Score)
+	}
+	ids := make([]uint, 0, len(vals))
+	for _, v := range vals {
+		if id, err := strconv.ParseUint(v.Member.(string), 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	if len(ids) == 0 {
+		return []Summary{}, next, nil
+	}
+
+	// 受信箱に残っていても退出済みの会話は表示しない
+	var convs []models.Conversation
+	err = config.DB.Preload("Members.User").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = conversations.id AND conversation_members.user_id = ?", userID).
+		Where("conversations.id IN ?", ids).
+		Find(&convs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	lastIDs := make([]uint, len(convs))
+	for i, c := range convs {
+		lastIDs[i] = c.LastMessageID
+	}
+	var lastMessages []models.Message
+	if err := config.DB.Unscoped().Preload("Sender").Where("id IN ?", lastIDs).Find(&lastMessages).Error; err != nil {
+		return nil, 0, err
+	}
+	lastByID := make(map[uint]*models.Message, len(lastMessages))
+	for i := range lastMessages {
+		lastByID[lastMessages[i].ID] = &lastMessages[i]
+	}
+
+	unread, err := unreadCounts(userID, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byID := make(map[uint]models.Conversation, len(convs))
+	for _, c := range convs {
+		byID[c.ID] = c
+	}
+	summaries := make([]Summary, 0, len(convs))
+	for _, id := range ids {
+		c, ok := byID[id]
+		if !ok {
+			continue
+		}
+		summaries = append(summaries, Summary{
+			Conversation: c,
+			LastMessage:  lastByID[c.LastMessageID],
+			UnreadCount:  unread[id],
+		})
+	}
+	return summaries, next, nil
+}
+
+// 受信箱がRedisにない場合（再起動後など）はMySQLから作り直す
+func ensureInbox(ctx context.Context, userID uint) error {
+	exists, err := config.RDB.Exists(ctx, inboxKey(userID)).Result()
+	if err != nil || exists > 0 {
+		return err
+	}
+	var convs []models.Conversation
+	err = config.DB.Select("conversations.id", "conversations.last_message_id").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = conversations.id").
+		Where("conversation_members.user_id = ? AND conversations.last_message_id > 0", userID).
+		Find(&convs).Error
+	if err != nil || len(convs) == 0 {
+		return err
+	}
+	members := make([]*redis.Z, len(convs))
+	for i, c := range convs {
+		members[i] = &redis.Z{Score: float64(c.LastMessageID), Member: c.ID}
+	}
+	return config.RDB.ZAdd(ctx, inboxKey(userID), members...).Err()
+}
+
+// 会話ごとの未読メッセージ数（自分が送ったもの・削除されたものは除く）
+func unreadCounts(userID uint, conversationIDs []uint) (map[uint]int64, error) {
+	var rows []struct {
+		ConversationID uint
+		Count          int64
+	}
+	err := config.DB.Table("messages").
+		Select("messages.conversation_id, COUNT(*) AS count").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = messages.conversation_id AND conversation_members.user_id = ?", userID).
+		Where("messages.conversation_id IN ? AND messages.id > conversation_members.last_read_message_id", conversationIDs).
+		Where("messages.sender_id <> ? AND messages.deleted_at IS NULL", userID).
+		Group("messages.conversation_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]int64, len(rows))
+	for _, r := range rows {
+		counts[r.ConversationID] = r.Count
+	}
+	return counts, nil
+}
+
+// 未読のメッセージがある会話の数と、未読のメッセージの総数を返す
+func UnreadTotal(userID uint) (int64, int64, error) {
+	var row struct {
+		Conversations int64
+		Messages      int64
+	}
+	err := config.DB.Table("messages").
+		Select("COUNT(DISTINCT messages.conversation_id) AS conversations, COUNT(*) AS messages").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = messages.conversation_id AND conversation_members.user_id = ?", userID).
+		Where("messages.id > conversation_members.last_read_message_id").
+		Where("messages.sender_id <> ? AND messages.deleted_at IS NULL", userID).
+		Scan(&row).Error
+	return row.Conversations, row.Messages, err
+}
@@ -0,0 +1,316 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/realtime"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 会話の種類
+const (
+	KindDirect = "direct"
+	KindGroup  = "group"
+)
+
+// 参加者の役割
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+)
+
+// メッセージの種類
+const (
+	MessageText   = "text"
+	MessageSystem = "system"
+)
+
+// ダイレクトメッセージを受け付ける相手
+const (
+	PolicyEveryone  = "everyone"
+	PolicyFollowers = "followers"
+	PolicyNobody    = "nobody"
+)
+
+// メッセージ本文の最大文字数
+const MaxMessageLength = 2000
+
+var (
+	ErrNotMember   = errors.New("not a member of the conversation")
+	ErrNotAllowed  = errors.New("the recipient does not accept messages from this user")
+	ErrSelfMessage = errors.New("cannot start a conversation with yourself")
+	ErrNotFound    = gorm.ErrRecordNotFound
+	ErrNotAdmin    = errors.New("only admins can manage the conversation")
+	ErrNotGroup    = errors.New("the conversation is not a group")
+	ErrGroupFull   = errors.New("the group has reached the member limit")
+)
+
+func IsValidPolicy(policy string) bool {
+	switch policy {
+	case PolicyEveryone, PolicyFollowers, PolicyNobody:
+		return true
+	}
+	return false
+}
+
+// 会話の一覧（受信箱）のキー。メンバーは会話ID、スコアは最新のメッセージID
+func inboxKey(userID uint) string {
+	return fmt.Sprintf("inbox:%d", userID)
+}
+
+// 1対1の会話を一意に表すキー
+func directKey(a uint, b uint) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
+
+// senderがrecipientにダイレクトメッセージを送れるかどうか
+func CanMessage(senderID uint, recipient *models.User) (bool, error) {
+	// どちらかがブロックしている場合は設定に関わらず送れない
+	if blocked, err := relations.IsBlocked(senderID, recipient.ID); err != nil || blocked {
+		return false, err
+	}
+	switch recipient.DirectMessagePolicy {
+	case PolicyEveryone:
+		return true, nil
+	case PolicyNobody:
+		return false, nil
+	default:
+		// 自分をフォローしているユーザーからのみ受け付ける
+		var count int64
+		err := config.DB.Model(&models.Follow{}).
+			Where("follower_id = ? AND followee_id = ?", senderID, recipient.ID).
+			Count(&count).Error
+		return count > 0, err
+	}
+}
+
+// 2人の1対1の会話を取得する。まだなければ作成する
+func GetOrCreateDirect(senderID uint, recipientID uint) (*models.Conversation, error) {
+	if senderID == recipientID {
+		return nil, ErrSelfMessage
+	}
+	var recipient models.User
+	if err := config.DB.Where("id = ? AND is_active = ?", recipientID, true).First(&recipient).Error; err != nil {
+		return nil, err
+	}
+
+	key := directKey(senderID, recipientID)
+	var conv models.Conversation
+	err := config.DB.Where("direct_key = ?", key).First(&conv).Error
+	if err == nil {
+		return &conv, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	if ok, err := CanMessage(senderID, &recipient); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrNotAllowed
+	}
+
+	conv = models.Conversation{Kind: KindDirect, DirectKey: &key, CreatedByID: senderID}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		// 同時に作成された場合は既存の会話を使う
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&conv)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return tx.Where("direct_key = ?", key).First(&conv).Error
+		}
+		members := []models.ConversationMember{
+			{ConversationID: conv.ID, UserID: senderID, Role: RoleMember},
+			{ConversationID: conv.ID, UserID: recipientID, Role: RoleMember},
+		}
+		return tx.Omit("User").Create(&members).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// 会話の参加者を取得する。参加していなければErrNotMemberを返す
+func Member(conversationID uint, userID uint) (*models.ConversationMember, error) {
+	var m models.ConversationMember
+	err := config.DB.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&m).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// 会話の参加者のユーザーIDを返す
+func MemberIDs(conversationID uint) ([]uint, error) {
+	var ids []uint
+	err := config.DB.Model(&models.ConversationMember{}).
+		Where("conversation_id = ?", conversationID).
+		Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+// 会話にメッセージを送る
+func Send(conversationID uint, senderID uint, body string) (*models.Message, error) {
+	if _, err := Member(conversationID, senderID); err != nil {
+		return nil, err
+	}
+	var conv models.Conversation
+	if err := config.DB.First(&conv, conversationID).Error; err != nil {
+		return nil, err
+	}
+
+	// 1対1の会話では、相手の受付設定が変わっていれば送れない
+	if conv.Kind == KindDirect {
+		var other models.User
+		err := config.DB.Joins("JOIN conversation_members ON conversation_members.user_id = users.id").
+			Where("conversation_members.conversation_id = ? AND users.id <> ?", conversationID, senderID).
+			First(&other).Error
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := CanMessage(senderID, &other); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, ErrNotAllowed
+		}
+	}
+
+	return post(&conv, senderID, MessageText, body)
+}
+
+// メッセージを保存し、会話の最新メッセージと参加者の受信箱を更新する
+func post(conv *models.Conversation, senderID uint, kind string, body string) (*models.Message, error) {
+	msg := models.Message{ConversationID: conv.ID, SenderID: senderID, Kind: kind, Body: body}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Sender").Create(&msg).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Conversation{}).Where("id = ?", conv.ID).
+			Update("last_message_id", msg.ID).Error; err != nil {
+			return err
+		}
+		// 自分が送ったメッセージは既読にしておく
+		return tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ? AND user_id = ?", conv.ID, senderID).
+			Update("last_read_message_id", msg.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	conv.LastMessageID = msg.ID
+
+	config.DB.First(&msg.Sender, senderID)
+
+	memberIDs, err := MemberIDs(conv.ID)
+	if err != nil {
+		log.Printf("messaging: failed to load members of conversation %d: %v", conv.ID, err)
+		return &msg, nil
+	}
+	if err := pushInbox(context.Background(), conv.ID, msg.ID, memberIDs); err != nil {
+		log.Printf("messaging: failed to update inboxes of conversation %d: %v", conv.ID, err)
+	}
+	realtime.PublishMany(memberIDs, realtime.TypeMessage, newMessageEvent(&msg))
+	return &msg, nil
+}
+
+// リアルタイムで配信するメッセージのイベント
+type messageEvent struct {
+	ID             uint      `json:"id"`
+	ConversationID uint      `json:"conversation_id"`
+	SenderID       uint      `json:"sender_id"`
+	SenderUsername string    `json:"sender_username"`
+	Kind           string    `json:"kind"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func newMessageEvent(m *models.Message) messageEvent {
+	return messageEvent{
+		ID:             m.ID,
+		ConversationID: m.ConversationID,
+		SenderID:       m.SenderID,
+		SenderUsername: m.Sender.Username,
+		Kind:           m.Kind,
+		Body:           m.Body,
+		CreatedAt:      m.CreatedAt,
+	}
+}
+
+// 参加者の受信箱で会話を先頭に移動する。参加者が多くても1回のパイプラインで書き込む
+func pushInbox(ctx context.Context, conversationID uint, messageID uint, memberIDs []uint) error {
+	pipe := config.RDB.Pipeline()
+	for _, id := range memberIDs {
+		pipe.ZAdd(ctx, inboxKey(id), &redis.Z{Score: float64(messageID), Member: conversationID})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 会話のメッセージを新しい順に取得する。maxIDはメッセージIDによるカーソル
+func Messages(conversationID uint, userID uint, maxID uint, limit int) ([]models.Message, error) {
+	if _, err := Member(conversationID, userID); err != nil {
+		return nil, err
+	}
+	query := config.DB.Preload("Sender").Where("conversation_id = ?", conversationID)
+	if maxID > 0 {
+		query = query.Where("id < ?", maxID)
+	}
+	var messages []models.Message
+	err := query.Order("id DESC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// 自分が送ったメッセージを削除する（会話の全員から見えなくなる）
+func DeleteMessage(conversationID uint, userID uint, messageID uint) error {
+	if _, err := Member(conversationID, userID); err != nil {
+		return err
+	}
+	result := config.DB.Where("id = ? AND conversation_id = ? AND sender_id = ? AND kind = ?",
+		messageID, conversationID, userID, MessageText).Delete(&models.Message{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// 会話をmessageIDまで既読にする。messageIDが0の場合は最新のメッセージまで既読にする
+func MarkRead(conversationID uint, userID uint, messageID uint) error {
+	m, err := Member(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if messageID == 0 {
+		var conv models.Conversation
+		if err := config.DB.Select("id", "last_message_id").First(&conv, conversationID).Error; err != nil {
+			return err
+		}
+		messageID = conv.LastMessageID
+	}
+	// 既読の位置は戻さない
+	if messageID <= m.LastReadMessageID {
+		return nil
+	}
+	return config.DB.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ? AND last_read_message_id < ?", conversationID, userID, messageID).
+		Update("last_read_message_id", messageID).Error
+}
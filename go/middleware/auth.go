@@ -3,6 +3,7 @@ package middleware
 import (
     "net/http"
     "github.com/gin-gonic/gin"
+    "github.com/Shota0616/go-sns/accounts"
     "github.com/Shota0616/go-sns/auth"
     "log"
 )
@@ -27,7 +28,29 @@ func AuthRequired() gin.HandlerFunc {
             return
         }
 
+        // ログアウトさせたセッションのトークンは使えない
+        if revoked, err := accounts.IsRevoked(c.Request.Context(), claims); err != nil || revoked {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+            c.Abort()
+            return
+        }
+
         c.Set("id", claims.ID)
         c.Next()
     }
 }
+
+// ログインしていなくても使えるルート用。トークンが正しければAuthRequiredと同じくidを設定する
+func OptionalAuth() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        token := c.GetHeader("Authorization")
+        if token != "" {
+            if claims, err := auth.ValidateJWT(token); err == nil {
+                if revoked, err := accounts.IsRevoked(c.Request.Context(), claims); err == nil && !revoked {
+                    c.Set("id", claims.ID)
+                }
+            }
+        }
+        c.Next()
+    }
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// ブロック。ブロックした側とされた側はお互いの投稿やプロフィールを見られず、フォローやメッセージもできない
+type Block struct {
+	ID        uint `gorm:"primaryKey"`
+	BlockerID uint `gorm:"uniqueIndex:idx_blocks_pair"`
+	BlockedID uint `gorm:"uniqueIndex:idx_blocks_pair;index"`
+	CreatedAt time.Time
+	Blocked   User `gorm:"foreignKey:BlockedID"`
+}
+
+// ミュート。ミュートした側のタイムラインと通知にだけ影響し、された側には分からない。
+// ExpiresAtが設定されている場合はその日時を過ぎると解除される
+type Mute struct {
+	ID        uint `gorm:"primaryKey"`
+	MuterID   uint `gorm:"uniqueIndex:idx_mutes_pair"`
+	MutedID   uint `gorm:"uniqueIndex:idx_mutes_pair"`
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+	Muted     User `gorm:"foreignKey:MutedID"`
+}
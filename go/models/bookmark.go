@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// ブックマークのコレクション。本人にしか見えない
+type BookmarkCollection struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"uniqueIndex:idx_bookmark_collections_name"`
+	Name      string `gorm:"type:varchar(50);uniqueIndex:idx_bookmark_collections_name"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ブックマーク。1つの投稿は1つのコレクションにだけ入る。CollectionIDがnilの場合はどのコレクションにも入っていない
+type Bookmark struct {
+	ID           uint  `gorm:"primaryKey"`
+	UserID       uint  `gorm:"uniqueIndex:idx_bookmarks_pair"`
+	PostID       uint  `gorm:"uniqueIndex:idx_bookmarks_pair;index"`
+	CollectionID *uint `gorm:"index"`
+	CreatedAt    time.Time
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 会話。1対1の会話はDirectKeyに2人のユーザーIDを小さい順に並べた値を持ち、同じ2人の会話は1つだけになる
+type Conversation struct {
+	ID            uint    `gorm:"primaryKey"`
+	Kind          string  `gorm:"type:varchar(16)"`
+	DirectKey     *string `gorm:"type:varchar(64);unique"`
+	Title         string  `gorm:"type:varchar(255)"`
+	CreatedByID   uint
+	LastMessageID uint `gorm:"default:0"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Members       []ConversationMember
+}
+
+// 会話の参加者。LastReadMessageIDまでのメッセージを既読とする
+type ConversationMember struct {
+	ConversationID    uint   `gorm:"primaryKey"`
+	UserID            uint   `gorm:"primaryKey;index"`
+	Role              string `gorm:"type:varchar(16)"`
+	LastReadMessageID uint   `gorm:"default:0"`
+	CreatedAt         time.Time
+	User              User
+}
+
+// メッセージ。KindがsystemのメッセージはSenderIDの操作（参加・退出など）を表す
+type Message struct {
+	ID             uint `gorm:"primaryKey"`
+	ConversationID uint `gorm:"index"`
+	SenderID       uint
+	Kind           string `gorm:"type:varchar(16)"`
+	Body           string `gorm:"type:text"`
+	CreatedAt      time.Time
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
+	Sender         User
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// 個人データのエクスポート。作成したzipファイルはKeyに保存し、ExpiresAtを過ぎると削除する
+type DataExport struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Status    string `gorm:"type:varchar(16);index"`
+	Key       string `gorm:"type:varchar(255)"`
+	Size      int64
+	Error     string     `gorm:"type:varchar(255)"`
+	ExpiresAt *time.Time `gorm:"index"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
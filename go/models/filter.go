@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// キーワードフィルター。Phraseに一致する投稿をContextsの画面で隠す（Actionがhide）か、
+// 警告付きで折りたたんで表示する（Actionがwarn）。ExpiresAtを過ぎると無効になる。
+// WholeWordは単語単位で一致させるか、Wildcardは「*」「?」「|」を特別な文字として扱うか
+type KeywordFilter struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Phrase    string `gorm:"type:varchar(100)"`
+	WholeWord bool
+	Wildcard  bool
+	Action    string   `gorm:"type:varchar(16)"`
+	Contexts  []string `gorm:"type:text;serializer:json"`
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
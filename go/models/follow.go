@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// フォロー関係。解除時は物理削除する（再フォローでユニーク制約に当たらないように）
+type Follow struct {
+	ID         uint `gorm:"primaryKey"`
+	FollowerID uint `gorm:"uniqueIndex:idx_follows_pair"`
+	FolloweeID uint `gorm:"uniqueIndex:idx_follows_pair;index"`
+	CreatedAt  time.Time
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ハッシュタグ。Nameは正規化済み（NFKC・小文字）の値
+type Hashtag struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"type:varchar(191);unique"`
+	CreatedAt time.Time
+}
+
+// 投稿とハッシュタグの関連。タグページはhashtag_id, post_idの順で引く
+type PostHashtag struct {
+	PostID    uint `gorm:"primaryKey;index:idx_post_hashtags_tag_post,priority:2"`
+	HashtagID uint `gorm:"primaryKey;index:idx_post_hashtags_tag_post,priority:1"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// いいね。1ユーザーにつき1投稿1件まで（取り消し時は物理削除する）
+type Like struct {
+	ID        uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"uniqueIndex:idx_likes_user_post"`
+	PostID    uint `gorm:"uniqueIndex:idx_likes_user_post;index"`
+	CreatedAt time.Time
+	User      User
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// ユーザーが作成したリスト。公開のリストは誰でも見られ、非公開のリストは作成者にしか見えない
+type List struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"index"`
+	Name         string `gorm:"type:varchar(50)"`
+	Description  string `gorm:"type:varchar(160)"`
+	Visibility   string `gorm:"type:varchar(16);default:private"`
+	MembersCount int64  `gorm:"default:0"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// リストのメンバー。外したときは物理削除する（再追加でユニーク制約に当たらないように）
+type ListMember struct {
+	ID        uint `gorm:"primaryKey"`
+	ListID    uint `gorm:"uniqueIndex:idx_list_members_pair"`
+	UserID    uint `gorm:"uniqueIndex:idx_list_members_pair;index"`
+	CreatedAt time.Time
+	User      User `gorm:"foreignKey:UserID"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// アップロードされた画像。処理が終わるまでは元のファイルだけを持ち、
+// 処理が終わるとサイズ別の画像（Variants）とプレースホルダー（Blurhash）を持つ。
+// Hashはアップロードされたファイルのハッシュで、同じファイルの処理結果を使い回すのに使う。
+// Privateの画像は署名付きの期限のあるURLでのみ配信する
+type Media struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index"`
+	PostID      *uint  `gorm:"index"`
+	Position    int    `gorm:"default:0"`
+	Status      string `gorm:"type:varchar(16);index"`
+	ContentType string `gorm:"type:varchar(64)"`
+	Size        int64
+	Hash        string `gorm:"type:varchar(64);index"`
+	Private     bool   `gorm:"default:false"`
+	Width       int
+	Height      int
+	Blurhash    string                  `gorm:"type:varchar(64)"`
+	OriginalKey string                  `gorm:"type:varchar(255)"`
+	Variants    map[string]MediaVariant `gorm:"type:text;serializer:json"`
+	Error       string                  `gorm:"type:varchar(255)"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// サイズ別の画像
+type MediaVariant struct {
+	Key         string `json:"key"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
@@ -0,0 +1,8 @@
+package models
+
+// 投稿内で@メンションされ、実在するユーザーとして解決できたもの
+type Mention struct {
+	PostID uint `gorm:"primaryKey"`
+	UserID uint `gorm:"primaryKey;index"`
+	User   User
+}
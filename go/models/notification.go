@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// 通知。UserIDが通知を受け取るユーザー、ActorIDが通知のきっかけとなったユーザー。
+// GroupKeyが同じ通知は「AさんとほかN人が…」のように1件にまとめて表示する
+type Notification struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"uniqueIndex:idx_notifications_group;index:idx_notifications_user_read"`
+	GroupKey  string `gorm:"type:varchar(64);uniqueIndex:idx_notifications_group"`
+	ActorID   uint   `gorm:"uniqueIndex:idx_notifications_group"`
+	Type      string `gorm:"type:varchar(32)"`
+	PostID    *uint
+	CreatedAt time.Time
+	ReadAt    *time.Time `gorm:"index:idx_notifications_user_read"`
+	Actor     User
+	Post      *Post
+}
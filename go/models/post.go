@@ -0,0 +1,30 @@
+package models
+
+import "gorm.io/gorm"
+
+// 投稿。RepostOfIDが設定されている場合は本文を持たない単純なリポスト、
+// QuoteOfIDが設定されている場合は他の投稿を引用した投稿、
+// InReplyToIDが設定されている場合は他の投稿への返信を表す。
+// Visibilityは公開範囲（visibilityパッケージの定数のいずれか）
+type Post struct {
+	gorm.Model
+	UserID       uint   `gorm:"index;uniqueIndex:idx_posts_user_repost"`
+	Body         string `gorm:"type:text"`
+	LikesCount   int64  `gorm:"default:0"`
+	RepostsCount int64  `gorm:"default:0"`
+	RepostOfID   *uint  `gorm:"index;uniqueIndex:idx_posts_user_repost"`
+	QuoteOfID    *uint  `gorm:"index"`
+	InReplyToID  *uint  `gorm:"index"`
+	Visibility   string `gorm:"type:varchar(16);default:public;index"`
+	User         User
+	RepostOf     *Post
+	QuoteOf      *Post
+}
+
+// リポストであれば元の投稿のIDを、そうでなければ自身のIDを返す
+func (p *Post) ContentID() uint {
+	if p.RepostOfID != nil {
+		return *p.RepostOfID
+	}
+	return p.ID
+}
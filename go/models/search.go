@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// 投稿の全文検索用のインデックス。本文は正規化して保存し、MySQLのngramパーサーで分かち書きする
+// （日本語のように単語の区切りに空白を使わない文章も検索できる）。
+// 投稿の作成・削除時に非同期で更新するため、投稿とは別のテーブルにしている
+type SearchDocument struct {
+	PostID    uint      `gorm:"primaryKey;autoIncrement:false"`
+	UserID    uint      `gorm:"index"`
+	Body      string    `gorm:"type:text;index:idx_search_documents_body,class:FULLTEXT,option:WITH PARSER ngram"`
+	CreatedAt time.Time `gorm:"index"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// おすすめのユーザーから外した相手。外した相手は再びおすすめに出さない
+type SuggestionDismissal struct {
+	ID          uint `gorm:"primaryKey"`
+	UserID      uint `gorm:"uniqueIndex:idx_suggestion_dismissals_pair"`
+	DismissedID uint `gorm:"uniqueIndex:idx_suggestion_dismissals_pair;index"`
+	CreatedAt   time.Time
+}
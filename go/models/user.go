@@ -1,12 +1,38 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
-	ID       uint   `gorm:"primaryKey"`
-	Username string `gorm:"type:varchar(255);unique"`
-	Email    string `gorm:"type:varchar(255);unique"`
-	Password string `gorm:"type:varchar(255)"`
-	IsActive bool
+	ID             uint   `gorm:"primaryKey"`
+	Username       string `gorm:"type:varchar(255);unique"`
+	Email          string `gorm:"type:varchar(255);unique"`
+	Password       string `gorm:"type:varchar(255)"`
+	IsActive       bool
+	FollowersCount int64 `gorm:"default:0;index"`
+	FollowingCount int64 `gorm:"default:0"`
+	// 通知の種類ごとの受け取り方（in_app, email, digest, none）
+	NotificationPreferences map[string]string `gorm:"type:text;serializer:json"`
+	LastDigestAt            *time.Time
+	// ダイレクトメッセージを受け付ける相手（everyone, followers, nobody）
+	DirectMessagePolicy string `gorm:"type:varchar(16);default:followers"`
+	// プロフィール。アイコンとヘッダーはアップロードした画像（Media）のID
+	DisplayName   string   `gorm:"type:varchar(50)"`
+	Bio           string   `gorm:"type:text"`
+	Location      string   `gorm:"type:varchar(30)"`
+	Links         []string `gorm:"type:text;serializer:json"`
+	AvatarMediaID *uint
+	HeaderMediaID *uint
+	Birthday      *time.Time `gorm:"type:date"`
+	// 誕生日を見せる相手（public, followers, private）
+	BirthdayVisibility string `gorm:"type:varchar(16);default:private"`
+	// 主に使う言語（jaやenなどの言語コード）。おすすめのユーザーを選ぶのに使う
+	Language string `gorm:"type:varchar(8);index"`
+	// アカウントの削除を依頼した日時。猶予期間が過ぎるとデータを削除し、PurgedAtを設定する
+	DeletionScheduledAt *time.Time `gorm:"index"`
+	PurgedAt            *time.Time `gorm:"index"`
 }
@@ -0,0 +1,106 @@
+package notifications
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shota0616/go-sns/auth"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+)
+
+// ダイジェストメールを送る時刻（ローカル時間の時）
+var DigestHour = 8
+
+// 1通のダイジェストに載せる最大のグループ数
+const digestMaxGroups = 50
+
+// 毎日DigestHourにダイジェストメールを送るスケジューラを起動する
+func StartDigestScheduler() {
+	go func() {
+		for {
+			now := time.Now()
+			next := time.Date(now.Year(), now.Month(), now.Day(), DigestHour, 0, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(time.Until(next))
+			SendDigests(next)
+		}
+	}()
+}
+
+// ダイジェストを受け取る設定のユーザー全員にダイジェストメールを送る
+func SendDigests(at time.Time) {
+	// 複数のAPIサーバーが動いていても1日1回だけ送るようにロックを取る
+	lockKey := "notifications:digest:" + at.Format("20060102")
+	ok, err := config.RDB.SetNX(context.Background(), lockKey, 1, 23*time.Hour).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	var users []models.User
+	config.DB.Select("id", "email", "notification_preferences", "language", "last_digest_at").
+		Where("is_active = ? AND notification_preferences LIKE ?", true, "%\""+DeliveryDigest+"\"%").
+		FindInBatches(&users, 100, func(tx *gorm.DB, batch int) error {
+			for i := range users {
+				if err := sendDigest(&users[i], at); err != nil {
+					log.Printf("notifications: failed to send digest to user %d: %v", users[i].ID, err)
+				}
+			}
+			return nil
+		})
+}
+
+// 前回のダイジェスト以降の未読の通知をまとめて、ユーザーの言語のメールで送る
+func sendDigest(user *models.User, at time.Time) error {
+	since := at.Add(-24 * time.Hour)
+	if user.LastDigestAt != nil {
+		since = *user.LastDigestAt
+	}
+
+	groups, err := List(user.ID, 0, digestMaxGroups)
+	if err != nil {
+		return err
+	}
+	localizer := config.LocalizerFor(user.Language)
+	var lines []string
+	for _, g := range groups {
+		if !g.Unread || !g.CreatedAt.After(since) || Delivery(user, g.Type) != DeliveryDigest {
+			continue
+		}
+		lines = append(lines, "- "+localizedMessage(localizer, g))
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	footer, err := unsubscribeFooter(localizer, user.ID)
+	if err != nil {
+		return err
+	}
+	subject := localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "notification_digest_subject"})
+	intro := localizer.MustLocalize(&i18n.LocalizeConfig{
+		MessageID:    "notification_digest_intro",
+		TemplateData: map[string]interface{}{"Count": len(lines)},
+	})
+	body := strings.Join([]string{
+		intro,
+		"",
+		strings.Join(lines, "\n"),
+		"",
+		os.Getenv("APP_URL"),
+		"",
+		footer,
+	}, "\n")
+	if err := auth.SendEmail(user.Email, subject, body); err != nil {
+		return err
+	}
+
+	return config.DB.Model(user).Update("last_digest_at", at).Error
+}
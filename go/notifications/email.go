@@ -0,0 +1,78 @@
+package notifications
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shota0616/go-sns/auth"
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// 配信停止リンクのトークンの用途と有効期限
+const (
+	UnsubscribePurpose = "unsubscribe"
+	unsubscribeTTL     = 90 * 24 * time.Hour
+)
+
+// ログインせずにメール通知を停止できるURLを生成する
+func UnsubscribeURL(userID uint) (string, error) {
+	token, err := auth.GeneratePurposeToken(UnsubscribePurpose, userID, unsubscribeTTL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/notifications/unsubscribe?token=%s", os.Getenv("APP_URL"), token), nil
+}
+
+// メール本文の末尾に付ける配信停止の案内
+func unsubscribeFooter(localizer *i18n.Localizer, userID uint) (string, error) {
+	url, err := UnsubscribeURL(userID)
+	if err != nil {
+		return "", err
+	}
+	return localizer.MustLocalize(&i18n.LocalizeConfig{
+		MessageID:    "notification_email_unsubscribe",
+		TemplateData: map[string]interface{}{"URL": url},
+	}), nil
+}
+
+// 通知をすぐにメールで送る
+func sendImmediate(recipient *models.User, n *models.Notification) {
+	var actor models.User
+	if err := config.DB.Select("id", "username").First(&actor, n.ActorID).Error; err != nil {
+		log.Printf("notifications: failed to load actor %d: %v", n.ActorID, err)
+		return
+	}
+	localizer := config.LocalizerFor(recipient.Language)
+	message := localizedMessage(localizer, Group{Type: n.Type, ActorsCount: 1, Actors: []models.User{actor}})
+
+	footer, err := unsubscribeFooter(localizer, recipient.ID)
+	if err != nil {
+		log.Printf("notifications: failed to generate unsubscribe link: %v", err)
+		return
+	}
+	body := strings.Join([]string{message, os.Getenv("APP_URL"), "", footer}, "\n")
+	if err := auth.SendEmail(recipient.Email, message, body); err != nil {
+		log.Printf("notifications: failed to send email to user %d: %v", recipient.ID, err)
+	}
+}
+
+// メール通知をすべて停止する（メール・ダイジェストの設定をアプリ内のみに戻す）
+func Unsubscribe(userID uint) error {
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return err
+	}
+	prefs := Preferences(&user)
+	for t, d := range prefs {
+		if d == DeliveryEmail || d == DeliveryDigest {
+			prefs[t] = DeliveryInApp
+		}
+	}
+	user.NotificationPreferences = prefs
+	return config.DB.Model(&user).Select("notification_preferences").Updates(&user).Error
+}
@@ -0,0 +1,31 @@
+package notifications
+
+import (
+	"github.com/Shota0616/go-sns/config"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// 「AさんとほかN人があなたの投稿をいいねしました」のような通知の文言を返す
+func Message(g Group) string {
+	return localizedMessage(config.Localizer, g)
+}
+
+// 指定したローカライザーで通知の文面を作る（メールで送るときは受け取るユーザーの言語にする）
+func localizedMessage(localizer *i18n.Localizer, g Group) string {
+	data := map[string]interface{}{"Others": g.ActorsCount - 1}
+	if len(g.Actors) > 0 {
+		data["Actor"] = g.Actors[0].Username
+	}
+	if len(g.Actors) > 1 {
+		data["Second"] = g.Actors[1].Username
+	}
+
+	messageID := "notification_" + g.Type
+	switch {
+	case g.ActorsCount == 2:
+		messageID += "_pair"
+	case g.ActorsCount > 2:
+		messageID += "_grouped"
+	}
+	return localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: messageID, TemplateData: data})
+}
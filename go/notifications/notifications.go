@@ -0,0 +1,251 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/realtime"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 通知の種類
+const (
+	TypeFollow  = "follow"
+	TypeLike    = "like"
+	TypeReply   = "reply"
+	TypeMention = "mention"
+	TypeRepost  = "repost"
+)
+
+// グループごとに返す最大のユーザー数（「AさんとBさんとほかN人」の表示用）
+const ActorsPerGroup = 3
+
+func unreadKey(userID uint) string {
+	return fmt.Sprintf("notifications:unread:%d", userID)
+}
+
+// まとめて表示する通知のキー。
+// フォローは日ごと、いいね・リポストは投稿と日ごとにまとめ、メンションと返信はまとめない
+func groupKey(notificationType string, postID *uint, at time.Time) string {
+	day := at.Format("20060102")
+	switch notificationType {
+	case TypeFollow:
+		return fmt.Sprintf("%s:%s", notificationType, day)
+	case TypeLike, TypeRepost:
+		return fmt.Sprintf("%s:%d:%s", notificationType, *postID, day)
+	default:
+		return fmt.Sprintf("%s:%d", notificationType, *postID)
+	}
+}
+
+// 未読数のキャッシュがある場合だけ増減させる（ない場合は次回の取得時にMySQLから数え直す）
+var adjustUnreadScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return nil
+end
+local n = redis.call("INCRBY", KEYS[1], ARGV[1])
+if n < 0 then
+	redis.call("SET", KEYS[1], 0)
+	return 0
+end
+return n
+`)
+
+func adjustUnread(ctx context.Context, userID uint, delta int64) {
+	err := adjustUnreadScript.Run(ctx, config.RDB, []string{unreadKey(userID)}, delta).Err()
+	if err != nil && err != redis.Nil {
+		log.Printf("notifications: failed to adjust unread count of user %d: %v", userID, err)
+	}
+}
+
+// 通知を記録する。自分自身の操作による通知や、同じグループ内で同じユーザーからの
+// 重複した通知（いいねの取り消しと再いいねなど）は記録しない。
+// 受け取るユーザーの設定に応じて、記録しなかったりすぐにメールで送ったりする
+func Notify(userID uint, actorID uint, notificationType string, postID *uint) {
+	if userID == actorID {
+		return
+	}
+	// ブロック・ミュートしている相手からの通知は作らない
+	if silenced, err := relations.Silenced(userID, actorID); err != nil || silenced {
+		return
+	}
+
+	var recipient models.User
+	if err := config.DB.Select("id", "email", "notification_preferences", "language").First(&recipient, userID).Error; err != nil {
+		log.Printf("notifications: failed to load user %d: %v", userID, err)
+		return
+	}
+	delivery := Delivery(&recipient, notificationType)
+	if delivery == DeliveryNone {
+		return
+	}
+
+	now := time.Now()
+	n := models.Notification{
+		UserID:    userID,
+		GroupKey:  groupKey(notificationType, postID, now),
+		ActorID:   actorID,
+		Type:      notificationType,
+		PostID:    postID,
+		CreatedAt: now,
+	}
+	result := config.DB.Clauses(clause.OnConflict{DoNothing: true}).Omit("Actor", "Post").Create(&n)
+	if result.Error != nil {
+		log.Printf("notifications: failed to notify user %d: %v", userID, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	adjustUnread(context.Background(), userID, 1)
+	realtime.Publish(userID, realtime.TypeNotification, notificationEvent{
+		ID:        n.ID,
+		Type:      n.Type,
+		ActorID:   n.ActorID,
+		PostID:    n.PostID,
+		CreatedAt: n.CreatedAt,
+	})
+
+	if delivery == DeliveryEmail {
+		sendImmediate(&recipient, &n)
+	}
+}
+
+// リアルタイムで配信する通知のイベント
+type notificationEvent struct {
+	ID        uint      `json:"id"`
+	Type      string    `json:"type"`
+	ActorID   uint      `json:"actor_id"`
+	PostID    *uint     `json:"post_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// まとめて表示する通知のグループ。IDはグループ内で最新の通知のID
+type Group struct {
+	ID          uint
+	GroupKey    string
+	Type        string
+	PostID      *uint
+	ActorsCount int64
+	Unread      bool
+	CreatedAt   time.Time
+	Actors      []models.User `gorm:"-"`
+}
+
+// 通知をグループごとに新しい順に取得する。maxIDはグループのIDによるカーソル
+func List(userID uint, maxID uint, limit int) ([]Group, error) {
+	query := config.DB.Model(&models.Notification{}).
+		Select("MAX(id) AS id, group_key, MAX(type) AS type, MAX(post_id) AS post_id, "+
+			"COUNT(*) AS actors_count, SUM(read_at IS NULL) > 0 AS unread, MAX(created_at) AS created_at").
+		Where("user_id = ?", userID).
+		Group("group_key")
+	// 通知を受け取った後にブロック・ミュートした相手の通知も出さない
+	filter, err := relations.For(userID)
+	if err != nil {
+		return nil, err
+	}
+	hidden := filter.HiddenIDs()
+	query = query.Scopes(excludeActors(hidden))
+	if maxID > 0 {
+		query = query.Having("MAX(id) < ?", maxID)
+	}
+	var groups []Group
+	if err := query.Order("id DESC").Limit(limit).Scan(&groups).Error; err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return groups, nil
+	}
+
+	// 各グループの最新のユーザーを数人ずつ取得する
+	keys := make([]string, len(groups))
+	for i, g := range groups {
+		keys[i] = g.GroupKey
+	}
+	var latest []models.Notification
+	err = config.DB.Preload("Actor").
+		Table("(?) AS ranked",
+			config.DB.Model(&models.Notification{}).
+				Select("*, ROW_NUMBER() OVER (PARTITION BY group_key ORDER BY id DESC) AS rn").
+				Where("user_id = ? AND group_key IN ?", userID, keys).
+				Scopes(excludeActors(hidden))).
+		Where("rn <= ?", ActorsPerGroup).
+		Order("id DESC").
+		Find(&latest).Error
+	if err != nil {
+		return nil, err
+	}
+	actors := make(map[string][]models.User)
+	for _, n := range latest {
+		actors[n.GroupKey] = append(actors[n.GroupKey], n.Actor)
+	}
+	for i := range groups {
+		groups[i].Actors = actors[groups[i].GroupKey]
+	}
+	return groups, nil
+}
+
+func excludeActors(ids []uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(ids) == 0 {
+			return db
+		}
+		return db.Where("actor_id NOT IN ?", ids)
+	}
+}
+
+// 通知が属するグループをまとめて既読にする
+func MarkRead(userID uint, notificationID uint) error {
+	var n models.Notification
+	if err := config.DB.Where("id = ? AND user_id = ?", notificationID, userID).First(&n).Error; err != nil {
+		return err
+	}
+	result := config.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND group_key = ? AND read_at IS NULL", userID, n.GroupKey).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	adjustUnread(context.Background(), userID, -result.RowsAffected)
+	return nil
+}
+
+// すべての通知を既読にする
+func MarkAllRead(userID uint) error {
+	err := config.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	// 既読にしている間に届いた通知を取りこぼさないよう、次回の取得時に数え直す
+	return config.RDB.Del(context.Background(), unreadKey(userID)).Err()
+}
+
+// 未読の通知の数を返す。Redisにキャッシュがなければ MySQLで数えてキャッシュする
+func UnreadCount(userID uint) (int64, error) {
+	ctx := context.Background()
+	count, err := config.RDB.Get(ctx, unreadKey(userID)).Int64()
+	if err == nil {
+		return count, nil
+	}
+	if err != redis.Nil {
+		return 0, err
+	}
+
+	if err := config.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	// 数えている間に増えた分と競合しないよう、キーがない場合だけ設定する
+	config.RDB.SetNX(ctx, unreadKey(userID), count, 0)
+	return count, nil
+}
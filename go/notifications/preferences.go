@@ -0,0 +1,48 @@
+package notifications
+
+import "github.com/Shota0616/go-sns/models"
+
+// 通知の受け取り方
+const (
+	DeliveryInApp  = "in_app" // アプリ内のみ
+	DeliveryEmail  = "email"  // アプリ内に加えてすぐにメールで送る
+	DeliveryDigest = "digest" // アプリ内に加えて1日1回まとめてメールで送る
+	DeliveryNone   = "none"   // 受け取らない
+)
+
+// 設定できる通知の種類
+var Types = []string{TypeFollow, TypeLike, TypeReply, TypeMention, TypeRepost}
+
+func IsValidType(notificationType string) bool {
+	for _, t := range Types {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidDelivery(delivery string) bool {
+	switch delivery {
+	case DeliveryInApp, DeliveryEmail, DeliveryDigest, DeliveryNone:
+		return true
+	}
+	return false
+}
+
+// 通知の種類に対するユーザーの受け取り方を返す。未設定の場合はアプリ内のみ
+func Delivery(user *models.User, notificationType string) string {
+	if d, ok := user.NotificationPreferences[notificationType]; ok && IsValidDelivery(d) {
+		return d
+	}
+	return DeliveryInApp
+}
+
+// すべての種類について受け取り方を埋めた設定を返す
+func Preferences(user *models.User) map[string]string {
+	prefs := make(map[string]string, len(Types))
+	for _, t := range Types {
+		prefs[t] = Delivery(user, t)
+	}
+	return prefs
+}
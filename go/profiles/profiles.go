@@ -0,0 +1,168 @@
+package profiles
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/media"
+	"github.com/Shota0616/go-sns/models"
+	"golang.org/x/text/language"
+)
+
+// 各項目の最大文字数
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+	MaxLocationLength    = 30
+	MaxLinks             = 4
+	MaxLinkLength        = 200
+)
+
+// 誕生日を見せる相手
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityPrivate   = "private"
+)
+
+// 誕生日の形式（YYYY-MM-DD）
+const BirthdayLayout = "2006-01-02"
+
+var ErrInvalidMedia = errors.New("the media cannot be used in the profile")
+
+func IsValidVisibility(v string) bool {
+	switch v {
+	case VisibilityPublic, VisibilityFollowers, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// 表示名・自己紹介・場所を整える。最大文字数を超えていたらfalseを返す
+func Clean(s string, max int) (string, bool) {
+	s = strings.TrimSpace(s)
+	return s, utf8.RuneCountInString(s) <= max
+}
+
+// 自己紹介は改行を残す。連続する空行は1つにまとめる
+func CleanBio(s string) (string, bool) {
+	s = strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n"))
+	for strings.Contains(s, "\n\n\n") {
+		s = strings.ReplaceAll(s, "\n\n\n", "\n\n")
+	}
+	return s, utf8.RuneCountInString(s) <= MaxBioLength
+}
+
+// Webサイトのリンクを検証する。http/httpsでホストのあるURLだけを受け付ける
+func CleanLinks(links []string) ([]string, bool) {
+	if len(links) > MaxLinks {
+		return nil, false
+	}
+	result := make([]string, 0, len(links))
+	for _, link := range links {
+		link = strings.TrimSpace(link)
+		if link == "" {
+			continue
+		}
+		if len(link) > MaxLinkLength {
+			return nil, false
+		}
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+			return nil, false
+		}
+		result = append(result, u.String())
+	}
+	return result, true
+}
+
+// 誕生日を解析する。空文字は未設定として扱う。未来の日付と1900年より前の日付は受け付けない
+func ParseBirthday(s string) (*time.Time, bool) {
+	if s == "" {
+		return nil, true
+	}
+	t, err := time.Parse(BirthdayLayout, s)
+	if err != nil || t.After(time.Now()) || t.Year() < 1900 {
+		return nil, false
+	}
+	return &t, true
+}
+
+// 言語を解析し、言語コード（jaやenなど）にそろえる。空文字は未設定として扱う
+func ParseLanguage(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", true
+	}
+	tag, err := language.Parse(s)
+	if err != nil {
+		return "", false
+	}
+	base, confidence := tag.Base()
+	if confidence == language.No {
+		return "", false
+	}
+	return base.String(), true
+}
+
+// アイコンやヘッダーに使う画像を取得する。自分がアップロードした、処理済みの公開画像だけを使える
+func OwnedMedia(userID uint, mediaID uint) (*models.Media, error) {
+	var m models.Media
+	err := config.DB.Where("id = ? AND user_id = ? AND status = ? AND private = ?", mediaID, userID, media.StatusReady, false).
+		First(&m).Error
+	if err != nil {
+		return nil, ErrInvalidMedia
+	}
+	return &m, nil
+}
+
+// 閲覧者が誕生日を見られるかどうか
+func CanSeeBirthday(viewerID uint, user *models.User) (bool, error) {
+	if viewerID == user.ID {
+		return true, nil
+	}
+	switch user.BirthdayVisibility {
+	case VisibilityPublic:
+		return true, nil
+	case VisibilityFollowers:
+		if viewerID == 0 {
+			return false, nil
+		}
+		var count int64
+		err := config.DB.Model(&models.Follow{}).
+			Where("follower_id = ? AND followee_id = ?", viewerID, user.ID).
+			Count(&count).Error
+		return count > 0, err
+	default:
+		return false, nil
+	}
+}
+
+// プロフィールのアイコンとヘッダーの画像をまとめて取得する
+func Media(user *models.User) (avatar *models.Media, header *models.Media) {
+	ids := make([]uint, 0, 2)
+	for _, id := range []*uint{user.AvatarMediaID, user.HeaderMediaID} {
+		if id != nil {
+			ids = append(ids, *id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var list []models.Media
+	config.DB.Where("id IN ? AND status = ?", ids, media.StatusReady).Find(&list)
+	for i := range list {
+		m := &list[i]
+		if user.AvatarMediaID != nil && m.ID == *user.AvatarMediaID {
+			avatar = m
+		}
+		if user.HeaderMediaID != nil && m.ID == *user.HeaderMediaID {
+			header = m
+		}
+	}
+	return avatar, header
+}
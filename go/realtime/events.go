@@ -0,0 +1,167 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/go-redis/redis/v8"
+)
+
+// イベントの種類
+const (
+	TypeNotification = "notification"
+	TypeMessage      = "message"
+	TypeTimeline     = "timeline"
+	TypeExport       = "export"
+)
+
+// 再接続したクライアントに送り直せるよう、イベントを残しておく期間
+var StreamRetention = 24 * time.Hour
+
+// 1ユーザーのストリームに残すイベントのおおよその上限。
+// フォロワーの多いユーザーの投稿などで、接続していないユーザーのストリームが大きくなりすぎないようにする
+var StreamMaxLen int64 = 1000
+
+// クライアントに送るイベント。Pub/Subで流す時はユーザーのストリームでのIDが先頭に付く
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// ユーザーごとのPub/Subのチャンネル。どのAPIインスタンスで接続していても届くようにRedisを経由する
+func channel(userID uint) string {
+	return fmt.Sprintf("events:user:%d", userID)
+}
+
+// ユーザーごとのイベントのストリーム。SSEで再接続したクライアントに見逃したイベントを送るのに使う
+func streamKey(userID uint) string {
+	return fmt.Sprintf("events:stream:%d", userID)
+}
+
+// イベントをストリームに追加し、ストリームでのIDを付けてPub/Subに流す。
+// 保存期間を過ぎたイベントと、上限を超えた古いイベントは追加時に削除する
+//
+// KEYS[1]: ストリーム
+// ARGV[1]: イベント, ARGV[2]: 残す最小のID, ARGV[3]: ストリームの有効期限（ミリ秒）, ARGV[4]: チャンネル,
+// ARGV[5]: ストリームに残す最大件数
+var publishScript = redis.NewScript(`
+local id = redis.call("XADD", KEYS[1], "MINID", "~", ARGV[2], "*", "event", ARGV[1])
+redis.call("XTRIM", KEYS[1], "MAXLEN", "~", ARGV[5])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+redis.call("PUBLISH", ARGV[4], '{"id":"' .. id .. '",' .. string.sub(ARGV[1], 2))
+return id
+`)
+
+// IDのないイベントにストリームでのIDを付ける（publishScriptと同じ形にする）
+func withID(id string, payload string) []byte {
+	return []byte(`{"id":"` + id + `",` + payload[1:])
+}
+
+func encode(eventType string, data interface{}) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Event{Type: eventType, Data: raw})
+}
+
+// ユーザーにイベントを送る。接続していないユーザーへのイベントもストリームに残し、
+// 保存期間内に再接続すれば受け取れる
+func Publish(userID uint, eventType string, data interface{}) {
+	PublishMany([]uint{userID}, eventType, data)
+}
+
+// 複数のユーザーに同じイベントを送る
+func PublishMany(userIDs []uint, eventType string, data interface{}) {
+	if len(userIDs) == 0 {
+		return
+	}
+	payload, err := encode(eventType, data)
+	if err != nil {
+		log.Printf("realtime: failed to encode %s event: %v", eventType, err)
+		return
+	}
+
+	ctx := context.Background()
+	// EVALSHAがパイプライン内で失敗しないよう、先にスクリプトを読み込んでおく
+	if err := publishScript.Load(ctx, config.RDB).Err(); err != nil {
+		log.Printf("realtime: failed to load publish script: %v", err)
+		return
+	}
+	minID := fmt.Sprintf("%d-0", time.Now().Add(-StreamRetention).UnixMilli())
+	ttl := StreamRetention.Milliseconds()
+	pipe := config.RDB.Pipeline()
+	for _, id := range userIDs {
+		publishScript.EvalSha(ctx, pipe, []string{streamKey(id)}, payload, minID, ttl, channel(id), StreamMaxLen)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("realtime: failed to publish %s event: %v", eventType, err)
+	}
+}
+
+// lastIDより後のイベントをストリームから古い順に取得する
+func missed(ctx context.Context, userID uint, lastID string, count int64) ([]StreamEvent, error) {
+	msgs, err := config.RDB.XRangeN(ctx, streamKey(userID), "("+lastID, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]StreamEvent, 0, len(msgs))
+	for _, m := range msgs {
+		payload, ok := m.Values["event"].(string)
+		if !ok || payload == "" {
+			continue
+		}
+		events = append(events, StreamEvent{ID: m.ID, Payload: withID(m.ID, payload)})
+	}
+	return events, nil
+}
+
+// ストリームから読み込んだイベント
+type StreamEvent struct {
+	ID      string
+	Payload []byte
+}
+
+// ストリームのIDとして正しい形式かどうか
+func IsValidID(id string) bool {
+	_, _, ok := parseID(id)
+	return ok
+}
+
+func parseID(id string) (uint64, uint64, bool) {
+	ms, seq, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	a, err := strconv.ParseUint(ms, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	b, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return a, b, true
+}
+
+// ストリームのIDでaがbより後かどうか
+func after(a string, b string) bool {
+	am, as, _ := parseID(a)
+	bm, bs, _ := parseID(b)
+	return am > bm || (am == bm && as > bs)
+}
+
+// Pub/Subで受け取ったイベントのIDを取り出す
+func eventID(payload []byte) string {
+	var e struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(payload, &e)
+	return e.ID
+}
@@ -0,0 +1,168 @@
+package realtime
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// 接続が生きているか確認するためのPingの間隔
+	PingInterval = 30 * time.Second
+	// この時間Pongが返ってこなければ切断する
+	PongWait = 60 * time.Second
+	// 1回の書き込みの待ち時間
+	WriteWait = 10 * time.Second
+	// クライアントごとに溜めておける未送信のイベント数。溢れた遅いクライアントは切断する
+	SendBuffer = 256
+)
+
+// WebSocketで接続しているクライアント
+type client struct {
+	userID uint
+	conn   *websocket.Conn
+	send   chan []byte
+}
+
+// このインスタンスで接続しているクライアントを管理し、
+// 接続中のユーザーのチャンネルだけをRedisで購読する
+type hub struct {
+	mu      sync.Mutex
+	clients map[uint]map[*client]struct{}
+	pubsub  *redis.PubSub
+}
+
+var defaultHub *hub
+
+// Redisの購読を開始する
+func Start() {
+	ctx := context.Background()
+	h := &hub{
+		clients: make(map[uint]map[*client]struct{}),
+		pubsub:  config.RDB.Subscribe(ctx),
+	}
+	defaultHub = h
+	go h.run()
+}
+
+func (h *hub) run() {
+	for msg := range h.pubsub.Channel() {
+		id, err := strconv.ParseUint(strings.TrimPrefix(msg.Channel, "events:user:"), 10, 64)
+		if err != nil {
+			continue
+		}
+		h.dispatch(uint(id), []byte(msg.Payload))
+	}
+}
+
+// イベントをユーザーのクライアントに渡す。送信が追いつかないクライアントは待たずに切断する
+func (h *hub) dispatch(userID uint, payload []byte) {
+	h.mu.Lock()
+	var slow []*client
+	for c := range h.clients[userID] {
+		select {
+		case c.send <- payload:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range slow {
+		log.Printf("realtime: disconnecting slow client of user %d", c.userID)
+		h.unregister(c)
+	}
+}
+
+func (h *hub) register(c *client) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients[c.userID]) == 0 {
+		if err := h.pubsub.Subscribe(context.Background(), channel(c.userID)); err != nil {
+			return err
+		}
+		h.clients[c.userID] = make(map[*client]struct{})
+	}
+	h.clients[c.userID][c] = struct{}{}
+	return nil
+}
+
+// クライアントを外して送信を止める。同じクライアントを何度外してもよい
+func (h *hub) unregister(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients := h.clients[c.userID]
+	if _, ok := clients[c]; !ok {
+		return
+	}
+	delete(clients, c)
+	close(c.send)
+	if len(clients) == 0 {
+		delete(h.clients, c.userID)
+		if err := h.pubsub.Unsubscribe(context.Background(), channel(c.userID)); err != nil {
+			log.Printf("realtime: failed to unsubscribe user %d: %v", c.userID, err)
+		}
+	}
+}
+
+// WebSocketの接続でユーザーのイベントを配信する。接続が閉じるまで戻らない
+func Serve(conn *websocket.Conn, userID uint) {
+	c := &client{userID: userID, conn: conn, send: make(chan []byte, SendBuffer)}
+	if err := defaultHub.register(c); err != nil {
+		log.Printf("realtime: failed to subscribe user %d: %v", userID, err)
+		conn.Close()
+		return
+	}
+	go c.writePump()
+	c.readPump()
+	defaultHub.unregister(c)
+}
+
+// クライアントからのメッセージは使わないが、Pongと切断を検知するために読み続ける
+func (c *client) readPump() {
+	c.conn.SetReadLimit(512)
+	c.conn.SetReadDeadline(time.Now().Add(PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(PongWait))
+	})
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *client) writePump() {
+	ticker := time.NewTicker(PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case payload, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+			if !ok {
+				// 送信が追いつかずに外された場合も含め、後から再接続してもらう
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ""))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package realtime
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// 接続を保つためのコメントを送る間隔（プロキシのタイムアウトより短くする）
+	SSEHeartbeat = 15 * time.Second
+	// 切断されたクライアントが再接続するまでの待ち時間
+	SSERetry = 3 * time.Second
+	// 見逃したイベントをストリームから一度に読み込む件数
+	replayBatch int64 = 500
+)
+
+// Server-Sent Eventsでユーザーのイベントを配信する。lastIDが空でなければ、
+// ストリームに残っているそれより後のイベントを先に送る。ctxが終わるか接続が切れるまで戻らない
+func ServeSSE(ctx context.Context, w http.ResponseWriter, userID uint, lastID string) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("realtime: streaming is not supported")
+	}
+
+	// 見逃したイベントを読んでいる間に届いたイベントを取りこぼさないよう、先に購読しておく
+	c := &client{userID: userID, send: make(chan []byte, SendBuffer)}
+	if err := defaultHub.register(c); err != nil {
+		return err
+	}
+	defer defaultHub.unregister(c)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// nginxでバッファリングされないようにする
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", SSERetry.Milliseconds()); err != nil {
+		return err
+	}
+
+	if lastID != "" {
+		for {
+			events, err := missed(ctx, userID, lastID, replayBatch)
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				if err := writeSSE(w, e.ID, e.Payload); err != nil {
+					return err
+				}
+				lastID = e.ID
+			}
+			if int64(len(events)) < replayBatch {
+				break
+			}
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(SSEHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case payload, ok := <-c.send:
+			if !ok {
+				// 送信が追いつかずに外された。クライアントはLast-Event-IDで再接続すれば続きから受け取れる
+				return nil
+			}
+			id := eventID(payload)
+			// 見逃したイベントとして送ったものは送らない
+			if id != "" && lastID != "" && !after(id, lastID) {
+				continue
+			}
+			if err := writeSSE(w, id, payload); err != nil {
+				return err
+			}
+			if id != "" {
+				lastID = id
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, id string, payload []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", payload)
+	return err
+}
@@ -0,0 +1,45 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Shota0616/go-sns/auth"
+	"github.com/Shota0616/go-sns/config"
+)
+
+// ブラウザのWebSocketやEventSourceはヘッダーを付けられないため、接続時はURLに使い捨てのチケットを載せる。
+// URLはアクセスログに残るため、ログインに使うトークンは載せない
+const TicketPurpose = "stream"
+
+// チケットの有効期限。発行してすぐに接続する前提で短くする
+var TicketTTL = 30 * time.Second
+
+var ErrInvalidTicket = errors.New("invalid or used stream ticket")
+
+func ticketKey(jti string) string {
+	return "events:ticket:" + jti
+}
+
+// ユーザーのイベントに接続するためのチケットを発行する
+func IssueTicket(userID uint) (string, error) {
+	return auth.GeneratePurposeToken(TicketPurpose, userID, TicketTTL)
+}
+
+// チケットを検証してユーザーIDを返す。同じチケットは一度しか使えない
+func RedeemTicket(ctx context.Context, ticket string) (uint, error) {
+	claims, err := auth.ValidatePurposeToken(TicketPurpose, ticket)
+	if err != nil || claims.Id == "" {
+		return 0, ErrInvalidTicket
+	}
+	// 使ったチケットは有効期限が切れるまで覚えておく
+	first, err := config.RDB.SetNX(ctx, ticketKey(claims.Id), 1, TicketTTL).Result()
+	if err != nil {
+		return 0, err
+	}
+	if !first {
+		return 0, ErrInvalidTicket
+	}
+	return claims.ID, nil
+}
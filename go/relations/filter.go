@@ -0,0 +1,107 @@
+package relations
+
+import (
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+)
+
+// 閲覧者から見えないユーザーの集合。一覧を返す処理はすべてこれを通して絞り込む
+type Filter struct {
+	// どちらかがブロックしている相手（お互いに見えない）
+	blocked map[uint]bool
+	// 閲覧者がミュートしている相手（閲覧者のタイムラインと通知にだけ出さない）
+	muted map[uint]bool
+}
+
+// 閲覧者のブロックとミュートを読み込む。ログインしていない場合は何も絞り込まない。
+// 読み込めなかった場合に絞り込まずに表示しないよう、エラーは呼び出し元でリクエストの失敗として扱う
+func For(viewerID uint) (*Filter, error) {
+	f := &Filter{blocked: make(map[uint]bool), muted: make(map[uint]bool)}
+	if viewerID == 0 {
+		return f, nil
+	}
+
+	var blocks []models.Block
+	if err := config.DB.Where("blocker_id = ? OR blocked_id = ?", viewerID, viewerID).Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	for _, b := range blocks {
+		if b.BlockerID == viewerID {
+			f.blocked[b.BlockedID] = true
+		} else {
+			f.blocked[b.BlockerID] = true
+		}
+	}
+	var muted []uint
+	if err := activeMutes(config.DB.Model(&models.Mute{})).Where("muter_id = ?", viewerID).Pluck("muted_id", &muted).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range muted {
+		f.muted[id] = true
+	}
+	return f, nil
+}
+
+// どちらかがブロックしているかどうか
+func (f *Filter) Blocks(userID uint) bool {
+	return f.blocked[userID]
+}
+
+// 一覧に出さないユーザーかどうか
+func (f *Filter) Hides(userID uint) bool {
+	return f.blocked[userID] || f.muted[userID]
+}
+
+// どちらかがブロックしているユーザーのID（SQLで除外するために使う）
+func (f *Filter) BlockedIDs() []uint {
+	ids := make([]uint, 0, len(f.blocked))
+	for id := range f.blocked {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// 一覧に出さないユーザーのID（SQLで除外するために使う）
+func (f *Filter) HiddenIDs() []uint {
+	ids := make([]uint, 0, len(f.blocked)+len(f.muted))
+	for id := range f.blocked {
+		ids = append(ids, id)
+	}
+	for id := range f.muted {
+		if !f.blocked[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// 投稿者、リポスト元の投稿者が見えない投稿かどうか
+func (f *Filter) HidesPost(p *models.Post) bool {
+	if f.Hides(p.UserID) {
+		return true
+	}
+	return p.RepostOf != nil && f.Hides(p.RepostOf.UserID)
+}
+
+// 見えない投稿を取り除く。引用元の投稿者が見えない場合は引用元を外す
+func (f *Filter) Posts(posts []models.Post) []models.Post {
+	if len(f.blocked) == 0 && len(f.muted) == 0 {
+		return posts
+	}
+	result := make([]models.Post, 0, len(posts))
+	for _, p := range posts {
+		if f.HidesPost(&p) {
+			continue
+		}
+		if p.QuoteOf != nil && f.Hides(p.QuoteOf.UserID) {
+			p.QuoteOf = nil
+		}
+		if p.RepostOf != nil && p.RepostOf.QuoteOf != nil && f.Hides(p.RepostOf.QuoteOf.UserID) {
+			original := *p.RepostOf
+			original.QuoteOf = nil
+			p.RepostOf = &original
+		}
+		result = append(result, p)
+	}
+	return result
+}
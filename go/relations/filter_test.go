@@ -0,0 +1,183 @@
+package relations
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/testutil"
+)
+
+const (
+	blockedUser = uint(2)
+	mutedUser   = uint(3)
+	otherUser   = uint(4)
+)
+
+func newFilter() *Filter {
+	return &Filter{
+		blocked: map[uint]bool{blockedUser: true},
+		muted:   map[uint]bool{mutedUser: true},
+	}
+}
+
+func TestFilterHides(t *testing.T) {
+	f := newFilter()
+	tests := []struct {
+		userID     uint
+		wantHides  bool
+		wantBlocks bool
+	}{
+		{blockedUser, true, true},
+		{mutedUser, true, false},
+		{otherUser, false, false},
+	}
+	for _, tt := range tests {
+		if got := f.Hides(tt.userID); got != tt.wantHides {
+			t.Errorf("Hides(%d) = %v, want %v", tt.userID, got, tt.wantHides)
+		}
+		if got := f.Blocks(tt.userID); got != tt.wantBlocks {
+			t.Errorf("Blocks(%d) = %v, want %v", tt.userID, got, tt.wantBlocks)
+		}
+	}
+}
+
+func TestFilterHidesPost(t *testing.T) {
+	f := newFilter()
+	tests := []struct {
+		name string
+		post models.Post
+		want bool
+	}{
+		{"ブロックしている相手の投稿", models.Post{UserID: blockedUser}, true},
+		{"ミュートしている相手の投稿", models.Post{UserID: mutedUser}, true},
+		{"他の人の投稿", models.Post{UserID: otherUser}, false},
+		{"ブロックしている相手の投稿のリポスト", models.Post{UserID: otherUser, RepostOf: &models.Post{UserID: blockedUser}}, true},
+		{"ミュートしている相手の投稿のリポスト", models.Post{UserID: otherUser, RepostOf: &models.Post{UserID: mutedUser}}, true},
+		// 引用元が見えなくても引用した投稿は表示し、引用元だけを外す（Postsで確認する）
+		{"ブロックしている相手の投稿の引用", models.Post{UserID: otherUser, QuoteOf: &models.Post{UserID: blockedUser}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.HidesPost(&tt.post); got != tt.want {
+				t.Errorf("HidesPost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterPosts(t *testing.T) {
+	f := newFilter()
+	blockedPost := &models.Post{UserID: blockedUser}
+	mutedPost := &models.Post{UserID: mutedUser}
+	otherPost := &models.Post{UserID: otherUser}
+	posts := []models.Post{
+		{Body: "blocked", UserID: blockedUser},
+		{Body: "muted", UserID: mutedUser},
+		{Body: "other", UserID: otherUser},
+		{Body: "repost of blocked", UserID: otherUser, RepostOf: blockedPost},
+		{Body: "quote of blocked", UserID: otherUser, QuoteOf: blockedPost},
+		{Body: "quote of muted", UserID: otherUser, QuoteOf: mutedPost},
+		{Body: "quote of other", UserID: otherUser, QuoteOf: otherPost},
+		{Body: "repost of quote of blocked", UserID: otherUser, RepostOf: &models.Post{UserID: otherUser, QuoteOf: blockedPost}},
+	}
+	got := f.Posts(posts)
+
+	type summary struct {
+		Body           string
+		HasQuote       bool
+		HasRepostQuote bool
+	}
+	var summaries []summary
+	for _, p := range got {
+		s := summary{Body: p.Body, HasQuote: p.QuoteOf != nil}
+		if p.RepostOf != nil {
+			s.HasRepostQuote = p.RepostOf.QuoteOf != nil
+		}
+		summaries = append(summaries, s)
+	}
+	want := []summary{
+		{Body: "other"},
+		{Body: "quote of blocked"},
+		{Body: "quote of muted"},
+		{Body: "quote of other", HasQuote: true},
+		{Body: "repost of quote of blocked"},
+	}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Errorf("Posts() = %+v, want %+v", summaries, want)
+	}
+	// 元の一覧のリポスト元は書き換えない（キャッシュなどで共有していることがある）
+	if posts[7].RepostOf.QuoteOf == nil {
+		t.Error("Posts() modified the original reposted post")
+	}
+}
+
+func TestFilterPostsEmpty(t *testing.T) {
+	f := &Filter{blocked: map[uint]bool{}, muted: map[uint]bool{}}
+	posts := []models.Post{{UserID: blockedUser}, {UserID: otherUser}}
+	if got := f.Posts(posts); len(got) != 2 {
+		t.Errorf("Posts() returned %d posts, want 2", len(got))
+	}
+}
+
+func TestFor(t *testing.T) {
+	testutil.Setup(t)
+	viewer := testutil.CreateUser(t, "viewer")
+	blocking := testutil.CreateUser(t, "blocking")
+	blocker := testutil.CreateUser(t, "blocker")
+	muted := testutil.CreateUser(t, "muted")
+	timed := testutil.CreateUser(t, "timed")
+	expired := testutil.CreateUser(t, "expired")
+	other := testutil.CreateUser(t, "other")
+
+	testutil.Block(t, viewer.ID, blocking.ID)
+	testutil.Block(t, blocker.ID, viewer.ID)
+	testutil.Mute(t, viewer.ID, muted.ID, 0)
+	testutil.Mute(t, viewer.ID, timed.ID, time.Hour)
+	testutil.Mute(t, viewer.ID, expired.ID, -time.Hour)
+	// 他のユーザーのミュートは閲覧者に関係ない
+	testutil.Mute(t, other.ID, viewer.ID, 0)
+
+	f, err := For(viewer.ID)
+	if err != nil {
+		t.Fatalf("For() error = %v", err)
+	}
+	tests := []struct {
+		name       string
+		userID     uint
+		wantBlocks bool
+		wantHides  bool
+	}{
+		{"ブロックしている相手", blocking.ID, true, true},
+		{"ブロックされている相手", blocker.ID, true, true},
+		{"期限なしのミュート", muted.ID, false, true},
+		{"期限内のミュート", timed.ID, false, true},
+		{"期限切れのミュート", expired.ID, false, false},
+		{"関係のない相手", other.ID, false, false},
+	}
+	for _, tt := range tests {
+		if got := f.Blocks(tt.userID); got != tt.wantBlocks {
+			t.Errorf("%s: Blocks() = %v, want %v", tt.name, got, tt.wantBlocks)
+		}
+		if got := f.Hides(tt.userID); got != tt.wantHides {
+			t.Errorf("%s: Hides() = %v, want %v", tt.name, got, tt.wantHides)
+		}
+	}
+
+	blocked := f.BlockedIDs()
+	sort.Slice(blocked, func(i, j int) bool { return blocked[i] < blocked[j] })
+	if want := []uint{blocking.ID, blocker.ID}; !reflect.DeepEqual(blocked, want) {
+		t.Errorf("BlockedIDs() = %v, want %v", blocked, want)
+	}
+
+	// ログインしていない場合は何も絞り込まない
+	anonymous, err := For(0)
+	if err != nil {
+		t.Fatalf("For(0) error = %v", err)
+	}
+	if anonymous.Hides(blocking.ID) || anonymous.Hides(muted.ID) {
+		t.Error("For(0) hides users")
+	}
+}
@@ -0,0 +1,158 @@
+package relations
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/timeline"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 期限付きでミュートできる最長の期間
+var MaxMuteDuration = 365 * 24 * time.Hour
+
+var ErrSelf = errors.New("cannot block or mute yourself")
+
+// ユーザーをブロックする。お互いのフォローも解除する
+func Block(blockerID uint, blockedID uint) error {
+	if blockerID == blockedID {
+		return ErrSelf
+	}
+	var removed [][2]uint
+	var demoted []uint
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.Block{BlockerID: blockerID, BlockedID: blockedID}).Error
+		if err != nil {
+			return err
+		}
+		for _, pair := range [][2]uint{{blockerID, blockedID}, {blockedID, blockerID}} {
+			ok, err := unfollow(tx, pair[0], pair[1])
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			removed = append(removed, pair)
+			dropped, err := timeline.DroppedBelowThreshold(tx, pair[1])
+			if err != nil {
+				return err
+			}
+			if dropped {
+				demoted = append(demoted, pair[1])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, pair := range removed {
+		go timeline.OnUnfollow(pair[0], pair[1])
+	}
+	for _, id := range demoted {
+		go timeline.OnCelebrityEnded(id)
+	}
+	return nil
+}
+
+// フォローを解除してフォロー数・フォロワー数を減らす。フォローしていなかった場合はfalseを返す
+func unfollow(tx *gorm.DB, followerID uint, followeeID uint) (bool, error) {
+	result := tx.Where("follower_id = ? AND followee_id = ?", followerID, followeeID).Delete(&models.Follow{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	if err := tx.Model(&models.User{}).Where("id = ?", followeeID).UpdateColumn("followers_count", gorm.Expr("followers_count - 1")).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Model(&models.User{}).Where("id = ?", followerID).UpdateColumn("following_count", gorm.Expr("following_count - 1")).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ブロックを解除する。解除したフォローは元に戻さない
+func Unblock(blockerID uint, blockedID uint) error {
+	return config.DB.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).Delete(&models.Block{}).Error
+}
+
+// ユーザーをミュートする。durationが0の場合は期限なし。既にミュートしていれば期限を更新する
+func Mute(muterID uint, mutedID uint, duration time.Duration) error {
+	if muterID == mutedID {
+		return ErrSelf
+	}
+	m := models.Mute{MuterID: muterID, MutedID: mutedID}
+	if duration > 0 {
+		expiresAt := time.Now().Add(duration)
+		m.ExpiresAt = &expiresAt
+	}
+	return config.DB.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"expires_at"}),
+	}).Create(&m).Error
+}
+
+func Unmute(muterID uint, mutedID uint) error {
+	return config.DB.Where("muter_id = ? AND muted_id = ?", muterID, mutedID).Delete(&models.Mute{}).Error
+}
+
+// 期限の切れていないミュート
+func activeMutes(db *gorm.DB) *gorm.DB {
+	return db.Where("(expires_at IS NULL OR expires_at > ?)", time.Now())
+}
+
+// どちらかがもう一方をブロックしているかどうか
+func IsBlocked(a uint, b uint) (bool, error) {
+	if a == 0 || b == 0 || a == b {
+		return false, nil
+	}
+	var count int64
+	err := config.DB.Model(&models.Block{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", a, b, b, a).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// userIDのユーザーがtargetIDのユーザーをミュートしているかどうか
+func IsMuted(userID uint, targetID uint) (bool, error) {
+	var count int64
+	err := activeMutes(config.DB.Model(&models.Mute{})).
+		Where("muter_id = ? AND muted_id = ?", userID, targetID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// userIDのユーザーへの通知やメンションを、actorIDのユーザーから受け取らないかどうか
+// （どちらかがブロックしているか、userIDのユーザーがミュートしている）
+func Silenced(userID uint, actorID uint) (bool, error) {
+	blocked, err := IsBlocked(userID, actorID)
+	if err != nil || blocked {
+		return blocked, err
+	}
+	return IsMuted(userID, actorID)
+}
+
+// ブロックしているユーザーの一覧
+func Blocks(userID uint, maxID uint, limit int) ([]models.Block, error) {
+	query := config.DB.Preload("Blocked").Where("blocker_id = ?", userID)
+	if maxID > 0 {
+		query = query.Where("id < ?", maxID)
+	}
+	var list []models.Block
+	err := query.Order("id DESC").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// ミュートしているユーザーの一覧。期限の切れたミュートは含まない
+func Mutes(userID uint, maxID uint, limit int) ([]models.Mute, error) {
+	query := activeMutes(config.DB.Preload("Muted").Where("muter_id = ?", userID))
+	if maxID > 0 {
+		query = query.Where("id < ?", maxID)
+	}
+	var list []models.Mute
+	err := query.Order("id DESC").Limit(limit).Find(&list).Error
+	return list, err
+}
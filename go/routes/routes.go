@@ -15,7 +15,7 @@ func SetupRouter() *gin.Engine {
 	// CORS設定
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{os.Getenv("APP_URL"),"http://localhost:5173"}, // .envファイルに設定したAPP_URLを使用
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -31,16 +31,105 @@ func SetupRouter() *gin.Engine {
 		public.POST("/request-password-reset", controllers.RequestPasswordReset) // パスワード再設定リクエストのエンドポイントを追加
 		public.POST("/resend-verification-code", controllers.ResendVerificationCode) // メール認証コード再送のエンドポイントを追加
 		public.POST("/reset-password", controllers.ResetPassword) // パスワード再設定のエンドポイントを追加
+		public.POST("/account/email/revert", controllers.RevertEmailChange) // メールアドレスの変更を取り消す（元のアドレスに送ったリンクから）
+		public.GET("/notifications/unsubscribe", controllers.ConfirmUnsubscribeNotifications) // 通知メールの配信停止の確認（メールのリンクから。ここでは停止しない）
+		public.POST("/notifications/unsubscribe", controllers.UnsubscribeNotifications) // 通知メールの配信停止（確認後またはワンクリック）
+		public.GET("/events/ws", controllers.EventsWebSocket) // 通知などのリアルタイム配信（トークンかチケットを自分で検証する）
+		public.GET("/events/stream", controllers.EventsStream) // 通知などのリアルタイム配信（SSE）
+		public.GET("/media/files/*key", controllers.ServeMediaFile) // ローカルに保存した画像（非公開の画像は署名付きURLのみ）
 		// サーバ側でトークンを管理するときは以下を追加
 		// public.POST("/logout", controllers.Logout)
 	}
 
+	// ログインしていなくても見られるルート（ログインしていれば閲覧者に合わせて返す）
+	optional := router.Group("/api")
+	optional.Use(middleware.OptionalAuth())
+	{
+		optional.GET("/users/:username", controllers.GetProfile) // プロフィール
+		optional.GET("/users/:username/posts", controllers.ListUserPosts) // ユーザーの投稿一覧（公開範囲に応じて絞り込む）
+		optional.GET("/users/:username/lists", controllers.ListUserLists) // ユーザーの公開のリスト一覧
+	}
+
 	// 認証が必要なルート
 	protected := router.Group("/api")
 	protected.Use(middleware.AuthRequired())
 	{
 		// protected.GET("/mypage", controllers.GetMyPage) // マイページ
 		protected.GET("/getuser", controllers.GetUser) // ユーザー情報取得
+		protected.PATCH("/profile", controllers.UpdateProfile) // プロフィールを更新
+		protected.POST("/account/email", controllers.RequestEmailChange) // メールアドレスの変更を依頼
+		protected.POST("/account/email/confirm", controllers.ConfirmEmailChange) // 確認コードを入力してメールアドレスを変更
+		protected.DELETE("/account", controllers.DeleteUser) // アカウントの削除を依頼（猶予期間中にログインすると取り消す）
+		protected.POST("/account/exports", controllers.RequestDataExport) // 個人データのエクスポートを依頼
+		protected.GET("/account/exports", controllers.ListDataExports) // 依頼したエクスポートの一覧
+		protected.POST("/media", controllers.UploadMedia) // 画像をアップロード
+		protected.GET("/media/:id", controllers.GetMedia) // 画像の処理状況
+		protected.POST("/posts", controllers.CreatePost) // 投稿
+		protected.GET("/posts/:id", controllers.GetPost) // 投稿の取得
+		protected.DELETE("/posts/:id", controllers.DeletePost) // 投稿の削除
+		protected.PUT("/posts/:id/like", controllers.LikePost) // いいね
+		protected.DELETE("/posts/:id/like", controllers.UnlikePost) // いいね取り消し
+		protected.GET("/posts/:id/likes", controllers.ListLikes) // いいねしたユーザー一覧
+		protected.GET("/posts/:id/replies", controllers.ListReplies) // 返信一覧
+		protected.POST("/posts/:id/repost", controllers.Repost) // リポスト
+		protected.DELETE("/posts/:id/repost", controllers.Unrepost) // リポスト取り消し
+		protected.PUT("/posts/:id/bookmark", controllers.BookmarkPost) // ブックマーク（collection_idで保存先を指定）
+		protected.PATCH("/posts/:id/bookmark", controllers.MoveBookmark) // ブックマークを別のコレクションに移す
+		protected.DELETE("/posts/:id/bookmark", controllers.UnbookmarkPost) // ブックマーク解除
+		protected.GET("/bookmarks", controllers.ListBookmarks) // ブックマーク一覧
+		protected.GET("/bookmarks/collections", controllers.ListBookmarkCollections) // ブックマークのコレクション一覧
+		protected.POST("/bookmarks/collections", controllers.CreateBookmarkCollection) // コレクション作成
+		protected.PATCH("/bookmarks/collections/:id", controllers.RenameBookmarkCollection) // コレクション名の変更
+		protected.DELETE("/bookmarks/collections/:id", controllers.DeleteBookmarkCollection) // コレクション削除
+		protected.GET("/timeline/home", controllers.HomeTimeline) // ホームタイムライン
+		protected.GET("/lists", controllers.ListMyLists) // 自分のリスト一覧
+		protected.POST("/lists", controllers.CreateList) // リスト作成
+		protected.GET("/lists/:id", controllers.GetList) // リストの取得（他人の非公開のリストは見られない）
+		protected.PATCH("/lists/:id", controllers.UpdateList) // リストの更新
+		protected.DELETE("/lists/:id", controllers.DeleteList) // リスト削除
+		protected.GET("/lists/:id/members", controllers.ListListMembers) // リストのメンバー一覧
+		protected.PUT("/lists/:id/members/:user_id", controllers.AddListMember) // リストにメンバーを追加
+		protected.DELETE("/lists/:id/members/:user_id", controllers.RemoveListMember) // リストからメンバーを外す
+		protected.GET("/lists/:id/timeline", controllers.ListTimeline) // リストタイムライン
+		protected.GET("/tags/:tag", controllers.TagTimeline) // ハッシュタグの投稿一覧
+		protected.GET("/trends/tags", controllers.TrendingTags) // トレンドのハッシュタグ
+		protected.GET("/search/posts", controllers.SearchPosts) // 投稿の検索
+		protected.GET("/search/users", controllers.SearchUsers) // ユーザーの検索
+		protected.GET("/search/users/autocomplete", controllers.AutocompleteUsers) // ユーザー名の入力補完
+		protected.GET("/suggestions/users", controllers.ListSuggestions) // おすすめのユーザー
+		protected.DELETE("/suggestions/users/:id", controllers.DismissSuggestion) // おすすめから外す
+		protected.POST("/users/:username/follow", controllers.Follow) // フォロー
+		protected.DELETE("/users/:username/follow", controllers.Unfollow) // フォロー解除
+		protected.POST("/users/:username/block", controllers.BlockUser) // ブロック
+		protected.DELETE("/users/:username/block", controllers.UnblockUser) // ブロック解除
+		protected.POST("/users/:username/mute", controllers.MuteUser) // ミュート（期限付きも可）
+		protected.DELETE("/users/:username/mute", controllers.UnmuteUser) // ミュート解除
+		protected.GET("/blocks", controllers.ListBlocks) // ブロックしているユーザー一覧
+		protected.GET("/mutes", controllers.ListMutes) // ミュートしているユーザー一覧
+		protected.GET("/notifications", controllers.ListNotifications) // 通知一覧
+		protected.GET("/notifications/unread-count", controllers.UnreadNotificationsCount) // 未読の通知数
+		protected.POST("/notifications/:id/read", controllers.MarkNotificationRead) // 通知を既読にする
+		protected.POST("/notifications/read-all", controllers.MarkAllNotificationsRead) // すべての通知を既読にする
+		protected.POST("/events/ticket", controllers.CreateStreamTicket) // リアルタイム配信に接続するための使い捨てのチケット
+		protected.GET("/settings/notifications", controllers.GetNotificationPreferences) // 通知の受け取り方の設定
+		protected.PUT("/settings/notifications", controllers.UpdateNotificationPreferences) // 通知の受け取り方の設定を更新
+		protected.PUT("/settings/direct-messages", controllers.UpdateDirectMessagePolicy) // ダイレクトメッセージを受け付ける相手の設定を更新
+		protected.GET("/settings/filters", controllers.ListFilters) // キーワードフィルター一覧
+		protected.POST("/settings/filters", controllers.CreateFilter) // キーワードフィルターを作成
+		protected.PATCH("/settings/filters/:id", controllers.UpdateFilter) // キーワードフィルターを更新
+		protected.DELETE("/settings/filters/:id", controllers.DeleteFilter) // キーワードフィルターを削除
+		protected.GET("/conversations", controllers.ListConversations) // 会話一覧
+		protected.POST("/conversations", controllers.StartConversation) // 1対1の会話を開始
+		protected.GET("/conversations/unread-count", controllers.UnreadMessagesCount) // 未読のメッセージ数
+		protected.POST("/conversations/groups", controllers.CreateGroupConversation) // グループを作成
+		protected.POST("/conversations/:id/members", controllers.AddConversationMembers) // グループにメンバーを追加
+		protected.DELETE("/conversations/:id/members/:user_id", controllers.RemoveConversationMember) // グループからメンバーを外す
+		protected.PUT("/conversations/:id/members/:user_id/role", controllers.UpdateConversationMemberRole) // メンバーの役割を変更
+		protected.POST("/conversations/:id/leave", controllers.LeaveConversation) // グループから退出
+		protected.GET("/conversations/:id/messages", controllers.ListMessages) // 会話のメッセージ一覧
+		protected.POST("/conversations/:id/messages", controllers.SendMessage) // メッセージを送信
+		protected.DELETE("/conversations/:id/messages/:message_id", controllers.DeleteMessage) // メッセージを削除
+		protected.POST("/conversations/:id/read", controllers.MarkConversationRead) // 会話を既読にする
 		// その他の保護されたルート
 	}
 
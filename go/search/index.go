@@ -0,0 +1,122 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// インデックスを更新する投稿IDのキュー
+const queueKey = "search:queue"
+
+// 複数のインスタンスで同時に取り込みを行わないためのロック
+const backfillLockKey = "search:backfill:lock"
+
+// 取り込み時に一度に読み込む投稿の数
+const backfillBatchSize = 500
+
+// 大文字・小文字と全角・半角の違いを無視して検索できるよう、本文と検索語を同じ形に揃える
+func normalize(s string) string {
+	return strings.ToLower(norm.NFKC.String(s))
+}
+
+// 投稿のインデックスの更新を依頼する。作成・削除のどちらの場合も呼び出し、
+// ワーカーが投稿の現在の状態に合わせてインデックスを追加・削除する
+func Enqueue(postID uint) {
+	if err := config.RDB.RPush(context.Background(), queueKey, postID).Err(); err != nil {
+		// 作成時のものは次回の起動時の取り込みで拾い直す
+		log.Printf("search: failed to enqueue post %d: %v", postID, err)
+	}
+}
+
+// インデックスを更新するワーカーを起動する。まだインデックスにない投稿もバックグラウンドで取り込み、
+// ユーザーの入力補完のインデックスがなければ作り直す
+func StartIndexer(n int) {
+	for i := 0; i < n; i++ {
+		go work()
+	}
+	go backfill()
+	go rebuildAutocomplete()
+}
+
+func work() {
+	ctx := context.Background()
+	for {
+		vals, err := config.RDB.BLPop(ctx, 5*time.Second, queueKey).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			log.Printf("search: failed to read queue: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		var id uint
+		if _, err := fmt.Sscan(vals[1], &id); err != nil {
+			continue
+		}
+		if err := Sync(id); err != nil {
+			log.Printf("search: failed to index post %d: %v", id, err)
+		}
+	}
+}
+
+// 投稿のインデックスを現在の状態に合わせる。削除された投稿、リポスト、本文のない投稿はインデックスから取り除く
+func Sync(postID uint) error {
+	var post models.Post
+	err := config.DB.First(&post, postID).Error
+	if err == gorm.ErrRecordNotFound || (err == nil && !indexable(&post)) {
+		return config.DB.Delete(&models.SearchDocument{}, postID).Error
+	}
+	if err != nil {
+		return err
+	}
+	return upsert(config.DB, []models.Post{post})
+}
+
+func indexable(p *models.Post) bool {
+	return p.RepostOfID == nil && strings.TrimSpace(p.Body) != ""
+}
+
+func upsert(db *gorm.DB, posts []models.Post) error {
+	docs := make([]models.SearchDocument, 0, len(posts))
+	for _, p := range posts {
+		if indexable(&p) {
+			docs = append(docs, models.SearchDocument{PostID: p.ID, UserID: p.UserID, Body: normalize(p.Body), CreatedAt: p.CreatedAt})
+		}
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"body"})}).Create(&docs).Error
+}
+
+// インデックスにない投稿を取り込む。
+// 検索を導入する前の投稿や、キューに入らなかった投稿（Enqueueに失敗した投稿やワーカーが処理できなかった投稿）をインデックスに追加する
+func backfill() {
+	ctx := context.Background()
+	ok, err := config.RDB.SetNX(ctx, backfillLockKey, 1, time.Hour).Result()
+	if err != nil || !ok {
+		return
+	}
+	defer config.RDB.Del(ctx, backfillLockKey)
+
+	var batch []models.Post
+	err = config.DB.Where("repost_of_id IS NULL").
+		Where("NOT EXISTS (SELECT 1 FROM search_documents WHERE search_documents.post_id = posts.id)").
+		FindInBatches(&batch, backfillBatchSize, func(tx *gorm.DB, n int) error {
+			return upsert(config.DB, batch)
+		}).Error
+	if err != nil {
+		log.Printf("search: failed to backfill posts: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/hashtags"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/Shota0616/go-sns/visibility"
+	"gorm.io/gorm"
+)
+
+// 検索語の最大文字数
+const MaxQueryLength = 200
+
+// 日付の指定に使う形式
+const DateLayout = "2006-01-02"
+
+// MySQLのngramパーサーが分かち書きする文字数（ngram_token_size）。これより短い語は全文検索で見つからない
+const ngramTokenSize = 2
+
+var (
+	ErrEmptyQuery   = errors.New("empty search query")
+	ErrInvalidQuery = errors.New("invalid search query")
+)
+
+// 検索条件。検索語は次のように書ける
+//
+//	東京 タワー     すべての語を含む投稿
+//	"東京タワー"    フレーズに一致する投稿
+//	from:alice      aliceの投稿
+//	#golang         ハッシュタグの付いた投稿
+//	since:2024-01-01 until:2024-01-31  期間（untilの日も含む）
+type Query struct {
+	Terms []string
+	From  string
+	Tags  []string
+	Since *time.Time
+	Until *time.Time
+}
+
+// 検索語を解析する
+func Parse(q string) (*Query, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return nil, ErrEmptyQuery
+	}
+	if utf8.RuneCountInString(q) > MaxQueryLength {
+		return nil, ErrInvalidQuery
+	}
+
+	query := &Query{}
+	for _, token := range tokenize(normalize(q)) {
+		if token.quoted {
+			query.Terms = append(query.Terms, token.text)
+			continue
+		}
+		switch {
+		case strings.HasPrefix(token.text, "from:"):
+			query.From = strings.TrimPrefix(strings.TrimPrefix(token.text, "from:"), "@")
+			if query.From == "" {
+				return nil, ErrInvalidQuery
+			}
+		case strings.HasPrefix(token.text, "since:"), strings.HasPrefix(token.text, "until:"):
+			t, err := time.ParseInLocation(DateLayout, token.text[len("since:"):], time.Local)
+			if err != nil {
+				return nil, ErrInvalidQuery
+			}
+			if strings.HasPrefix(token.text, "since:") {
+				query.Since = &t
+			} else {
+				end := t.AddDate(0, 0, 1)
+				query.Until = &end
+			}
+		case strings.HasPrefix(token.text, "#") && len(token.text) > 1:
+			query.Tags = append(query.Tags, hashtags.Normalize(token.text[1:]))
+		default:
+			query.Terms = append(query.Terms, token.text)
+		}
+	}
+	if len(query.Terms) == 0 && query.From == "" && len(query.Tags) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	return query, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// 空白で区切る。ダブルクォートで囲んだ部分は空白を含めて1つのフレーズとする
+func tokenize(q string) []token {
+	var tokens []token
+	var b strings.Builder
+	quoted := false
+	flush := func() {
+		if text := strings.TrimSpace(b.String()); text != "" {
+			tokens = append(tokens, token{text: text, quoted: quoted})
+		}
+		b.Reset()
+	}
+	for _, r := range q {
+		switch {
+		case r == '"':
+			flush()
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			flush()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// 全文検索のBOOLEAN MODEで、語をすべて含む条件にする。ngramパーサーではダブルクォートで囲んだ語は
+// 並びが一致するものだけが見つかる
+func booleanQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = `+"` + t + `"`
+	}
+	return strings.Join(parts, " ")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// 検索条件に一致する投稿のIDを新しい順に返す。ハッシュタグのページと同じく公開の投稿だけを対象とし、
+// 閲覧者がブロック・ミュートしている相手の投稿は除く
+func PostIDs(viewerID uint, q *Query, maxID uint, limit int) ([]uint, error) {
+	db := config.DB.Table("search_documents").
+		Joins("JOIN posts ON posts.id = search_documents.post_id AND posts.deleted_at IS NULL").
+		Scopes(visibility.Listed)
+
+	var fulltext []string
+	for _, t := range q.Terms {
+		// ngramの長さに満たない語（1文字の漢字など）は全文検索では見つからないため部分一致で探す
+		if utf8.RuneCountInString(t) < ngramTokenSize {
+			db = db.Where("search_documents.body LIKE ?", "%"+escapeLike(t)+"%")
+		} else {
+			fulltext = append(fulltext, t)
+		}
+	}
+	if len(fulltext) > 0 {
+		db = db.Where("MATCH(search_documents.body) AGAINST (? IN BOOLEAN MODE)", booleanQuery(fulltext))
+	}
+
+	if q.From != "" {
+		var author models.User
+		if err := config.DB.Select("id").Where("username = ?", q.From).First(&author).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return []uint{}, nil
+			}
+			return nil, err
+		}
+		db = db.Where("search_documents.user_id = ?", author.ID)
+	}
+	for _, tag := range q.Tags {
+		db = db.Where("search_documents.post_id IN (?)",
+			config.DB.Table("post_hashtags").Select("post_hashtags.post_id").
+				Joins("JOIN hashtags ON hashtags.id = post_hashtags.hashtag_id").
+				Where("hashtags.name = ?", tag))
+	}
+	if q.Since != nil {
+		db = db.Where("search_documents.created_at >= ?", *q.Since)
+	}
+	if q.Until != nil {
+		db = db.Where("search_documents.created_at < ?", *q.Until)
+	}
+	filter, err := relations.For(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if hidden := filter.HiddenIDs(); len(hidden) > 0 {
+		db = db.Where("search_documents.user_id NOT IN ?", hidden)
+	}
+	if maxID > 0 {
+		db = db.Where("search_documents.post_id < ?", maxID)
+	}
+
+	var ids []uint
+	err = db.Order("search_documents.post_id DESC").Limit(limit).Pluck("search_documents.post_id", &ids).Error
+	return ids, err
+}
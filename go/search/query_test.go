@@ -0,0 +1,86 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []token
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"東京 タワー", []token{{text: "東京"}, {text: "タワー"}}},
+		{"a\tb\nc", []token{{text: "a"}, {text: "b"}, {text: "c"}}},
+		{`"東京 タワー" 夜景`, []token{{text: "東京 タワー", quoted: true}, {text: "夜景"}}},
+		{`前"フレーズ"後`, []token{{text: "前"}, {text: "フレーズ", quoted: true}, {text: "後"}}},
+		// 閉じていないダブルクォートは最後までをフレーズとする
+		{`a "b c`, []token{{text: "a"}, {text: "b c", quoted: true}}},
+		{`""`, nil},
+		{`"from:alice"`, []token{{text: "from:alice", quoted: true}}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %+v, want %+v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	date := func(s string) *time.Time {
+		d, err := time.ParseInLocation(DateLayout, s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &d
+	}
+	tests := []struct {
+		name    string
+		q       string
+		want    *Query
+		wantErr error
+	}{
+		{"空", "  ", nil, ErrEmptyQuery},
+		{"長すぎる", strings.Repeat("あ", MaxQueryLength+1), nil, ErrInvalidQuery},
+		{"上限の長さ", strings.Repeat("あ", MaxQueryLength), &Query{Terms: []string{strings.Repeat("あ", MaxQueryLength)}}, nil},
+		{"語", "東京 タワー", &Query{Terms: []string{"東京", "タワー"}}, nil},
+		{"大文字と全角を揃える", "ＧＯ　Lang", &Query{Terms: []string{"go", "lang"}}, nil},
+		{"フレーズ", `"東京 タワー"`, &Query{Terms: []string{"東京 タワー"}}, nil},
+		{"全角のダブルクォート", "＂東京 タワー＂", &Query{Terms: []string{"東京 タワー"}}, nil},
+		{"フレーズ内の演算子は語として扱う", `"from:alice"`, &Query{Terms: []string{"from:alice"}}, nil},
+		{"投稿者", "from:@Alice 猫", &Query{Terms: []string{"猫"}, From: "alice"}, nil},
+		{"投稿者が空", "from: 猫", nil, ErrInvalidQuery},
+		{"ハッシュタグ", "#Go ＃猫", &Query{Tags: []string{"go", "猫"}}, nil},
+		{"#だけは語", "#", &Query{Terms: []string{"#"}}, nil},
+		{"期間", "猫 since:2024-01-01 until:2024-01-31", &Query{Terms: []string{"猫"}, Since: date("2024-01-01"), Until: date("2024-02-01")}, nil},
+		{"日付の形式が違う", "猫 since:2024/01/01", nil, ErrInvalidQuery},
+		{"期間だけ", "since:2024-01-01", nil, ErrEmptyQuery},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.q)
+			if err != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, want %v", tt.q, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBooleanQuery(t *testing.T) {
+	if got, want := booleanQuery([]string{"東京", "夜 景"}), `+"東京" +"夜 景"`; got != want {
+		t.Errorf("booleanQuery() = %q, want %q", got, want)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got, want := escapeLike(`a_b%c\d`), `a\_b\%c\\d`; got != want {
+		t.Errorf("escapeLike() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// 入力補完で返すユーザーの最大数
+	MaxAutocomplete = 10
+	// ユーザー検索で返すユーザーの最大数
+	MaxUsers = 50
+	// 入力補完で並べ替えの候補にするユーザー数（名前の辞書順で先頭から取る。フォロー・フォロワーの相手はこれとは別に探す）
+	autocompleteCandidates = 200
+)
+
+// 入力補完用のインデックス。「正規化した名前\x00ユーザーID」をスコア0で入れ、ZRANGEBYLEXで前方一致を引く
+const autocompleteKey = "users:autocomplete"
+
+// インデックスを作り直すときのロック
+const autocompleteLockKey = "users:autocomplete:lock"
+
+// ユーザーがインデックスに入れたエントリ（名前の変更時に古いエントリを消すため）
+func entriesKey(userID uint) string {
+	return fmt.Sprintf("users:autocomplete:%d", userID)
+}
+
+// ユーザー名と表示名、表示名に含まれる各単語で前方一致させる
+func autocompleteEntries(u *models.User) []string {
+	names := []string{normalize(u.Username)}
+	if name := normalize(strings.TrimSpace(u.DisplayName)); name != "" {
+		names = append(names, name)
+		if words := strings.Fields(name); len(words) > 1 {
+			names = append(names, words[1:]...)
+		}
+	}
+	seen := make(map[string]bool, len(names))
+	entries := make([]string, 0, len(names))
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			entries = append(entries, n+"\x00"+strconv.FormatUint(uint64(u.ID), 10))
+		}
+	}
+	return entries
+}
+
+// ユーザーを入力補完のインデックスに入れ直す。ユーザー名・表示名の変更時やアカウントの有効化時に呼び出す
+func IndexUser(ctx context.Context, u *models.User) error {
+	old, err := config.RDB.SMembers(ctx, entriesKey(u.ID)).Result()
+	if err != nil {
+		return err
+	}
+	entries := autocompleteEntries(u)
+	_, err = config.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(old) > 0 {
+			members := make([]interface{}, len(old))
+			for i, m := range old {
+				members[i] = m
+			}
+			pipe.ZRem(ctx, autocompleteKey, members...)
+			pipe.Del(ctx, entriesKey(u.ID))
+		}
+		zs := make([]*redis.Z, len(entries))
+		members := make([]interface{}, len(entries))
+		for i, e := range entries {
+			zs[i] = &redis.Z{Score: 0, Member: e}
+			members[i] = e
+		}
+		pipe.ZAdd(ctx, autocompleteKey, zs...)
+		pipe.SAdd(ctx, entriesKey(u.ID), members...)
+		return nil
+	})
+	return err
+}
+
+// ユーザーを入力補完のインデックスから取り除く
+func RemoveUser(ctx context.Context, userID uint) error {
+	old, err := config.RDB.SMembers(ctx, entriesKey(userID)).Result()
+	if err != nil || len(old) == 0 {
+		return err
+	}
+	members := make([]interface{}, len(old))
+	for i, m := range old {
+		members[i] = m
+	}
+	_, err = config.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, autocompleteKey, members...)
+		pipe.Del(ctx, entriesKey(userID))
+		return nil
+	})
+	return err
+}
+
+// 入力補完のインデックスがなければ（Redisを入れ替えた場合など）全ユーザーから作り直す
+func rebuildAutocomplete() {
+	ctx := context.Background()
+	n, err := config.RDB.Exists(ctx, autocompleteKey).Result()
+	if err != nil || n > 0 {
+		return
+	}
+	ok, err := config.RDB.SetNX(ctx, autocompleteLockKey, 1, time.Hour).Result()
+	if err != nil || !ok {
+		return
+	}
+	defer config.RDB.Del(ctx, autocompleteLockKey)
+
+	var batch []models.User
+	err = config.DB.Select("id", "username", "display_name").Where("is_active = ?", true).
+		FindInBatches(&batch, backfillBatchSize, func(tx *gorm.DB, n int) error {
+			for i := range batch {
+				if err := IndexUser(ctx, &batch[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		log.Printf("search: failed to rebuild autocomplete index: %v", err)
+	}
+}
+
+// 閲覧者との関係で並べ替える。お互いにフォローしている相手、フォローしている相手、
+// フォローされている相手の順に優先し、同じ場合はフォロワーの多い順にする。ユーザー名が完全に一致すれば最優先
+func ranked(viewerID uint, exact string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		following := config.DB.Model(&models.Follow{}).Select("followee_id").Where("follower_id = ?", viewerID)
+		followers := config.DB.Model(&models.Follow{}).Select("follower_id").Where("followee_id = ?", viewerID)
+		return db.Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "users.username = ? DESC, 2 * (users.id IN (?)) + (users.id IN (?)) DESC, users.followers_count DESC, users.id",
+			Vars:               []interface{}{exact, following, followers},
+			WithoutParentheses: true,
+		}})
+	}
+}
+
+// 検索できるユーザー（停止中のユーザーと、ブロックしている・されている相手は除く）
+func searchable(viewerID uint) (func(*gorm.DB) *gorm.DB, error) {
+	filter, err := relations.For(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	blocked := filter.BlockedIDs()
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("users.is_active = ?", true)
+		if len(blocked) > 0 {
+			db = db.Where("users.id NOT IN ?", blocked)
+		}
+		return db
+	}, nil
+}
+
+// ユーザー名・表示名で検索する。ユーザー名と表示名（表示名の各単語を含む）の前方一致で探す
+func Users(viewerID uint, q string, limit int) ([]models.User, error) {
+	q = strings.TrimPrefix(normalize(strings.TrimSpace(q)), "@")
+	if q == "" {
+		return nil, ErrEmptyQuery
+	}
+	if limit > MaxUsers {
+		limit = MaxUsers
+	}
+	visible, err := searchable(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	prefix := escapeLike(q) + "%"
+	var users []models.User
+	err = config.DB.Model(&models.User{}).
+		Scopes(visible, ranked(viewerID, q)).
+		Where("(users.username LIKE ? OR users.display_name LIKE ? OR users.display_name LIKE ?)", prefix, prefix, "% "+prefix).
+		Limit(limit).
+		Find(&users).Error
+	return users, err
+}
+
+// 入力中の文字列で始まるユーザーを返す（入力補完用）。Redisのインデックスで候補を絞り、並べ替えだけをMySQLで行う。
+// インデックスからは名前の辞書順で先頭の候補しか取らないため、フォローしている相手・フォロワーで一致する人はMySQLで別に探して加える
+func Autocomplete(ctx context.Context, viewerID uint, prefix string) ([]models.User, error) {
+	prefix = strings.TrimPrefix(normalize(strings.TrimSpace(prefix)), "@")
+	if prefix == "" {
+		return []models.User{}, nil
+	}
+	members, err := config.RDB.ZRangeByLex(ctx, autocompleteKey, &redis.ZRangeBy{
+		Min:   "[" + prefix,
+		Max:   "[" + prefix + "\xff",
+		Count: autocompleteCandidates,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(members))
+	for _, m := range members {
+		i := strings.LastIndexByte(m, 0)
+		if i < 0 {
+			continue
+		}
+		if id, err := strconv.ParseUint(m[i+1:], 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+
+	visible, err := searchable(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	following := config.DB.Model(&models.Follow{}).Select("followee_id").Where("follower_id = ?", viewerID)
+	followers := config.DB.Model(&models.Follow{}).Select("follower_id").Where("followee_id = ?", viewerID)
+	like := escapeLike(prefix) + "%"
+	var users []models.User
+	err = config.DB.Model(&models.User{}).
+		Scopes(visible, ranked(viewerID, prefix)).
+		Where(config.DB.Where("users.id IN ?", ids).
+			Or("(users.id IN (?) OR users.id IN (?)) AND (users.username LIKE ? OR users.display_name LIKE ? OR users.display_name LIKE ?)",
+				following, followers, like, like, "% "+like)).
+		Limit(MaxAutocomplete).
+		Find(&users).Error
+	return users, err
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocal(t *testing.T) *Local {
+	return NewLocal(t.TempDir(), "http://localhost/api/media/files/", []byte("secret"))
+}
+
+func TestLocal(t *testing.T) {
+	testStorage(t, newTestLocal(t), PrivatePrefix+"test/")
+}
+
+func TestLocalRejectsKeysOutsideRoot(t *testing.T) {
+	l := newTestLocal(t)
+	ctx := context.Background()
+	outside := filepath.Join(filepath.Dir(l.Root), "outside.txt")
+	t.Cleanup(func() { os.Remove(outside) })
+
+	for _, key := range []string{"", "/", "../outside.txt", "public/../../outside.txt", "public/..", `..\outside.txt`} {
+		t.Run(key, func(t *testing.T) {
+			if err := l.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Put(%q) error = %v, want ErrNotFound", key, err)
+			}
+			if _, err := l.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(%q) error = %v, want ErrNotFound", key, err)
+			}
+			if _, err := l.FilePath(key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("FilePath(%q) error = %v, want ErrNotFound", key, err)
+			}
+		})
+	}
+	if _, err := os.Stat(outside); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a file was written outside the root: %v", err)
+	}
+}
+
+// 署名付きURLからキーと検証用のパラメータを取り出す
+func parseSignedURL(t *testing.T, l *Local, signed string) (key, expires, signature string) {
+	t.Helper()
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := url.Parse(l.BaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key = strings.TrimPrefix(u.Path, base.Path+"/")
+	return key, u.Query().Get("expires"), u.Query().Get("signature")
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	l := newTestLocal(t)
+	ctx := context.Background()
+	signed, err := l.SignedURL(ctx, "private/a.txt", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, expires, signature := parseSignedURL(t, l, signed)
+	if key != "private/a.txt" {
+		t.Fatalf("SignedURL() key = %q, want %q", key, "private/a.txt")
+	}
+	past := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+	other := NewLocal(l.Root, l.BaseURL, []byte("other"))
+
+	tests := []struct {
+		name                    string
+		l                       *Local
+		key, expires, signature string
+		want                    bool
+	}{
+		{"正しい署名", l, key, expires, signature, true},
+		{"別のキー", l, "private/b.txt", expires, signature, false},
+		{"期限を書き換えた", l, key, expires + "0", signature, false},
+		{"署名を書き換えた", l, key, expires, strings.Repeat("0", len(signature)), false},
+		{"署名なし", l, key, expires, "", false},
+		{"期限が数値でない", l, key, "tomorrow", signature, false},
+		{"別の鍵", other, key, expires, signature, false},
+		{"期限切れ", l, key, past, l.sign(key, mustParseInt(t, past)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.l.Verify(tt.key, tt.expires, tt.signature); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 有効期限が過ぎた署名付きURLは使えない
+	expired, err := l.SignedURL(ctx, "private/a.txt", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, expires, signature := parseSignedURL(t, l, expired); l.Verify(key, expires, signature) {
+		t.Error("Verify() = true for an expired URL")
+	}
+}
+
+func mustParseInt(t *testing.T, s string) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// キーの先頭で公開・非公開を分ける。publicのファイルは誰でも取得でき、
+// privateのファイルは署名付きの期限のあるURLでのみ取得できる
+const (
+	PublicPrefix  = "public/"
+	PrivatePrefix = "private/"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// 保存しているファイルの情報
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ファイルの保存先
+type Storage interface {
+	// ファイルを保存する。同じキーのファイルがあれば上書きする
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// ファイルを読み込む。ない場合はErrNotFoundを返す
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// ファイルがあるかどうか
+	Exists(ctx context.Context, key string) (bool, error)
+	// ファイルを削除する。ない場合もエラーにしない
+	Delete(ctx context.Context, key string) error
+	// prefixで始まるファイルを順に渡す
+	List(ctx context.Context, prefix string, fn func(Object) error) error
+	// 公開するファイルのURL
+	PublicURL(key string) string
+	// 非公開のファイルを期限付きで取得できる署名付きURL
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// 内容のハッシュから作るキー。同じ内容のファイルは同じキーになるため、重複して保存されない
+func ContentKey(prefix string, data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	h := hex.EncodeToString(sum[:])
+	return prefix + "blobs/" + h[:2] + "/" + h + ext
+}
+
+func IsPublic(key string) bool {
+	return strings.HasPrefix(key, PublicPrefix)
+}
+
+// 公開するファイルはそのままのURL、非公開のファイルは署名付きURLを返す
+func URL(ctx context.Context, s Storage, key string, ttl time.Duration) (string, error) {
+	if IsPublic(key) {
+		return s.PublicURL(key), nil
+	}
+	return s.SignedURL(ctx, key, ttl)
+}
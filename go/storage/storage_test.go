@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func put(t *testing.T, s Storage, key, body string) {
+	t.Helper()
+	if err := s.Put(context.Background(), key, strings.NewReader(body), int64(len(body)), "text/plain"); err != nil {
+		t.Fatalf("Put(%q) error = %v", key, err)
+	}
+}
+
+func read(t *testing.T, s Storage, key string) string {
+	t.Helper()
+	r, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q) error = %v", key, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Get(%q): read error = %v", key, err)
+	}
+	return string(b)
+}
+
+func fetch(t *testing.T, url string) (int, string) {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("GET %s: read error = %v", url, err)
+	}
+	return res.StatusCode, string(b)
+}
+
+// LocalとS3で共通の動作を確認する。prefixの下だけを使い、終わったら消す
+func testStorage(t *testing.T, s Storage, prefix string) {
+	ctx := context.Background()
+	a, b := prefix+"a.txt", prefix+"dir/b.txt"
+	t.Cleanup(func() {
+		s.Delete(ctx, a)
+		s.Delete(ctx, b)
+	})
+
+	put(t, s, a, "first")
+	put(t, s, b, "second")
+	if got := read(t, s, a); got != "first" {
+		t.Errorf("Get(%q) = %q, want %q", a, got, "first")
+	}
+	// 同じキーは上書きする
+	put(t, s, a, "overwritten")
+	if got := read(t, s, a); got != "overwritten" {
+		t.Errorf("Get(%q) after overwrite = %q, want %q", a, got, "overwritten")
+	}
+	if ok, err := s.Exists(ctx, b); err != nil || !ok {
+		t.Errorf("Exists(%q) = %v, %v, want true", b, ok, err)
+	}
+
+	var keys []string
+	err := s.List(ctx, prefix, func(o Object) error {
+		keys = append(keys, o.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	sort.Strings(keys)
+	if want := []string{a, b}; strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("List() = %q, want %q", keys, want)
+	}
+
+	if err := s.Delete(ctx, a); err != nil {
+		t.Fatalf("Delete(%q) error = %v", a, err)
+	}
+	if _, err := s.Get(ctx, a); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(%q) after delete error = %v, want ErrNotFound", a, err)
+	}
+	if ok, err := s.Exists(ctx, a); err != nil || ok {
+		t.Errorf("Exists(%q) after delete = %v, %v, want false", a, ok, err)
+	}
+	// ないファイルの削除はエラーにしない
+	if err := s.Delete(ctx, a); err != nil {
+		t.Errorf("Delete(%q) of a missing object error = %v", a, err)
+	}
+	if _, err := s.Get(ctx, prefix+"missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing object error = %v, want ErrNotFound", err)
+	}
+
+	url, err := s.SignedURL(ctx, b, time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL(%q) error = %v", b, err)
+	}
+	if !strings.Contains(url, "dir/b.txt?") {
+		t.Errorf("SignedURL(%q) = %q, want a URL of the key with a query", b, url)
+	}
+}
@@ -0,0 +1,333 @@
+package suggestions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/relations"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm/clause"
+)
+
+// おすすめの理由
+const (
+	// フォローしている人たちがフォローしている
+	ReasonFriendsOfFriends = "friends_of_friends"
+	// 自分をフォローしている
+	ReasonFollowsYou = "follows_you"
+	// 同じ言語の人気のユーザー
+	ReasonPopular = "popular"
+)
+
+const (
+	// 1ユーザーに保存するおすすめの最大数
+	MaxSuggestions = 50
+	// 一覧で返す件数の既定値
+	DefaultLimit = 10
+	// 理由ごとに集める候補の数
+	candidateLimit = 200
+	popularLimit   = 50
+)
+
+// スコアの重み。共通のフォローは1人につき1点、フォローされていれば3点、人気のユーザーは0.5点とする
+const (
+	weightMutual     = 1.0
+	weightFollowsYou = 3.0
+	weightPopular    = 0.5
+)
+
+// キャッシュの有効期限。期限が切れる前にバックグラウンドで計算し直す
+const cacheTTL = 24 * time.Hour
+
+// この期間おすすめを見ていないユーザーはバックグラウンドで計算し直さない
+const activeWindow = 7 * 24 * time.Hour
+
+// おすすめを見たユーザー（スコアは最後に見た日時）
+const activeKey = "suggestions:active"
+
+// 複数のインスタンスで同時に計算し直さないためのロック
+const refreshLockKey = "suggestions:refresh:lock"
+
+func cacheKey(userID uint) string {
+	return fmt.Sprintf("suggestions:%d", userID)
+}
+
+// おすすめのユーザー
+type Suggestion struct {
+	UserID uint    `json:"user_id"`
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+	// 自分がフォローしている人のうち、この相手をフォローしている人数
+	MutualCount int64 `json:"mutual_count"`
+}
+
+// フォローの関係からおすすめのユーザーを計算する。フォロー済み、ブロック・ミュートしている（されている）相手、
+// おすすめから外した相手は除き、スコアの高い順に返す
+func Compute(userID uint) ([]Suggestion, error) {
+	var user models.User
+	if err := config.DB.Select("id", "language").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	candidates := make(map[uint]*Suggestion)
+	add := func(id uint, score float64) *Suggestion {
+		s, ok := candidates[id]
+		if !ok {
+			s = &Suggestion{UserID: id}
+			candidates[id] = s
+		}
+		s.Score += score
+		return s
+	}
+
+	// フォローしている人たちがフォローしている相手（共通のフォローが多いほど高くする）。
+	// 件数を絞る前に、フォロー済みの相手・おすすめから外した相手・自分を除いておく
+	following := config.DB.Model(&models.Follow{}).Select("followee_id").Where("follower_id = ?", userID)
+	dismissed := config.DB.Model(&models.SuggestionDismissal{}).Select("dismissed_id").Where("user_id = ?", userID)
+	var mutuals []struct {
+		UserID      uint
+		MutualCount int64
+	}
+	err := config.DB.Model(&models.Follow{}).
+		Select("followee_id AS user_id, COUNT(*) AS mutual_count").
+		Where("follower_id IN (?)", following).
+		Where("followee_id NOT IN (?) AND followee_id NOT IN (?) AND followee_id <> ?", following, dismissed, userID).
+		Group("followee_id").
+		Order("mutual_count DESC").
+		Limit(candidateLimit).
+		Scan(&mutuals).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mutuals {
+		add(m.UserID, weightMutual*float64(m.MutualCount)).MutualCount = m.MutualCount
+	}
+
+	// フォローを返していないフォロワー
+	var followers []uint
+	if err := config.DB.Model(&models.Follow{}).Where("followee_id = ?", userID).
+		Where("follower_id NOT IN (?) AND follower_id NOT IN (?)", following, dismissed).
+		Order("id DESC").Limit(candidateLimit).Pluck("follower_id", &followers).Error; err != nil {
+		return nil, err
+	}
+	followsYou := make(map[uint]bool, len(followers))
+	for _, id := range followers {
+		add(id, weightFollowsYou)
+		followsYou[id] = true
+	}
+
+	// 同じ言語の人気のユーザー（言語を設定していなければ全体で人気のユーザー）
+	popularQuery := config.DB.Model(&models.User{}).
+		Where("is_active = ?", true).
+		Where("id NOT IN (?) AND id NOT IN (?) AND id <> ?", following, dismissed, userID)
+	if user.Language != "" {
+		popularQuery = popularQuery.Where("language = ?", user.Language)
+	}
+	var popular []uint
+	if err := popularQuery.Order("followers_count DESC").Limit(popularLimit).Pluck("id", &popular).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range popular {
+		add(id, weightPopular)
+	}
+
+	delete(candidates, userID)
+	if len(candidates) == 0 {
+		return []Suggestion{}, nil
+	}
+	ids := make([]uint, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	excluded, err := excludedIDs(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// 停止中・削除予定のユーザーは出さない。同じスコアならフォロワーの多い順にする
+	var users []models.User
+	if err := config.DB.Select("id", "followers_count").Where("id IN ? AND is_active = ?", ids, true).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	followersCount := make(map[uint]int64, len(users))
+	result := make([]Suggestion, 0, len(users))
+	for _, u := range users {
+		if excluded[u.ID] {
+			continue
+		}
+		s := candidates[u.ID]
+		switch {
+		case followsYou[u.ID]:
+			s.Reason = ReasonFollowsYou
+		case s.MutualCount > 0:
+			s.Reason = ReasonFriendsOfFriends
+		default:
+			s.Reason = ReasonPopular
+		}
+		followersCount[u.ID] = u.FollowersCount
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if followersCount[a.UserID] != followersCount[b.UserID] {
+			return followersCount[a.UserID] > followersCount[b.UserID]
+		}
+		return a.UserID < b.UserID
+	})
+	if len(result) > MaxSuggestions {
+		result = result[:MaxSuggestions]
+	}
+	return result, nil
+}
+
+// 候補のうち、おすすめに出さない相手（フォロー済み、ブロック・ミュート、おすすめから外した相手）
+func excludedIDs(userID uint, ids []uint) (map[uint]bool, error) {
+	excluded := make(map[uint]bool)
+	var followed, dismissed []uint
+	if err := config.DB.Model(&models.Follow{}).Where("follower_id = ? AND followee_id IN ?", userID, ids).
+		Pluck("followee_id", &followed).Error; err != nil {
+		return nil, err
+	}
+	if err := config.DB.Model(&models.SuggestionDismissal{}).Where("user_id = ? AND dismissed_id IN ?", userID, ids).
+		Pluck("dismissed_id", &dismissed).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range append(followed, dismissed...) {
+		excluded[id] = true
+	}
+	filter, err := relations.For(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if filter.Hides(id) {
+			excluded[id] = true
+		}
+	}
+	return excluded, nil
+}
+
+// おすすめを計算し直してキャッシュに保存する
+func Refresh(ctx context.Context, userID uint) ([]Suggestion, error) {
+	list, err := Compute(userID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.RDB.Set(ctx, cacheKey(userID), data, cacheTTL).Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// おすすめのユーザーを返す。キャッシュがなければその場で計算する。
+// キャッシュした後にフォローした相手やおすすめから外した相手などは取り除く
+func For(ctx context.Context, userID uint, limit int) ([]Suggestion, error) {
+	config.RDB.ZAdd(ctx, activeKey, &redis.Z{Score: float64(time.Now().Unix()), Member: userID})
+
+	var list []Suggestion
+	data, err := config.RDB.Get(ctx, cacheKey(userID)).Bytes()
+	switch {
+	case err == redis.Nil:
+		if list, err = Refresh(ctx, userID); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	ids := make([]uint, len(list))
+	for i, s := range list {
+		ids[i] = s.UserID
+	}
+	excluded, err := excludedIDs(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Suggestion, 0, limit)
+	for _, s := range list {
+		if len(result) == limit {
+			break
+		}
+		if !excluded[s.UserID] {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// 相手をおすすめから外す。外した相手は計算し直しても出さない
+func Dismiss(userID, dismissedID uint) error {
+	return config.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.SuggestionDismissal{UserID: userID, DismissedID: dismissedID}).Error
+}
+
+// 最近おすすめを見たユーザーのおすすめを定期的に計算し直す
+func StartRefresher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx := context.Background()
+			ok, err := config.RDB.SetNX(ctx, refreshLockKey, 1, interval).Result()
+			if err != nil || !ok {
+				continue
+			}
+			RefreshActive(ctx)
+		}
+	}()
+}
+
+// 最近おすすめを見たユーザー全員のおすすめを計算し直す。しばらく見ていないユーザーは対象から外す
+func RefreshActive(ctx context.Context) {
+	cutoff := strconv.FormatInt(time.Now().Add(-activeWindow).Unix(), 10)
+	if err := config.RDB.ZRemRangeByScore(ctx, activeKey, "-inf", "("+cutoff).Err(); err != nil {
+		log.Printf("suggestions: failed to expire inactive users: %v", err)
+		return
+	}
+	members, err := config.RDB.ZRange(ctx, activeKey, 0, -1).Result()
+	if err != nil {
+		log.Printf("suggestions: failed to load active users: %v", err)
+		return
+	}
+	for _, m := range members {
+		id, err := strconv.ParseUint(m, 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, err := Refresh(ctx, uint(id)); err != nil {
+			log.Printf("suggestions: failed to refresh user %d: %v", id, err)
+		}
+	}
+}
+
+// 削除されたユーザーのおすすめと、おすすめから外した（外された）記録を削除する
+func RemoveUser(userID uint) error {
+	ctx := context.Background()
+	if err := config.RDB.Del(ctx, cacheKey(userID)).Err(); err != nil {
+		return err
+	}
+	if err := config.RDB.ZRem(ctx, activeKey, userID).Err(); err != nil {
+		return err
+	}
+	return config.DB.Where("user_id = ? OR dismissed_id = ?", userID, userID).Delete(&models.SuggestionDismissal{}).Error
+}
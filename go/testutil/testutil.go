@@ -0,0 +1,190 @@
+// MySQLとRedisを使うテストの準備。接続先は環境変数で指定し、指定がなければテストを飛ばす。
+// テストのたびにすべてのテーブルとRedisのデータベースを空にするため、テスト専用のものを指定すること
+//
+//	TEST_DATABASE_DSN="user:password@tcp(127.0.0.1:3306)/sns_test?charset=utf8mb4&parseTime=True&loc=Local" \
+//	TEST_REDIS_URL="redis://127.0.0.1:6379/15" go test ./...
+package testutil
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	dsnEnv   = "TEST_DATABASE_DSN"
+	redisEnv = "TEST_REDIS_URL"
+)
+
+// テスト用のデータベースを使う間に取るロック
+const (
+	lockName    = "go-sns:test"
+	lockTimeout = 5 * time.Minute
+)
+
+var (
+	once    sync.Once
+	initErr error
+	// テーブルの作成は他のパッケージのテストと重ならないよう、ロックを取ってから行う
+	migrateOnce sync.Once
+)
+
+func connect(dsn, redisURL string) error {
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return err
+	}
+	config.DB = db
+
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return err
+	}
+	config.RDB = redis.NewClient(opts)
+	if err := config.RDB.Ping(context.Background()).Err(); err != nil {
+		return err
+	}
+	return loadLocales()
+}
+
+// config.InitI18nは作業ディレクトリからの相対パスで読み込むため、テストではこのファイルの位置から探す
+func loadLocales() error {
+	_, file, _, _ := runtime.Caller(0)
+	dir := filepath.Join(filepath.Dir(file), "..", "..", "locales")
+	bundle := i18n.NewBundle(language.English)
+	bundle.RegisterUnmarshalFunc("json", json.Unmarshal)
+	for _, name := range []string{"en.json", "ja.json"} {
+		if _, err := bundle.LoadMessageFile(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	config.Localizer = i18n.NewLocalizer(bundle, "en")
+	config.Bundle = bundle
+	return nil
+}
+
+// テスト用のMySQLとRedisに接続し、中身を空にする。環境変数が設定されていなければテストを飛ばす
+func Setup(t *testing.T) {
+	t.Helper()
+	dsn, redisURL := os.Getenv(dsnEnv), os.Getenv(redisEnv)
+	if dsn == "" || redisURL == "" {
+		t.Skipf("%s and %s are required", dsnEnv, redisEnv)
+	}
+	once.Do(func() { initErr = connect(dsn, redisURL) })
+	if initErr != nil {
+		t.Fatalf("testutil: failed to connect: %v", initErr)
+	}
+	lock(t)
+	migrateOnce.Do(config.MigrateDatabase)
+
+	// 外部キーの確認を止めて空にするため、同じ接続で実行する
+	err := config.DB.Connection(func(tx *gorm.DB) error {
+		tables, err := tx.Migrator().GetTables()
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec("SET FOREIGN_KEY_CHECKS = 0").Error; err != nil {
+			return err
+		}
+		defer tx.Exec("SET FOREIGN_KEY_CHECKS = 1")
+		for _, table := range tables {
+			if err := tx.Exec("TRUNCATE TABLE `" + table + "`").Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("testutil: failed to clean database: %v", err)
+	}
+	if err := config.RDB.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("testutil: failed to clean redis: %v", err)
+	}
+}
+
+// go testはパッケージごとに並行して実行するため、同じデータベースを使うテストが重ならないようにロックを取る。
+// ロックは接続に結びつくため、テストが終わるまで接続を1つ確保しておく
+func lock(t *testing.T) {
+	t.Helper()
+	sqlDB, err := config.DB.DB()
+	if err != nil {
+		t.Fatalf("testutil: %v", err)
+	}
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		t.Fatalf("testutil: %v", err)
+	}
+	var ok sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(lockTimeout.Seconds())).Scan(&ok); err != nil || ok.Int64 != 1 {
+		conn.Close()
+		t.Fatalf("testutil: failed to lock the test database: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName)
+		conn.Close()
+	})
+}
+
+// 有効なユーザーを作る
+func CreateUser(t *testing.T, username string) models.User {
+	t.Helper()
+	u := models.User{Username: username, Email: username + "@example.com", IsActive: true}
+	if err := config.DB.Create(&u).Error; err != nil {
+		t.Fatalf("testutil: failed to create user %s: %v", username, err)
+	}
+	return u
+}
+
+// 投稿を作る。公開範囲を指定しなければ公開の投稿になる
+func CreatePost(t *testing.T, p models.Post) models.Post {
+	t.Helper()
+	if err := config.DB.Omit("User", "RepostOf", "QuoteOf").Create(&p).Error; err != nil {
+		t.Fatalf("testutil: failed to create post: %v", err)
+	}
+	return p
+}
+
+// フォロー関係を作る
+func Follow(t *testing.T, followerID, followeeID uint) {
+	t.Helper()
+	if err := config.DB.Create(&models.Follow{FollowerID: followerID, FolloweeID: followeeID}).Error; err != nil {
+		t.Fatalf("testutil: failed to follow: %v", err)
+	}
+}
+
+// ブロックを作る
+func Block(t *testing.T, blockerID, blockedID uint) {
+	t.Helper()
+	if err := config.DB.Omit("Blocked").Create(&models.Block{BlockerID: blockerID, BlockedID: blockedID}).Error; err != nil {
+		t.Fatalf("testutil: failed to block: %v", err)
+	}
+}
+
+// ミュートを作る。expiresInが0なら期限なし、負の値なら期限切れのミュートになる
+func Mute(t *testing.T, muterID, mutedID uint, expiresIn time.Duration) {
+	t.Helper()
+	m := models.Mute{MuterID: muterID, MutedID: mutedID}
+	if expiresIn != 0 {
+		expiresAt := time.Now().Add(expiresIn)
+		m.ExpiresAt = &expiresAt
+	}
+	if err := config.DB.Omit("Muted").Create(&m).Error; err != nil {
+		t.Fatalf("testutil: failed to mute: %v", err)
+	}
+}
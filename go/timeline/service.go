@@ -0,0 +1,350 @@
+package timeline
+
+import (
+	"context"
+	"log"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/realtime"
+	"gorm.io/gorm"
+)
+
+// フォロー時にホームタイムラインへ取り込む過去投稿の件数
+var BackfillSize int64 = 50
+
+// 投稿者がセレブ（ファンアウト対象外）かどうか
+func IsCelebrity(user *models.User) bool {
+	return user.FollowersCount >= CelebrityThreshold
+}
+
+// 新しい投稿をタイムラインに配信する。
+// セレブの投稿はユーザータイムラインにのみ書き込み、フォロワー側では読み込み時にマージする
+func Publish(post *models.Post) {
+	ctx := context.Background()
+
+	if err := PushOwn(ctx, post.UserID, post.ID); err != nil {
+		log.Printf("timeline: failed to push own post %d: %v", post.ID, err)
+		return
+	}
+
+	var author models.User
+	if err := config.DB.Select("id", "followers_count").First(&author, post.UserID).Error; err != nil {
+		log.Printf("timeline: failed to load author %d: %v", post.UserID, err)
+		return
+	}
+	if IsCelebrity(&author) {
+		return
+	}
+
+	// 投稿者をメンバーに含むリストのタイムラインにも配信する
+	var listIDs []uint
+	config.DB.Model(&models.ListMember{}).Where("user_id = ?", post.UserID).Pluck("list_id", &listIDs)
+	if err := FanOutLists(ctx, post.ID, listIDs); err != nil {
+		log.Printf("timeline: failed to fan out post %d to lists: %v", post.ID, err)
+	}
+
+	// フォロワーをバッチで取得しながらファンアウトし、接続中のフォロワーに新しい投稿を知らせる。
+	// セレブの投稿はフォロワーが多すぎるため知らせない
+	event := timelineEvent{PostID: post.ID, UserID: post.UserID, RepostOfID: post.RepostOfID}
+	var follows []models.Follow
+	config.DB.Select("id", "follower_id").
+		Where("followee_id = ?", post.UserID).
+		FindInBatches(&follows, FanOutBatchSize, func(tx *gorm.DB, batch int) error {
+			ids := make([]uint, len(follows))
+			for i, f := range follows {
+				ids[i] = f.FollowerID
+			}
+			var err error
+			if post.RepostOfID != nil {
+				err = FanOutRepost(ctx, post.ID, *post.RepostOfID, ids)
+			} else {
+				err = FanOut(ctx, post.ID, ids)
+			}
+			if err != nil {
+				log.Printf("timeline: failed to fan out post %d: %v", post.ID, err)
+				return err
+			}
+			realtime.PublishMany(ids, realtime.TypeTimeline, event)
+			return nil
+		})
+}
+
+// 宛先を限った投稿（ダイレクト）を、投稿者本人と宛先のユーザーのホームタイムラインにだけ配信する
+func PublishTo(post *models.Post, recipientIDs []uint) {
+	ctx := context.Background()
+	if err := PushOwn(ctx, post.UserID, post.ID); err != nil {
+		log.Printf("timeline: failed to push own post %d: %v", post.ID, err)
+		return
+	}
+	if len(recipientIDs) == 0 {
+		return
+	}
+	if err := FanOut(ctx, post.ID, recipientIDs); err != nil {
+		log.Printf("timeline: failed to fan out post %d: %v", post.ID, err)
+		return
+	}
+	realtime.PublishMany(recipientIDs, realtime.TypeTimeline, timelineEvent{PostID: post.ID, UserID: post.UserID})
+}
+
+// リアルタイムで配信するタイムラインのイベント
+type timelineEvent struct {
+	PostID     uint  `json:"post_id"`
+	UserID     uint  `json:"user_id"`
+	RepostOfID *uint `json:"repost_of_id"`
+}
+
+// 投稿削除時にユーザータイムラインから取り除く。
+// ホームタイムラインに残ったIDは読み込み時の取得で削除済みとして除外される
+func Unpublish(post *models.Post) {
+	if err := RemoveFromUser(context.Background(), post.UserID, post.ID); err != nil {
+		log.Printf("timeline: failed to remove post %d: %v", post.ID, err)
+	}
+}
+
+// フォロー時、相手が通常ユーザーなら直近の投稿をホームタイムラインに取り込む
+func OnFollow(followerID uint, followee *models.User) {
+	if IsCelebrity(followee) {
+		return
+	}
+	ctx := context.Background()
+	ids, err := Recent(ctx, followee.ID, BackfillSize)
+	if err != nil {
+		log.Printf("timeline: failed to load recent posts of %d: %v", followee.ID, err)
+		return
+	}
+	pipe := config.RDB.Pipeline()
+	for _, id := range ids {
+		push(ctx, pipe, HomeKey(followerID), id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("timeline: failed to backfill home of %d: %v", followerID, err)
+	}
+}
+
+// フォロワーを1人減らした直後に同じトランザクションの中で呼び出し、フォロワー数がセレブのしきい値を下回ったかどうかを返す。
+// フォロワー数を更新した行はトランザクションが終わるまでロックされるため、しきい値をまたいだ更新は1回だけtrueになる
+func DroppedBelowThreshold(tx *gorm.DB, userID uint) (bool, error) {
+	var count int64
+	if err := tx.Model(&models.User{}).Select("followers_count").Where("id = ?", userID).Scan(&count).Error; err != nil {
+		return false, err
+	}
+	return count == CelebrityThreshold-1, nil
+}
+
+// セレブでなくなったユーザーの直近の投稿を、フォロワーのホームタイムラインとメンバーに含むリストのタイムラインに取り込む。
+// セレブの間の投稿はファンアウトしておらず、読み込み時のマージからも外れるため、そのままでは見えなくなる
+func OnCelebrityEnded(userID uint) {
+	ctx := context.Background()
+	ids, err := Recent(ctx, userID, BackfillSize)
+	if err != nil {
+		log.Printf("timeline: failed to load recent posts of %d: %v", userID, err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	// リポストはPublishと同じく、元の投稿がすでにあるホームタイムラインには入れない
+	var reposts []models.Post
+	if err := config.DB.Select("id", "repost_of_id").Where("id IN ? AND repost_of_id IS NOT NULL", ids).Find(&reposts).Error; err != nil {
+		log.Printf("timeline: failed to load reposts of %d: %v", userID, err)
+		return
+	}
+	originals := make(map[uint]uint, len(reposts))
+	for _, p := range reposts {
+		originals[p.ID] = *p.RepostOfID
+	}
+
+	var listIDs []uint
+	if err := config.DB.Model(&models.ListMember{}).Where("user_id = ?", userID).Pluck("list_id", &listIDs).Error; err != nil {
+		log.Printf("timeline: failed to load lists of %d: %v", userID, err)
+	}
+	for _, id := range ids {
+		if err := FanOutLists(ctx, id, listIDs); err != nil {
+			log.Printf("timeline: failed to backfill lists with posts of %d: %v", userID, err)
+			break
+		}
+	}
+
+	var follows []models.Follow
+	err = config.DB.Select("id", "follower_id").
+		Where("followee_id = ?", userID).
+		FindInBatches(&follows, FanOutBatchSize, func(tx *gorm.DB, batch int) error {
+			followerIDs := make([]uint, len(follows))
+			for i, f := range follows {
+				followerIDs[i] = f.FollowerID
+			}
+			// 投稿した順に取り込み、自分の投稿のリポストも元の投稿と重ならないようにする
+			for i := len(ids) - 1; i >= 0; i-- {
+				id := ids[i]
+				var err error
+				if originalID, ok := originals[id]; ok {
+					err = FanOutRepost(ctx, id, originalID, followerIDs)
+				} else {
+					err = FanOut(ctx, id, followerIDs)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		log.Printf("timeline: failed to backfill followers with posts of %d: %v", userID, err)
+	}
+}
+
+// フォロー解除時、相手の投稿をホームタイムラインから取り除く
+func OnUnfollow(followerID uint, followeeID uint) {
+	ctx := context.Background()
+	ids, err := Recent(ctx, followeeID, MaxEntries)
+	if err != nil {
+		log.Printf("timeline: failed to load recent posts of %d: %v", followeeID, err)
+		return
+	}
+	if err := RemoveFromHome(ctx, followerID, ids); err != nil {
+		log.Printf("timeline: failed to clean home of %d: %v", followerID, err)
+	}
+}
+
+// リストにメンバーを追加したとき、相手が通常ユーザーなら直近の投稿をリストタイムラインに取り込む
+func OnListAdd(listID uint, member *models.User) {
+	if IsCelebrity(member) {
+		return
+	}
+	ctx := context.Background()
+	ids, err := Recent(ctx, member.ID, BackfillSize)
+	if err != nil {
+		log.Printf("timeline: failed to load recent posts of %d: %v", member.ID, err)
+		return
+	}
+	pipe := config.RDB.Pipeline()
+	for _, id := range ids {
+		push(ctx, pipe, ListKey(listID), id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("timeline: failed to backfill list %d: %v", listID, err)
+	}
+}
+
+// リストからメンバーを外したとき、相手の投稿をリストタイムラインから取り除く
+func OnListRemove(listID uint, memberID uint) {
+	ctx := context.Background()
+	ids, err := Recent(ctx, memberID, MaxEntries)
+	if err != nil {
+		log.Printf("timeline: failed to load recent posts of %d: %v", memberID, err)
+		return
+	}
+	if err := RemoveFromList(ctx, listID, ids); err != nil {
+		log.Printf("timeline: failed to clean list %d: %v", listID, err)
+	}
+}
+
+// フォローしているセレブのユーザーIDを返す。本人がセレブの場合は本人も含める
+func CelebrityIDs(userID uint) ([]uint, error) {
+	var ids []uint
+	err := config.DB.Model(&models.User{}).
+		Where("followers_count >= ?", CelebrityThreshold).
+		Where("id IN (?) OR id = ?",
+			config.DB.Model(&models.Follow{}).Select("followee_id").Where("follower_id = ?", userID),
+			userID).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// ホームタイムラインの投稿をmaxIDより古いものから新しい順に最大limit件返す。
+// 続きがある場合は次ページのmaxIDも返す（続きがなければ0）
+func Home(userID uint, maxID uint, limit int) ([]models.Post, uint, error) {
+	celebrities, err := CelebrityIDs(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids, err := Merge(context.Background(), userID, celebrities, maxID, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	posts, err := Hydrate(ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	return Dedupe(posts), NextCursor(ids, limit), nil
+}
+
+// リストタイムラインの投稿をmaxIDより古いものから新しい順に最大limit件返す。
+// メンバーのうちセレブの投稿は読み込み時にマージする。続きがある場合は次ページのmaxIDも返す（続きがなければ0）
+func List(listID uint, maxID uint, limit int) ([]models.Post, uint, error) {
+	var celebrities []uint
+	err := config.DB.Model(&models.User{}).
+		Where("followers_count >= ?", CelebrityThreshold).
+		Where("id IN (?)", config.DB.Model(&models.ListMember{}).Select("user_id").Where("list_id = ?", listID)).
+		Pluck("id", &celebrities).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	ids, err := MergeList(context.Background(), listID, celebrities, maxID, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	posts, err := Hydrate(ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	return Dedupe(posts), NextCursor(ids, limit), nil
+}
+
+// 同じ投稿（元の投稿とそのリポスト）が複数含まれる場合、最も新しいものだけを残す。
+// ファンアウト時に重複を避けられないセレブのリポストなどを読み込み時に取り除く
+func Dedupe(posts []models.Post) []models.Post {
+	seen := make(map[uint]bool, len(posts))
+	result := posts[:0]
+	for _, p := range posts {
+		if seen[p.ContentID()] {
+			continue
+		}
+		seen[p.ContentID()] = true
+		result = append(result, p)
+	}
+	return result
+}
+
+// 取得したIDの件数がlimitに達していれば最後のIDを次ページのカーソルとして返す
+func NextCursor(ids []uint, limit int) uint {
+	if len(ids) < limit || len(ids) == 0 {
+		return 0
+	}
+	return ids[len(ids)-1]
+}
+
+// 投稿IDの並び順を保ったまま投稿を取得する。
+// 削除済みの投稿と、元の投稿が削除されたリポスト、停止中のユーザーの投稿は除外される
+func Hydrate(ids []uint) ([]models.Post, error) {
+	if len(ids) == 0 {
+		return []models.Post{}, nil
+	}
+	var found []models.Post
+	err := config.DB.Preload("User").
+		Preload("RepostOf.User").
+		Preload("RepostOf.QuoteOf.User").
+		Preload("QuoteOf.User").
+		Where("id IN ?", ids).Find(&found).Error
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Post, len(found))
+	for _, p := range found {
+		if p.RepostOfID != nil && p.RepostOf == nil {
+			continue
+		}
+		if !p.User.IsActive || (p.RepostOf != nil && !p.RepostOf.User.IsActive) {
+			continue
+		}
+		byID[p.ID] = p
+	}
+	posts := make([]models.Post, 0, len(found))
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			posts = append(posts, p)
+		}
+	}
+	return posts, nil
+}
@@ -0,0 +1,50 @@
+package timeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/testutil"
+)
+
+func TestOnCelebrityEndedSkipsRepostsOfShownPosts(t *testing.T) {
+	testutil.Setup(t)
+	ctx := context.Background()
+	follower := testutil.CreateUser(t, "follower")
+	author := testutil.CreateUser(t, "author")
+	friend := testutil.CreateUser(t, "friend")
+	testutil.Follow(t, follower.ID, author.ID)
+	testutil.Follow(t, follower.ID, friend.ID)
+
+	shown := testutil.CreatePost(t, models.Post{UserID: friend.ID, Body: "shown"})
+	if err := FanOut(ctx, shown.ID, []uint{follower.ID}); err != nil {
+		t.Fatal(err)
+	}
+	notShown := testutil.CreatePost(t, models.Post{UserID: friend.ID, Body: "not shown"})
+
+	// セレブの間の投稿はユーザータイムラインにだけ入っている
+	own := testutil.CreatePost(t, models.Post{UserID: author.ID, Body: "own"})
+	repostOfShown := testutil.CreatePost(t, models.Post{UserID: author.ID, RepostOfID: &shown.ID})
+	repostOfNotShown := testutil.CreatePost(t, models.Post{UserID: author.ID, RepostOfID: &notShown.ID})
+	repostOfOwn := testutil.CreatePost(t, models.Post{UserID: author.ID, RepostOfID: &own.ID})
+	for _, p := range []models.Post{own, repostOfShown, repostOfNotShown, repostOfOwn} {
+		if err := PushOwn(ctx, author.ID, p.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	OnCelebrityEnded(author.ID)
+
+	vals, err := config.RDB.ZRevRange(ctx, HomeKey(follower.ID), 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := parseIDs(vals)
+	want := []uint{repostOfNotShown.ID, own.ID, shown.ID}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("home timeline = %v, want %v", got, want)
+	}
+}
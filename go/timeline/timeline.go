@@ -0,0 +1,269 @@
+package timeline
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/go-redis/redis/v8"
+)
+
+// フォロワー数がこの値以上のアカウントは投稿時にファンアウトせず、
+// 読み込み時に各フォロワーのホームタイムラインへマージする
+var CelebrityThreshold int64 = 10000
+
+// 1つのタイムライン(ZSET)に保持する最大件数
+var MaxEntries int64 = 800
+
+// ファンアウト時に1回のパイプラインで書き込むフォロワー数
+var FanOutBatchSize = 1000
+
+// ホームタイムラインのキー（フォローしている通常ユーザーの投稿がファンアウトされる）
+func HomeKey(userID uint) string {
+	return fmt.Sprintf("timeline:home:%d", userID)
+}
+
+// ユーザータイムラインのキー（本人の投稿のみ。セレブのマージ元にもなる）
+func UserKey(userID uint) string {
+	return fmt.Sprintf("timeline:user:%d", userID)
+}
+
+// リストタイムラインのキー（リストのメンバーのうち通常ユーザーの投稿がファンアウトされる）
+func ListKey(listID uint) string {
+	return fmt.Sprintf("timeline:list:%d", listID)
+}
+
+// ホームタイムラインに表示中のリポスト元の投稿ID（スコアはリポストの投稿ID）
+func HomeRepostsKey(userID uint) string {
+	return fmt.Sprintf("timeline:home:%d:reposts", userID)
+}
+
+// リポストをホームタイムラインに追加する。元の投稿や同じ投稿の別のリポストが
+// 既に載っている場合は追加しない（複数のフォロー相手が同じ投稿をリポストしても1回だけ表示する）
+//
+// KEYS[1]: ホームタイムライン, KEYS[2]: 表示中のリポスト元
+// ARGV[1]: リポストの投稿ID, ARGV[2]: 元の投稿ID, ARGV[3]: 最大件数
+var pushRepostScript = redis.NewScript(`
+if redis.call("ZSCORE", KEYS[1], ARGV[2]) then
+	return 0
+end
+local shown = redis.call("ZSCORE", KEYS[2], ARGV[2])
+if shown and redis.call("ZSCORE", KEYS[1], shown) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[1], ARGV[1])
+redis.call("ZREMRANGEBYRANK", KEYS[1], 0, -(tonumber(ARGV[3]) + 1))
+redis.call("ZADD", KEYS[2], ARGV[1], ARGV[2])
+redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -(tonumber(ARGV[3]) + 1))
+return 1
+`)
+
+// 投稿IDをスコアとしてタイムラインに追加し、上限を超えた古いエントリを削除する
+func push(ctx context.Context, pipe redis.Pipeliner, key string, postID uint) {
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(postID), Member: postID})
+	pipe.ZRemRangeByRank(ctx, key, 0, -(MaxEntries + 1))
+}
+
+// 投稿者本人のユーザータイムラインとホームタイムラインに投稿を追加する
+func PushOwn(ctx context.Context, authorID uint, postID uint) error {
+	pipe := config.RDB.Pipeline()
+	push(ctx, pipe, UserKey(authorID), postID)
+	push(ctx, pipe, HomeKey(authorID), postID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// フォロワーのホームタイムラインに投稿をファンアウトする
+func FanOut(ctx context.Context, postID uint, followerIDs []uint) error {
+	for start := 0; start < len(followerIDs); start += FanOutBatchSize {
+		end := start + FanOutBatchSize
+		if end > len(followerIDs) {
+			end = len(followerIDs)
+		}
+		pipe := config.RDB.Pipeline()
+		for _, id := range followerIDs[start:end] {
+			push(ctx, pipe, HomeKey(id), postID)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 投稿者をメンバーに含むリストのタイムラインに投稿をファンアウトする
+func FanOutLists(ctx context.Context, postID uint, listIDs []uint) error {
+	if len(listIDs) == 0 {
+		return nil
+	}
+	pipe := config.RDB.Pipeline()
+	for _, id := range listIDs {
+		push(ctx, pipe, ListKey(id), postID)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// リポストをフォロワーのホームタイムラインにファンアウトする
+func FanOutRepost(ctx context.Context, postID uint, originalID uint, followerIDs []uint) error {
+	// EVALSHAがパイプライン内で失敗しないよう、先にスクリプトを読み込んでおく
+	if err := pushRepostScript.Load(ctx, config.RDB).Err(); err != nil {
+		return err
+	}
+	for start := 0; start < len(followerIDs); start += FanOutBatchSize {
+		end := start + FanOutBatchSize
+		if end > len(followerIDs) {
+			end = len(followerIDs)
+		}
+		pipe := config.RDB.Pipeline()
+		for _, id := range followerIDs[start:end] {
+			pushRepostScript.EvalSha(ctx, pipe, []string{HomeKey(id), HomeRepostsKey(id)}, postID, originalID, MaxEntries)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ホームタイムラインから指定した投稿を取り除く（フォロー解除時など）
+func RemoveFromHome(ctx context.Context, userID uint, postIDs []uint) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(postIDs))
+	for i, id := range postIDs {
+		members[i] = id
+	}
+	return config.RDB.ZRem(ctx, HomeKey(userID), members...).Err()
+}
+
+// リストタイムラインから指定した投稿を取り除く（メンバーを外したときなど）
+func RemoveFromList(ctx context.Context, listID uint, postIDs []uint) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(postIDs))
+	for i, id := range postIDs {
+		members[i] = id
+	}
+	return config.RDB.ZRem(ctx, ListKey(listID), members...).Err()
+}
+
+// ユーザータイムラインから投稿を取り除く（投稿削除時）
+func RemoveFromUser(ctx context.Context, userID uint, postID uint) error {
+	return config.RDB.ZRem(ctx, UserKey(userID), postID).Err()
+}
+
+// ユーザータイムラインの新しい順に最大limit件の投稿IDを返す
+func Recent(ctx context.Context, userID uint, limit int64) ([]uint, error) {
+	vals, err := config.RDB.ZRevRange(ctx, UserKey(userID), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseIDs(vals), nil
+}
+
+// ホームタイムラインとフォローしているセレブのユーザータイムラインをマージし、
+// maxIDより古い投稿IDを新しい順に最大limit件返す。maxIDが0の場合は最新から取得する
+func Merge(ctx context.Context, userID uint, celebrityIDs []uint, maxID uint, limit int) ([]uint, error) {
+	return merge(ctx, HomeKey(userID), celebrityIDs, maxID, limit)
+}
+
+// リストタイムラインとメンバーのうちセレブのユーザータイムラインをマージする。引数と戻り値はMergeと同じ
+func MergeList(ctx context.Context, listID uint, celebrityIDs []uint, maxID uint, limit int) ([]uint, error) {
+	return merge(ctx, ListKey(listID), celebrityIDs, maxID, limit)
+}
+
+func merge(ctx context.Context, key string, celebrityIDs []uint, maxID uint, limit int) ([]uint, error) {
+	max := "+inf"
+	if maxID > 0 {
+		max = fmt.Sprintf("(%d", maxID)
+	}
+	rangeBy := &redis.ZRangeBy{Min: "-inf", Max: max, Count: int64(limit)}
+
+	// 各ソースからlimit件ずつを1回のパイプラインでまとめて取得する
+	pipe := config.RDB.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, 0, len(celebrityIDs)+1)
+	cmds = append(cmds, pipe.ZRevRangeByScore(ctx, key, rangeBy))
+	for _, id := range celebrityIDs {
+		cmds = append(cmds, pipe.ZRevRangeByScore(ctx, UserKey(id), rangeBy))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	sources := make([][]uint, 0, len(cmds))
+	for _, cmd := range cmds {
+		vals, err := cmd.Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		if len(vals) > 0 {
+			sources = append(sources, parseIDs(vals))
+		}
+	}
+	return MergeSorted(sources, limit), nil
+}
+
+// 降順にソート済みの複数のID列をk-wayマージし、重複を除いて最大limit件を返す
+func MergeSorted(sources [][]uint, limit int) []uint {
+	h := make(mergeHeap, 0, len(sources))
+	for _, src := range sources {
+		if len(src) > 0 {
+			h = append(h, &cursor{ids: src})
+		}
+	}
+	heap.Init(&h)
+
+	result := make([]uint, 0, limit)
+	for h.Len() > 0 && len(result) < limit {
+		c := h[0]
+		id := c.ids[c.pos]
+		// セレブ化する前にファンアウトされた投稿などで同じIDが複数のソースに現れることがある
+		if len(result) == 0 || result[len(result)-1] != id {
+			result = append(result, id)
+		}
+		c.pos++
+		if c.pos < len(c.ids) {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return result
+}
+
+func parseIDs(vals []string) []uint {
+	ids := make([]uint, 0, len(vals))
+	for _, v := range vals {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids
+}
+
+type cursor struct {
+	ids []uint
+	pos int
+}
+
+// 先頭の投稿IDが大きい順に取り出す最大ヒープ
+type mergeHeap []*cursor
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].ids[h[i].pos] > h[j].ids[h[j].pos] }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*cursor)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	*h = old[:n-1]
+	return c
+}
@@ -0,0 +1,32 @@
+package timeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeSorted(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources [][]uint
+		limit   int
+		want    []uint
+	}{
+		{"ソースなし", nil, 10, []uint{}},
+		{"空のソースのみ", [][]uint{{}, {}}, 10, []uint{}},
+		{"1つのソース", [][]uint{{9, 5, 1}}, 10, []uint{9, 5, 1}},
+		{"複数のソースを降順に混ぜる", [][]uint{{10, 6, 2}, {9, 5}, {8, 7, 1}}, 10, []uint{10, 9, 8, 7, 6, 5, 2, 1}},
+		{"limitで打ち切る", [][]uint{{10, 6, 2}, {9, 5}}, 3, []uint{10, 9, 6}},
+		// セレブ化する前にファンアウトされた投稿は、ホームとユーザーの両方のタイムラインに入っている
+		{"重複を除く", [][]uint{{10, 8, 4}, {8, 4, 3}, {8}}, 10, []uint{10, 8, 4, 3}},
+		{"重複を除いてからlimitを数える", [][]uint{{5, 4}, {5, 4}, {3}}, 3, []uint{5, 4, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MergeSorted(tt.sources, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeSorted(%v, %d) = %v, want %v", tt.sources, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
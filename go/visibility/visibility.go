@@ -0,0 +1,198 @@
+package visibility
+
+import (
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/relations"
+	"gorm.io/gorm"
+)
+
+// 投稿の公開範囲
+const (
+	// 誰でも見られ、ハッシュタグや検索にも表示する
+	Public = "public"
+	// 誰でも見られるが、ハッシュタグや検索には表示しない
+	Unlisted = "unlisted"
+	// フォロワーとメンションされたユーザーだけが見られる
+	Followers = "followers"
+	// メンションされたユーザーだけが見られる
+	Direct = "direct"
+)
+
+func IsValid(v string) bool {
+	return v == Public || v == Unlisted || v == Followers || v == Direct
+}
+
+// 投稿の公開範囲。公開範囲を持たない古い投稿は公開として扱う
+func Of(p *models.Post) string {
+	if p.Visibility == "" {
+		return Public
+	}
+	return p.Visibility
+}
+
+// リポスト・引用できる投稿かどうか（フォロワー限定やダイレクトの投稿は広められない）
+func Shareable(p *models.Post) bool {
+	v := Of(p)
+	return v == Public || v == Unlisted
+}
+
+// ハッシュタグのページや検索など、フォローしていないユーザーの投稿を集めた一覧に出す投稿に絞り込む
+func Listed(db *gorm.DB) *gorm.DB {
+	return db.Where("posts.visibility = ?", Public)
+}
+
+// 閲覧者が見られる投稿に絞り込む（SQLで絞り込める範囲。ブロックはViewerで確認する）
+func Scope(viewerID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if viewerID == 0 {
+			return db.Where("posts.visibility IN ?", []string{Public, Unlisted})
+		}
+		return db.Where("(posts.visibility IN ? OR posts.user_id = ? OR (posts.visibility = ? AND posts.user_id IN (?)) OR posts.id IN (?))",
+			[]string{Public, Unlisted},
+			viewerID,
+			Followers,
+			config.DB.Model(&models.Follow{}).Select("followee_id").Where("follower_id = ?", viewerID),
+			config.DB.Model(&models.Mention{}).Select("post_id").Where("user_id = ?", viewerID))
+	}
+}
+
+// 閲覧者から投稿が見えるかどうかを判定する。投稿を返す処理はすべてこれを通して確認する
+type Viewer struct {
+	ID        uint
+	relations *relations.Filter
+	// 閲覧者がフォローしているユーザー（確認したものだけ）
+	following map[uint]bool
+	// 閲覧者がメンションされている投稿（確認したものだけ）
+	mentioned map[uint]bool
+	checked   map[uint]bool
+}
+
+// 閲覧者のブロック・ミュートを読み込む。ログインしていない場合はviewerIDに0を渡す
+func For(viewerID uint) (*Viewer, error) {
+	filter, err := relations.For(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	return &Viewer{
+		ID:        viewerID,
+		relations: filter,
+		following: make(map[uint]bool),
+		mentioned: make(map[uint]bool),
+		checked:   make(map[uint]bool),
+	}, nil
+}
+
+// ブロック・ミュートの判定
+func (v *Viewer) Relations() *relations.Filter {
+	return v.relations
+}
+
+// 公開範囲の判定に必要なフォロー関係とメンションをまとめて読み込む
+func (v *Viewer) prepare(posts []*models.Post) {
+	if v.ID == 0 {
+		return
+	}
+	var authorIDs, postIDs []uint
+	for _, p := range posts {
+		if p == nil || p.UserID == v.ID || v.checked[p.ID] {
+			continue
+		}
+		switch Of(p) {
+		case Followers:
+			authorIDs = append(authorIDs, p.UserID)
+			postIDs = append(postIDs, p.ID)
+		case Direct:
+			postIDs = append(postIDs, p.ID)
+		}
+		v.checked[p.ID] = true
+	}
+	if len(authorIDs) > 0 {
+		var ids []uint
+		config.DB.Model(&models.Follow{}).Where("follower_id = ? AND followee_id IN ?", v.ID, authorIDs).Pluck("followee_id", &ids)
+		for _, id := range ids {
+			v.following[id] = true
+		}
+	}
+	if len(postIDs) > 0 {
+		var ids []uint
+		config.DB.Model(&models.Mention{}).Where("user_id = ? AND post_id IN ?", v.ID, postIDs).Pluck("post_id", &ids)
+		for _, id := range ids {
+			v.mentioned[id] = true
+		}
+	}
+}
+
+// 公開範囲だけを見て、閲覧者が見られる投稿かどうか
+func (v *Viewer) allowed(p *models.Post) bool {
+	if v.ID != 0 && p.UserID == v.ID {
+		return true
+	}
+	switch Of(p) {
+	case Public, Unlisted:
+		return true
+	case Followers:
+		return v.following[p.UserID] || v.mentioned[p.ID]
+	case Direct:
+		return v.mentioned[p.ID]
+	}
+	return false
+}
+
+// 投稿を1件表示してよいかどうか。投稿者（リポストの場合はリポスト元の投稿者も）とブロックし合っていない、
+// かつ公開範囲に閲覧者が含まれる場合にtrueを返す。引用元が見えない場合は引用元を外す。
+// ミュートは一覧にだけ影響するため、ここでは見ない
+func (v *Viewer) Check(p *models.Post) bool {
+	all := []*models.Post{p, p.RepostOf, p.QuoteOf}
+	if p.RepostOf != nil {
+		all = append(all, p.RepostOf.QuoteOf)
+	}
+	v.prepare(all)
+	if v.relations.Blocks(p.UserID) || !v.allowed(p) {
+		return false
+	}
+	if p.RepostOf != nil && (v.relations.Blocks(p.RepostOf.UserID) || !v.allowed(p.RepostOf)) {
+		return false
+	}
+	if p.QuoteOf != nil && (v.relations.Blocks(p.QuoteOf.UserID) || !v.allowed(p.QuoteOf)) {
+		p.QuoteOf = nil
+	}
+	if p.RepostOf != nil && p.RepostOf.QuoteOf != nil &&
+		(v.relations.Blocks(p.RepostOf.QuoteOf.UserID) || !v.allowed(p.RepostOf.QuoteOf)) {
+		original := *p.RepostOf
+		original.QuoteOf = nil
+		p.RepostOf = &original
+	}
+	return true
+}
+
+// 一覧から見えない投稿を取り除く。ブロック・ミュートしている相手の投稿と、公開範囲に閲覧者が含まれない投稿を取り除き、
+// 見えない引用元は外す
+func (v *Viewer) Posts(posts []models.Post) []models.Post {
+	posts = v.relations.Posts(posts)
+	all := make([]*models.Post, 0, len(posts)*2)
+	for i := range posts {
+		all = append(all, &posts[i], posts[i].RepostOf, posts[i].QuoteOf)
+		if posts[i].RepostOf != nil {
+			all = append(all, posts[i].RepostOf.QuoteOf)
+		}
+	}
+	v.prepare(all)
+
+	result := make([]models.Post, 0, len(posts))
+	for _, p := range posts {
+		if !v.allowed(&p) || (p.RepostOf != nil && !v.allowed(p.RepostOf)) {
+			continue
+		}
+		if p.QuoteOf != nil && !v.allowed(p.QuoteOf) {
+			p.QuoteOf = nil
+		}
+		if p.RepostOf != nil && p.RepostOf.QuoteOf != nil && !v.allowed(p.RepostOf.QuoteOf) {
+			original := *p.RepostOf
+			original.QuoteOf = nil
+			p.RepostOf = &original
+		}
+		result = append(result, p)
+	}
+	return result
+}
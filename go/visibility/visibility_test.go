@@ -0,0 +1,202 @@
+package visibility
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shota0616/go-sns/config"
+	"github.com/Shota0616/go-sns/models"
+	"github.com/Shota0616/go-sns/testutil"
+)
+
+// 閲覧者と、閲覧者との関係がそれぞれ異なる投稿者
+type fixture struct {
+	viewer, blocked, muted, expired, followed, stranger models.User
+}
+
+func setup(t *testing.T) fixture {
+	testutil.Setup(t)
+	f := fixture{
+		viewer:   testutil.CreateUser(t, "viewer"),
+		blocked:  testutil.CreateUser(t, "blocked"),
+		muted:    testutil.CreateUser(t, "muted"),
+		expired:  testutil.CreateUser(t, "expired"),
+		followed: testutil.CreateUser(t, "followed"),
+		stranger: testutil.CreateUser(t, "stranger"),
+	}
+	testutil.Block(t, f.blocked.ID, f.viewer.ID)
+	testutil.Mute(t, f.viewer.ID, f.muted.ID, 0)
+	testutil.Mute(t, f.viewer.ID, f.expired.ID, -time.Minute)
+	testutil.Follow(t, f.viewer.ID, f.followed.ID)
+	return f
+}
+
+func post(t *testing.T, author models.User, visibility string) *models.Post {
+	p := testutil.CreatePost(t, models.Post{UserID: author.ID, Body: author.Username, Visibility: visibility})
+	p.User = author
+	return &p
+}
+
+func quote(t *testing.T, author models.User, quoted *models.Post) *models.Post {
+	p := testutil.CreatePost(t, models.Post{UserID: author.ID, Body: "quote", QuoteOfID: &quoted.ID})
+	p.User = author
+	p.QuoteOf = quoted
+	return &p
+}
+
+func repost(t *testing.T, author models.User, original *models.Post) *models.Post {
+	p := testutil.CreatePost(t, models.Post{UserID: author.ID, RepostOfID: &original.ID})
+	p.User = author
+	p.RepostOf = original
+	return &p
+}
+
+func mention(t *testing.T, p *models.Post, user models.User) {
+	if err := config.DB.Omit("User").Create(&models.Mention{PostID: p.ID, UserID: user.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestViewerCheck(t *testing.T) {
+	f := setup(t)
+	mentionedDirect := post(t, f.stranger, Direct)
+	mention(t, mentionedDirect, f.viewer)
+
+	tests := []struct {
+		name      string
+		post      *models.Post
+		want      bool
+		wantQuote bool
+	}{
+		{"公開の投稿", post(t, f.stranger, Public), true, false},
+		{"未収載の投稿", post(t, f.stranger, Unlisted), true, false},
+		{"ブロックしている相手の投稿", post(t, f.blocked, Public), false, false},
+		// ミュートは一覧にだけ影響する
+		{"ミュートしている相手の投稿", post(t, f.muted, Public), true, false},
+		{"期限切れのミュートの相手の投稿", post(t, f.expired, Public), true, false},
+		{"フォローしている相手のフォロワー限定の投稿", post(t, f.followed, Followers), true, false},
+		{"フォローしていない相手のフォロワー限定の投稿", post(t, f.stranger, Followers), false, false},
+		{"メンションされたダイレクトの投稿", mentionedDirect, true, false},
+		{"メンションされていないダイレクトの投稿", post(t, f.stranger, Direct), false, false},
+		{"自分のダイレクトの投稿", post(t, f.viewer, Direct), true, false},
+		{"ブロックしている相手の投稿のリポスト", repost(t, f.stranger, post(t, f.blocked, Public)), false, false},
+		{"見えない投稿のリポスト", repost(t, f.followed, post(t, f.stranger, Followers)), false, false},
+		{"ブロックしている相手の投稿の引用", quote(t, f.stranger, post(t, f.blocked, Public)), true, false},
+		{"見えない投稿の引用", quote(t, f.stranger, post(t, f.stranger, Direct)), true, false},
+		{"見える投稿の引用", quote(t, f.stranger, post(t, f.followed, Followers)), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := For(f.viewer.ID)
+			if err != nil {
+				t.Fatalf("For() error = %v", err)
+			}
+			p := *tt.post
+			if got := v.Check(&p); got != tt.want {
+				t.Fatalf("Check() = %v, want %v", got, tt.want)
+			}
+			if got := p.QuoteOf != nil; got != tt.wantQuote {
+				t.Errorf("Check() kept the quote = %v, want %v", got, tt.wantQuote)
+			}
+		})
+	}
+}
+
+func TestViewerCheckRepostOfQuote(t *testing.T) {
+	f := setup(t)
+	v, err := For(f.viewer.ID)
+	if err != nil {
+		t.Fatalf("For() error = %v", err)
+	}
+	original := quote(t, f.followed, post(t, f.blocked, Public))
+	p := *repost(t, f.stranger, original)
+	if !v.Check(&p) {
+		t.Fatal("Check() = false, want true")
+	}
+	if p.RepostOf.QuoteOf != nil {
+		t.Error("Check() kept the quote of a blocked user inside the repost")
+	}
+	if original.QuoteOf == nil {
+		t.Error("Check() modified the original reposted post")
+	}
+}
+
+func TestViewerCheckAnonymous(t *testing.T) {
+	f := setup(t)
+	v, err := For(0)
+	if err != nil {
+		t.Fatalf("For() error = %v", err)
+	}
+	tests := []struct {
+		post *models.Post
+		want bool
+	}{
+		{post(t, f.blocked, Public), true},
+		{post(t, f.followed, Unlisted), true},
+		{post(t, f.followed, Followers), false},
+		{post(t, f.followed, Direct), false},
+	}
+	for _, tt := range tests {
+		if got := v.Check(tt.post); got != tt.want {
+			t.Errorf("Check(%s) = %v, want %v", tt.post.Visibility, got, tt.want)
+		}
+	}
+}
+
+func TestViewerPosts(t *testing.T) {
+	f := setup(t)
+	blockedPost := post(t, f.blocked, Public)
+	mutedPost := post(t, f.muted, Public)
+	hiddenPost := post(t, f.stranger, Followers)
+	visiblePost := post(t, f.followed, Followers)
+
+	posts := []models.Post{
+		*post(t, f.stranger, Public),
+		*blockedPost,
+		*mutedPost,
+		*post(t, f.expired, Public),
+		*visiblePost,
+		*hiddenPost,
+		*repost(t, f.followed, blockedPost),
+		*repost(t, f.followed, mutedPost),
+		*repost(t, f.stranger, hiddenPost),
+		*quote(t, f.followed, blockedPost),
+		*quote(t, f.followed, mutedPost),
+		*quote(t, f.followed, hiddenPost),
+		*quote(t, f.followed, visiblePost),
+		*repost(t, f.stranger, quote(t, f.followed, blockedPost)),
+		*repost(t, f.stranger, quote(t, f.followed, hiddenPost)),
+	}
+	v, err := For(f.viewer.ID)
+	if err != nil {
+		t.Fatalf("For() error = %v", err)
+	}
+
+	type summary struct {
+		ID       uint
+		HasQuote bool
+	}
+	var got []summary
+	for _, p := range v.Posts(posts) {
+		quoted := p.QuoteOf
+		if p.RepostOf != nil {
+			quoted = p.RepostOf.QuoteOf
+		}
+		got = append(got, summary{ID: p.ID, HasQuote: quoted != nil})
+	}
+	want := []summary{
+		{ID: posts[0].ID},  // 公開の投稿
+		{ID: posts[3].ID},  // 期限切れのミュートの相手の投稿
+		{ID: posts[4].ID},  // フォローしている相手のフォロワー限定の投稿
+		{ID: posts[9].ID},  // ブロックしている相手の投稿の引用（引用元は外す）
+		{ID: posts[10].ID}, // ミュートしている相手の投稿の引用（引用元は外す）
+		{ID: posts[11].ID}, // 見えない投稿の引用（引用元は外す）
+		{ID: posts[12].ID, HasQuote: true},
+		{ID: posts[13].ID}, // ブロックしている相手の投稿を引用した投稿のリポスト
+		{ID: posts[14].ID}, // 見えない投稿を引用した投稿のリポスト
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Posts() = %+v, want %+v", got, want)
+	}
+}